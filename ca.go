@@ -0,0 +1,113 @@
+// Copyright (c) 2017 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"strconv"
+
+	"github.com/spf13/cobra"
+
+	"github.com/pulcy/gluon/pki"
+)
+
+var (
+	cmdCA = &cobra.Command{
+		Use:   "ca",
+		Short: "Manage the local cluster CA (used with --ca-mode=local)",
+		Run:   showUsage,
+	}
+	cmdCAInit = &cobra.Command{
+		Use:   "init",
+		Short: "Create the cluster CA in " + pki.CADir + " (existing files are kept)",
+		Run:   runCAInit,
+	}
+	cmdCAIssue = &cobra.Command{
+		Use:   "issue",
+		Short: "Issue a certificate signed by the cluster CA, unless the existing one is still valid",
+		Run:   runCAIssue,
+	}
+	caFlags struct {
+		Dir        string
+		CommonName string
+		AltNames   []string
+		IPSans     []string
+		CertPath   string
+		KeyPath    string
+		CAPath     string
+		FileMode   string
+	}
+)
+
+func init() {
+	cmdCAInit.Flags().StringVar(&caFlags.Dir, "dir", pki.CADir, "Directory the CA is created in")
+	cmdCAInit.Flags().StringVar(&caFlags.CommonName, "common-name", "gluon-ca", "Common name of the CA")
+
+	f := cmdCAIssue.Flags()
+	f.StringVar(&caFlags.CommonName, "common-name", "", "Common name of the certificate")
+	f.StringSliceVar(&caFlags.AltNames, "alt-name", nil, "DNS name of the certificate")
+	f.StringSliceVar(&caFlags.IPSans, "ip-san", nil, "IP address of the certificate")
+	f.StringVar(&caFlags.CertPath, "cert", "", "Path of the certificate")
+	f.StringVar(&caFlags.KeyPath, "key", "", "Path of the private key")
+	f.StringVar(&caFlags.CAPath, "ca", "", "Path of the CA certificate")
+	f.StringVar(&caFlags.FileMode, "file-mode", "0600", "Mode (octal) of the created files")
+
+	cmdCA.AddCommand(cmdCAInit)
+	cmdCA.AddCommand(cmdCAIssue)
+	cmdMain.AddCommand(cmdCA)
+}
+
+func runCAInit(cmd *cobra.Command, args []string) {
+	created, err := pki.InitDir(caFlags.Dir, caFlags.CommonName)
+	if err != nil {
+		Exitf("Cannot create CA: %v\n", err)
+	}
+	for _, p := range created {
+		log.Infof("Created %s", p)
+	}
+	if len(created) == 0 {
+		log.Infof("CA already exists in %s", caFlags.Dir)
+	}
+	log.Infof("Copy %s to %s on all machines of the cluster and run setup with --ca-mode=local", caFlags.Dir, pki.CADir)
+}
+
+func runCAIssue(cmd *cobra.Command, args []string) {
+	assertArgIsSet(caFlags.CommonName, "--common-name")
+	assertArgIsSet(caFlags.CertPath, "--cert")
+	assertArgIsSet(caFlags.KeyPath, "--key")
+	assertArgIsSet(caFlags.CAPath, "--ca")
+	mode, err := strconv.ParseUint(caFlags.FileMode, 8, 32)
+	if err != nil {
+		Exitf("Invalid file mode '%s': %v\n", caFlags.FileMode, err)
+	}
+	ca, err := pki.LoadCA(pki.CACertPath, pki.CAKeyPath)
+	if err != nil {
+		Exitf("Cannot load CA: %v\n", err)
+	}
+	req := pki.Request{
+		CommonName: caFlags.CommonName,
+		AltNames:   caFlags.AltNames,
+		IPSans:     caFlags.IPSans,
+	}
+	changed, err := ca.IssueFiles(req, caFlags.CertPath, caFlags.KeyPath, caFlags.CAPath, os.FileMode(mode))
+	if err != nil {
+		Exitf("Cannot issue certificate: %v\n", err)
+	}
+	if changed {
+		log.Infof("Issued %s", caFlags.CertPath)
+	} else {
+		log.Infof("%s is still valid", caFlags.CertPath)
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2017 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/pulcy/gluon/service"
+	"github.com/pulcy/gluon/util"
+)
+
+var (
+	cmdConfig = &cobra.Command{
+		Use:   "config",
+		Short: "Inspect the configuration of this node",
+		Run:   showUsage,
+	}
+	cmdConfigShow = &cobra.Command{
+		Use:   "show",
+		Short: "Print the configuration (including not yet imported legacy files) as JSON",
+		Run:   runConfigShow,
+	}
+	cmdConfigGet = &cobra.Command{
+		Use:   "get <key>",
+		Short: "Print a single configuration value (e.g. gluon-image, weave.seed)",
+		Run:   runConfigGet,
+	}
+	configOptions struct {
+		Root string
+	}
+)
+
+func init() {
+	cmdConfig.PersistentFlags().StringVar(&configOptions.Root, "root", "", "Read the configuration of the system found in this directory instead of the running system")
+	cmdConfig.AddCommand(cmdConfigShow)
+	cmdConfig.AddCommand(cmdConfigGet)
+	cmdMain.AddCommand(cmdConfig)
+}
+
+func runConfigShow(cmd *cobra.Command, args []string) {
+	cfg, legacyFiles, err := service.LoadConfig(util.NewTarget(log, configOptions.Root, nil))
+	if err != nil {
+		Exitf("Failed to load configuration: %#v\n", err)
+	}
+	for _, path := range legacyFiles {
+		log.Warningf("%s has not yet been imported into %s", path, service.ConfigPath)
+	}
+	content, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		Exitf("Failed to encode configuration: %#v\n", err)
+	}
+	fmt.Println(string(content))
+}
+
+func runConfigGet(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		Exitf("Expected exactly 1 key argument\n")
+	}
+	cfg, _, err := service.LoadConfig(util.NewTarget(log, configOptions.Root, nil))
+	if err != nil {
+		Exitf("Failed to load configuration: %#v\n", err)
+	}
+	value, err := configValue(cfg, args[0])
+	if err != nil {
+		Exitf("%v\n", err)
+	}
+	fmt.Println(value)
+}
+
+// configValue returns the value found at the given (dot separated) key in the given configuration.
+// Strings are returned as is, all other values are returned as JSON.
+func configValue(cfg *service.Config, key string) (string, error) {
+	raw, err := json.Marshal(cfg)
+	if err != nil {
+		return "", maskAny(err)
+	}
+	var value interface{}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return "", maskAny(err)
+	}
+	for _, part := range strings.Split(key, ".") {
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return "", maskAny(fmt.Errorf("Unknown key '%s'", key))
+		}
+		// Keys that are not set are left out of the JSON
+		value = obj[part]
+	}
+	switch value := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return value, nil
+	default:
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return "", maskAny(err)
+		}
+		return string(raw), nil
+	}
+}
@@ -17,8 +17,20 @@ package main
 import (
 	"os"
 	"strconv"
+	"strings"
+
+	"github.com/pulcy/gluon/util"
+)
+
+const (
+	coreosPrivateIPv4Key = "COREOS_PRIVATE_IPV4"
+	environmentPath      = "/etc/environment"
 )
 
+func defaultCAMode() string {
+	return os.Getenv("GLUON_CA_MODE")
+}
+
 func defaultEtcdUseVaultCA() bool {
 	return boolFromEnv("GLUON_ETCD_USE_VAULT_CA", false)
 }
@@ -35,6 +47,10 @@ func defaultKubernetesEnabled() bool {
 	return boolFromEnv("GLUON_K8S_ENABLED", true)
 }
 
+func defaultAutoReboot() bool {
+	return boolFromEnv("GLUON_AUTO_REBOOT", false)
+}
+
 func defaultKubernetesAPIDNSName() string {
 	return os.Getenv("GLUON_K8S_API_DNS_NAME")
 }
@@ -47,3 +63,22 @@ func boolFromEnv(key string, defaultValue bool) bool {
 	result, _ := strconv.ParseBool(x)
 	return result
 }
+
+// defaultPrivateIPv4 returns the private IP address of the given target, as found in
+// the COREOS_PRIVATE_IPV4 environment variable or in /etc/environment.
+func defaultPrivateIPv4(target *util.Target) string {
+	if x := os.Getenv(coreosPrivateIPv4Key); x != "" {
+		return x
+	}
+	content, err := target.ReadFile(environmentPath)
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) == 2 && strings.TrimSpace(parts[0]) == coreosPrivateIPv4Key {
+			return strings.TrimSpace(parts[1])
+		}
+	}
+	return ""
+}
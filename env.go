@@ -15,40 +15,29 @@
 package main
 
 import (
-	"io/ioutil"
 	"os"
-	"strings"
 	"sync"
-)
 
-const (
-	gluonEnvPath = "/etc/pulcy/gluon.env"
+	"github.com/pulcy/gluon/service"
+	"github.com/pulcy/gluon/util"
 )
 
 var (
 	loadEnvOnce sync.Once
 )
 
-// LoadEnv loads the environment file /etc/pulcy/gluon.env into the environment of this process.
+// LoadEnv loads the environment section of /etc/pulcy/gluon.json (and the legacy
+// /etc/pulcy/gluon.env) into the environment of this process.
+// Variables that are already set in the environment are left untouched.
 func LoadEnv() {
 	loadEnvOnce.Do(func() {
-		if content, err := ioutil.ReadFile(gluonEnvPath); os.IsNotExist(err) {
-			return
-		} else if err != nil {
-			Exitf("Failed to read %s: %#v", gluonEnvPath, err)
-		} else {
-			lines := strings.Split(string(content), "\n")
-			for _, line := range lines {
-				// Trim comments
-				parts := strings.SplitN(line, "#", 2)
-				line = strings.TrimSpace(parts[0])
-				// Split in key=value
-				parts = strings.SplitN(line, "=", 2)
-				if len(parts) == 2 {
-					key := strings.TrimSpace(parts[0])
-					value := strings.TrimSpace(parts[1])
-					os.Setenv(key, value)
-				}
+		cfg, _, err := service.LoadConfig(util.NewTarget(log, "", nil))
+		if err != nil {
+			Exitf("Failed to load configuration: %#v", err)
+		}
+		for key, value := range cfg.Environment {
+			if _, found := os.LookupEnv(key); !found {
+				os.Setenv(key, value)
 			}
 		}
 	})
@@ -0,0 +1,197 @@
+// Copyright (c) 2017 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/pulcy/gluon/service"
+	"github.com/pulcy/gluon/service/etcd"
+	"github.com/pulcy/gluon/update"
+	"github.com/pulcy/gluon/util"
+)
+
+var (
+	cmdEtcd = &cobra.Command{
+		Use:   "etcd",
+		Short: "Manage etcd",
+		Run:   showUsage,
+	}
+	cmdEtcdBackup = &cobra.Command{
+		Use:   "backup",
+		Short: "Take a snapshot of the etcd data of this machine",
+		Run:   runEtcdBackup,
+	}
+	cmdEtcdRestore = &cobra.Command{
+		Use:   "restore <snapshot>",
+		Short: "Restore a snapshot as a new single member etcd cluster",
+		Run:   runEtcdRestore,
+	}
+	cmdEtcdMaintain = &cobra.Command{
+		Use:   "maintain",
+		Short: "Compact & defragment etcd and disarm NOSPACE alarms (only acts on the leader)",
+		Run:   runEtcdMaintain,
+	}
+	cmdEtcdStatus = &cobra.Command{
+		Use:   "status",
+		Short: "Show the database size & alarms of all etcd peers",
+		Run:   runEtcdStatus,
+	}
+	cmdEtcdMigrate = &cobra.Command{
+		Use:   "migrate",
+		Short: "Migrate all machines from etcd v2 to etcd v3",
+		Long:  "Migrate all machines from etcd v2 to etcd v3. All etcd peers are stopped while their data is migrated. Run it again to resume an interrupted migration.",
+		Run:   runEtcdMigrate,
+	}
+	cmdEtcdSetVersion = &cobra.Command{
+		Use:   "set-version <2|3>",
+		Short: "Set the major etcd version in the configuration of this machine",
+		Run:   runEtcdSetVersion,
+	}
+	etcdFlags     = &service.ServiceFlags{}
+	migrateFlags  = &update.MigrateFlags{}
+	migrateStatus bool
+)
+
+func init() {
+	for _, c := range []*cobra.Command{cmdEtcdBackup, cmdEtcdRestore, cmdEtcdMaintain, cmdEtcdStatus} {
+		addServiceFlags(c, etcdFlags)
+	}
+
+	cmdEtcdMigrate.Flags().StringVar(&migrateFlags.StatePath, "state", "", "File where the progress of the migration is recorded (default $HOME/.gluon/etcd-migrate.json)")
+	cmdEtcdMigrate.Flags().BoolVar(&migrateStatus, "status", false, "If set, the progress of the migration is shown")
+	cmdEtcdMigrate.Flags().BoolVar(&migrateFlags.AskConfirmation, "confirm", true, "If set, confirmation is needed before etcd is stopped")
+	addSSHFlags(cmdEtcdMigrate.Flags(), &migrateFlags.SSH)
+
+	cmdEtcd.AddCommand(cmdEtcdBackup)
+	cmdEtcd.AddCommand(cmdEtcdRestore)
+	cmdEtcd.AddCommand(cmdEtcdMaintain)
+	cmdEtcd.AddCommand(cmdEtcdStatus)
+	cmdEtcd.AddCommand(cmdEtcdMigrate)
+	cmdEtcd.AddCommand(cmdEtcdSetVersion)
+	cmdMain.AddCommand(cmdEtcd)
+}
+
+// setupEtcdFlags fills the etcd flags with defaults for this machine.
+func setupEtcdFlags(target *util.Target) {
+	if etcdFlags.Network.ClusterIP == "" {
+		etcdFlags.Network.ClusterIP = defaultPrivateIPv4(target)
+	}
+	if etcdFlags.Docker.DockerIP == "" {
+		etcdFlags.Docker.DockerIP = etcdFlags.Network.ClusterIP
+	}
+	if err := etcdFlags.SetupDefaults(target); err != nil {
+		Exitf("SetupDefaults failed: %#v\n", err)
+	}
+	assertArgIsSet(etcdFlags.Network.ClusterIP, "--private-ip")
+}
+
+// newEtcdDependencies fills the etcd flags with defaults for this machine and
+// returns the dependencies needed to run etcd commands on it.
+func newEtcdDependencies() service.ServiceDependencies {
+	target := util.NewTarget(log, "", nil)
+	setupEtcdFlags(target)
+	return service.ServiceDependencies{
+		Systemd: newSystemdClient(target),
+		Logger:  log,
+		Target:  target,
+	}
+}
+
+func runEtcdBackup(cmd *cobra.Command, args []string) {
+	deps := newEtcdDependencies()
+	defer deps.Systemd.Close()
+	if _, err := etcd.Backup(deps, etcdFlags); err != nil {
+		Exitf("Backup failed: %v\n", err)
+	}
+}
+
+func runEtcdRestore(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		Exitf("Usage: gluon etcd restore <snapshot>\n")
+	}
+	deps := newEtcdDependencies()
+	defer deps.Systemd.Close()
+	if err := etcd.Restore(deps, etcdFlags, args[0]); err != nil {
+		Exitf("Restore failed: %v\n", err)
+	}
+}
+
+func runEtcdMaintain(cmd *cobra.Command, args []string) {
+	setupEtcdFlags(util.NewTarget(log, "", nil))
+	if err := etcd.Maintain(etcdFlags, log); err != nil {
+		Exitf("Maintenance failed: %v\n", err)
+	}
+}
+
+func runEtcdStatus(cmd *cobra.Command, args []string) {
+	setupEtcdFlags(util.NewTarget(log, "", nil))
+	peers, alarms, err := etcd.Status(etcdFlags, log)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "PEER\tMEMBER\tLEADER\tREVISION\tDB SIZE")
+	for _, p := range peers {
+		if p.Status == nil {
+			fmt.Fprintf(tw, "%s\t-\t-\t-\tunreachable: %v\n", p.ClusterIP, p.Error)
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%x\t%v\t%d\t%d\n", p.ClusterIP, p.Status.MemberID, p.Status.IsLeader(), p.Status.Revision, p.Status.DBSize)
+	}
+	tw.Flush()
+	if err != nil {
+		Exitf("Cannot load alarms: %v\n", err)
+	}
+	for _, a := range alarms {
+		fmt.Printf("ALARM %s on member %x\n", a.Alarm, a.MemberID)
+	}
+}
+
+func runEtcdMigrate(cmd *cobra.Command, args []string) {
+	if err := migrateFlags.SetupDefaults(log); err != nil {
+		Exitf("SetupDefaults failed: %#v\n", err)
+	}
+	if migrateStatus {
+		state, err := update.LoadMigrateState(migrateFlags.StatePath)
+		if err != nil {
+			Exitf("Cannot load migration state: %#v\n", err)
+		}
+		fmt.Println(state.String())
+		return
+	}
+	if err := update.MigrateEtcd(migrateFlags, log); err != nil {
+		Exitf("Migration failed: %v\n", err)
+	}
+}
+
+func runEtcdSetVersion(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		Exitf("Usage: gluon etcd set-version <2|3>\n")
+	}
+	version, err := strconv.Atoi(args[0])
+	if err != nil || (version != 2 && version != 3) {
+		Exitf("Invalid etcd version '%s', expected 2 or 3\n", args[0])
+	}
+	target := util.NewTarget(log, "", nil)
+	if _, err := service.UpdateConfig(target, func(cfg *service.Config) error {
+		cfg.Etcd.Version = version
+		return nil
+	}); err != nil {
+		Exitf("Failed to update configuration: %#v\n", err)
+	}
+}
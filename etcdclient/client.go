@@ -0,0 +1,246 @@
+// Copyright (c) 2017 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package etcdclient is a minimal client for the ETCD v2 HTTP API and the
+// maintenance part of the v3 API. It only implements the parts of the API gluon needs.
+package etcdclient
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/juju/errgo"
+)
+
+var (
+	maskAny = errgo.MaskFunc(errgo.Any)
+)
+
+const (
+	defaultTimeout = time.Second * 10
+
+	// Error codes of the ETCD v2 keys API
+	errorCodeKeyNotFound  = 100
+	errorCodeTestFailed   = 101
+	errorCodeNodeExist    = 105
+	errorCodeRaftInternal = 300
+)
+
+// Client talks to an ETCD cluster using the v2 HTTP API.
+// Requests are sent to the first endpoint that responds.
+type Client struct {
+	endpoints []string
+	client    *http.Client
+}
+
+// NewClient creates a client for the given endpoints (e.g. http://10.0.0.1:2379).
+// If tlsConfig is not nil, it is used for https endpoints.
+func NewClient(endpoints []string, tlsConfig *tls.Config) *Client {
+	transport := &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: tlsConfig,
+	}
+	return &Client{
+		endpoints: endpoints,
+		client:    &http.Client{Transport: transport, Timeout: defaultTimeout},
+	}
+}
+
+// Endpoints returns the endpoints of the client.
+func (c *Client) Endpoints() []string {
+	return c.endpoints
+}
+
+// Error is returned when ETCD answers a request with an error.
+type Error struct {
+	ErrorCode  int    `json:"errorCode"`
+	Message    string `json:"message"`
+	Cause      string `json:"cause"`
+	StatusCode int    `json:"-"`
+}
+
+func (e *Error) Error() string {
+	if e.Cause != "" {
+		return fmt.Sprintf("%s (%s)", e.Message, e.Cause)
+	}
+	if e.Message != "" {
+		return e.Message
+	}
+	return fmt.Sprintf("status %d", e.StatusCode)
+}
+
+// IsKeyNotFound returns true if the given error indicates that a key does not exist.
+func IsKeyNotFound(err error) bool {
+	e, ok := errgo.Cause(err).(*Error)
+	return ok && e.ErrorCode == errorCodeKeyNotFound
+}
+
+// IsTestFailed returns true if the given error indicates that a compare-and-swap failed.
+func IsTestFailed(err error) bool {
+	e, ok := errgo.Cause(err).(*Error)
+	return ok && e.ErrorCode == errorCodeTestFailed
+}
+
+// IsNodeExist returns true if the given error indicates that a key already exists.
+func IsNodeExist(err error) bool {
+	e, ok := errgo.Cause(err).(*Error)
+	return ok && e.ErrorCode == errorCodeNodeExist
+}
+
+// Node is a key (or directory) in the v2 keys API.
+type Node struct {
+	Key           string `json:"key"`
+	Value         string `json:"value,omitempty"`
+	Dir           bool   `json:"dir,omitempty"`
+	Nodes         []Node `json:"nodes,omitempty"`
+	ModifiedIndex uint64 `json:"modifiedIndex"`
+	CreatedIndex  uint64 `json:"createdIndex"`
+}
+
+type keysResponse struct {
+	Action string `json:"action"`
+	Node   Node   `json:"node"`
+}
+
+// Get returns the node with given key.
+func (c *Client) Get(key string, recursive bool) (*Node, error) {
+	q := url.Values{}
+	if recursive {
+		q.Set("recursive", "true")
+	}
+	var resp keysResponse
+	if err := c.do("GET", keysPath(key), q, nil, &resp); err != nil {
+		return nil, maskAny(err)
+	}
+	return &resp.Node, nil
+}
+
+// Set sets the value of the given key.
+// If ttl is not zero, the key expires after that duration.
+func (c *Client) Set(key, value string, ttl time.Duration) (*Node, error) {
+	return c.put(key, value, ttl, url.Values{})
+}
+
+// Create sets the value of the given key, failing if the key already exists.
+func (c *Client) Create(key, value string, ttl time.Duration) (*Node, error) {
+	return c.put(key, value, ttl, url.Values{"prevExist": {"false"}})
+}
+
+// CompareAndSwap sets the value of the given key, failing if the key has been
+// modified after the given index.
+func (c *Client) CompareAndSwap(key, value string, ttl time.Duration, prevIndex uint64) (*Node, error) {
+	return c.put(key, value, ttl, url.Values{"prevIndex": {strconv.FormatUint(prevIndex, 10)}})
+}
+
+// Delete removes the given key.
+func (c *Client) Delete(key string) error {
+	return maskAny(c.do("DELETE", keysPath(key), nil, nil, nil))
+}
+
+func (c *Client) put(key, value string, ttl time.Duration, q url.Values) (*Node, error) {
+	form := url.Values{"value": {value}}
+	if ttl > 0 {
+		form.Set("ttl", strconv.Itoa(int(ttl/time.Second)))
+	}
+	var resp keysResponse
+	if err := c.do("PUT", keysPath(key), q, form, &resp); err != nil {
+		return nil, maskAny(err)
+	}
+	return &resp.Node, nil
+}
+
+func keysPath(key string) string {
+	return path.Join("/v2/keys", key)
+}
+
+// do sends a request to the first endpoint that can be reached and decodes
+// the JSON response into result (if not nil).
+func (c *Client) do(method, urlPath string, query url.Values, form url.Values, result interface{}) error {
+	if len(c.endpoints) == 0 {
+		return maskAny(fmt.Errorf("No ETCD endpoints"))
+	}
+	var lastErr error
+	for _, ep := range c.endpoints {
+		resp, err := c.send(ep, method, urlPath, query, form)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		err = decodeResponse(resp, result)
+		resp.Body.Close()
+		if e, ok := errgo.Cause(err).(*Error); ok && e.ErrorCode == errorCodeRaftInternal {
+			// This member cannot serve the request (e.g. no leader), try the next one
+			lastErr = err
+			continue
+		}
+		return maskAny(err)
+	}
+	return maskAny(lastErr)
+}
+
+// send performs a single request on the given endpoint.
+func (c *Client) send(endpoint, method, urlPath string, query url.Values, form url.Values) (*http.Response, error) {
+	u := strings.TrimSuffix(endpoint, "/") + urlPath
+	if len(query) > 0 {
+		u = u + "?" + query.Encode()
+	}
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	return resp, nil
+}
+
+// decodeResponse decodes a successful response into result, or turns
+// an unsuccessful response into an *Error.
+func decodeResponse(resp *http.Response, result interface{}) error {
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return maskAny(err)
+	}
+	if resp.StatusCode >= 300 {
+		e := &Error{StatusCode: resp.StatusCode}
+		if err := json.Unmarshal(content, e); err != nil || e.Message == "" {
+			e.Message = strings.TrimSpace(string(content))
+		}
+		return maskAny(e)
+	}
+	if result == nil || len(content) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(content, result); err != nil {
+		return maskAny(fmt.Errorf("Cannot decode response: %v", err))
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package etcdclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestClientErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"errorCode":100,"message":"Key not found","cause":"/foo"}`)
+	}))
+	defer server.Close()
+
+	// The first endpoint cannot be reached, so the second one must be used
+	c := NewClient([]string{"http://127.0.0.1:1", server.URL}, nil)
+	_, err := c.Get("/foo", false)
+	if !IsKeyNotFound(err) {
+		t.Fatalf("Expected key not found, got %v", err)
+	}
+	if IsTestFailed(err) || IsNodeExist(err) {
+		t.Errorf("Unexpected error kind %v", err)
+	}
+}
+
+func TestMembers(t *testing.T) {
+	var added []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			fmt.Fprint(w, `{"members":[{"id":"a1","name":"m1","peerURLs":["https://10.0.0.1:2380"],"clientURLs":["http://10.0.0.1:2379"]},{"id":"b2","peerURLs":["https://10.0.0.2:2380"]}]}`)
+		case "POST":
+			var req struct {
+				PeerURLs []string `json:"peerURLs"`
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+			added = req.PeerURLs
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprint(w, `{"id":"c3","peerURLs":["https://10.0.0.3:2380"]}`)
+		}
+	}))
+	defer server.Close()
+
+	c := NewClient([]string{server.URL}, nil)
+	members, err := c.Members()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 2 || !members[0].Started() || members[1].Started() || !members[1].HasPeerURL("https://10.0.0.2:2380") {
+		t.Errorf("Unexpected members %+v", members)
+	}
+	m, err := c.AddMember([]string{"https://10.0.0.3:2380"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.ID != "c3" || !reflect.DeepEqual(added, []string{"https://10.0.0.3:2380"}) {
+		t.Errorf("Unexpected member %+v, added %v", m, added)
+	}
+}
+
+func TestMaintenance(t *testing.T) {
+	var disarm struct {
+		Action   int    `json:"action"`
+		MemberID string `json:"memberID"`
+		Alarm    int    `json:"alarm"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v3alpha/maintenance/status":
+			fmt.Fprint(w, `{"header":{"member_id":"12345","revision":"678"},"version":"3.1.5","dbSize":"2048","leader":"12345"}`)
+		case "/v3alpha/maintenance/alarm":
+			json.NewDecoder(r.Body).Decode(&disarm)
+			fmt.Fprint(w, `{"alarms":[{"memberID":"12345","alarm":"NOSPACE"}]}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	c := NewClient([]string{server.URL}, nil)
+	s, err := c.MemberStatus(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.IsLeader() || s.Revision != 678 || s.DBSize != 2048 || s.Version != "3.1.5" {
+		t.Errorf("Unexpected status %+v", s)
+	}
+	alarms, err := c.Alarms()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(alarms) != 1 || alarms[0].MemberID != 12345 || alarms[0].Alarm != AlarmNoSpace {
+		t.Fatalf("Unexpected alarms %+v", alarms)
+	}
+	if err := c.DisarmAlarm(alarms[0]); err != nil {
+		t.Fatal(err)
+	}
+	if disarm.Action != alarmActionDeactivate || disarm.MemberID != "12345" || disarm.Alarm != 1 {
+		t.Errorf("Unexpected disarm request %+v", disarm)
+	}
+}
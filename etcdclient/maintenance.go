@@ -0,0 +1,220 @@
+// Copyright (c) 2017 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/juju/errgo"
+)
+
+// The maintenance requests use the JSON gateway of the ETCD v3 API.
+const (
+	v3Prefix = "/v3alpha"
+
+	alarmActionGet        = 0
+	alarmActionDeactivate = 2
+
+	// AlarmNoSpace is raised when the backend database of a member exceeds its quota.
+	// Until it is disarmed, the cluster only accepts reads and deletes.
+	AlarmNoSpace = "NOSPACE"
+
+	defragTimeout = time.Minute * 5
+)
+
+// Status is the status of a single ETCD member.
+type Status struct {
+	MemberID  uint64
+	Leader    uint64
+	Revision  int64
+	DBSize    int64 // Size of the backend database in bytes
+	Version   string
+	RaftIndex uint64
+}
+
+// IsLeader returns true if the member is the leader of the cluster.
+func (s Status) IsLeader() bool {
+	return s.MemberID != 0 && s.MemberID == s.Leader
+}
+
+// Alarm is an alarm raised by a member.
+type Alarm struct {
+	MemberID uint64
+	Alarm    string
+}
+
+// jsonNumber decodes an integer that is encoded as JSON number or as JSON string
+// (the v3 gateway encodes 64-bit integers as strings).
+type jsonNumber string
+
+func (n *jsonNumber) UnmarshalJSON(data []byte) error {
+	*n = jsonNumber(strings.Trim(string(data), `"`))
+	return nil
+}
+
+func (n jsonNumber) uint64() uint64 {
+	v, _ := strconv.ParseUint(string(n), 10, 64)
+	return v
+}
+
+func (n jsonNumber) int64() int64 {
+	v, _ := strconv.ParseInt(string(n), 10, 64)
+	return v
+}
+
+// alarmName decodes an alarm type that is encoded as name or as number.
+type alarmName string
+
+func (a *alarmName) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	switch s {
+	case "0":
+		s = "NONE"
+	case "1":
+		s = AlarmNoSpace
+	}
+	*a = alarmName(s)
+	return nil
+}
+
+type responseHeader struct {
+	MemberID jsonNumber `json:"member_id"`
+	Revision jsonNumber `json:"revision"`
+	RaftTerm jsonNumber `json:"raft_term"`
+}
+
+// MemberStatus returns the status of the member at the given endpoint.
+func (c *Client) MemberStatus(endpoint string) (*Status, error) {
+	var resp struct {
+		Header    responseHeader `json:"header"`
+		Version   string         `json:"version"`
+		DBSize    jsonNumber     `json:"dbSize"`
+		Leader    jsonNumber     `json:"leader"`
+		RaftIndex jsonNumber     `json:"raftIndex"`
+	}
+	if err := c.post(endpoint, "/maintenance/status", struct{}{}, &resp, 0); err != nil {
+		return nil, maskAny(err)
+	}
+	return &Status{
+		MemberID:  resp.Header.MemberID.uint64(),
+		Leader:    resp.Leader.uint64(),
+		Revision:  resp.Header.Revision.int64(),
+		DBSize:    resp.DBSize.int64(),
+		Version:   resp.Version,
+		RaftIndex: resp.RaftIndex.uint64(),
+	}, nil
+}
+
+// Alarms returns all alarms raised in the cluster.
+func (c *Client) Alarms() ([]Alarm, error) {
+	return c.alarm(alarmActionGet, 0, "")
+}
+
+// DisarmAlarm deactivates the given alarm of the member with given ID.
+func (c *Client) DisarmAlarm(a Alarm) error {
+	_, err := c.alarm(alarmActionDeactivate, a.MemberID, a.Alarm)
+	return maskAny(err)
+}
+
+func (c *Client) alarm(action int, memberID uint64, alarm string) ([]Alarm, error) {
+	req := struct {
+		Action   int    `json:"action"`
+		MemberID string `json:"memberID,omitempty"`
+		Alarm    int    `json:"alarm,omitempty"`
+	}{Action: action}
+	if memberID != 0 {
+		req.MemberID = strconv.FormatUint(memberID, 10)
+	}
+	if alarm == AlarmNoSpace {
+		req.Alarm = 1
+	}
+	var resp struct {
+		Alarms []struct {
+			MemberID jsonNumber `json:"memberID"`
+			Alarm    alarmName  `json:"alarm"`
+		} `json:"alarms"`
+	}
+	if err := c.postAny("/maintenance/alarm", req, &resp); err != nil {
+		return nil, maskAny(err)
+	}
+	var result []Alarm
+	for _, a := range resp.Alarms {
+		result = append(result, Alarm{MemberID: a.MemberID.uint64(), Alarm: string(a.Alarm)})
+	}
+	return result, nil
+}
+
+// Compact removes all key revisions older than the given revision from the v3 store.
+// The compaction is physical, so the space can be reclaimed by a defragmentation afterwards.
+func (c *Client) Compact(revision int64) error {
+	req := struct {
+		Revision string `json:"revision"`
+		Physical bool   `json:"physical"`
+	}{strconv.FormatInt(revision, 10), true}
+	return maskAny(c.postAny("/kv/compaction", req, nil))
+}
+
+// Defragment releases the free space in the backend database of the member at the given endpoint.
+// The member cannot serve requests while it is being defragmented.
+func (c *Client) Defragment(endpoint string) error {
+	return maskAny(c.post(endpoint, "/maintenance/defragment", struct{}{}, nil, defragTimeout))
+}
+
+// postAny sends a v3 request to the first endpoint that can be reached.
+func (c *Client) postAny(urlPath string, body, result interface{}) error {
+	if len(c.endpoints) == 0 {
+		return maskAny(fmt.Errorf("No ETCD endpoints"))
+	}
+	var lastErr error
+	for _, ep := range c.endpoints {
+		err := c.post(ep, urlPath, body, result, 0)
+		if _, ok := errgo.Cause(err).(*Error); ok || err == nil {
+			// ETCD answered the request
+			return maskAny(err)
+		}
+		lastErr = err
+	}
+	return maskAny(lastErr)
+}
+
+// post sends a v3 request to the given endpoint.
+// If timeout is not 0, it replaces the default timeout of the client.
+func (c *Client) post(endpoint, urlPath string, body, result interface{}, timeout time.Duration) error {
+	content, err := json.Marshal(body)
+	if err != nil {
+		return maskAny(err)
+	}
+	req, err := http.NewRequest("POST", strings.TrimSuffix(endpoint, "/")+v3Prefix+urlPath, bytes.NewReader(content))
+	if err != nil {
+		return maskAny(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := c.client
+	if timeout != 0 {
+		client = &http.Client{Transport: c.client.Transport, Timeout: timeout}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return maskAny(err)
+	}
+	defer resp.Body.Close()
+	return maskAny(decodeResponse(resp, result))
+}
@@ -0,0 +1,131 @@
+// Copyright (c) 2017 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Member is a peer of the ETCD cluster, as returned by the v2 members API.
+type Member struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	PeerURLs   []string `json:"peerURLs"`
+	ClientURLs []string `json:"clientURLs"`
+}
+
+// Started returns true if the member has joined the cluster.
+// A member that has been added but not started yet has no name.
+func (m Member) Started() bool {
+	return m.Name != ""
+}
+
+// HasPeerURL returns true if the member has the given peer URL.
+func (m Member) HasPeerURL(url string) bool {
+	for _, u := range m.PeerURLs {
+		if u == url {
+			return true
+		}
+	}
+	return false
+}
+
+// Members returns all members of the cluster.
+func (c *Client) Members() ([]Member, error) {
+	var resp struct {
+		Members []Member `json:"members"`
+	}
+	if err := c.do("GET", "/v2/members", nil, nil, &resp); err != nil {
+		return nil, maskAny(err)
+	}
+	return resp.Members, nil
+}
+
+// AddMember adds a member with given peer URLs to the cluster.
+// The new member must be started with initial cluster state `existing` afterwards.
+func (c *Client) AddMember(peerURLs []string) (*Member, error) {
+	body, err := json.Marshal(struct {
+		PeerURLs []string `json:"peerURLs"`
+	}{peerURLs})
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	var m Member
+	if err := c.doJSON("POST", "/v2/members", body, &m); err != nil {
+		return nil, maskAny(err)
+	}
+	return &m, nil
+}
+
+// UpdateMember changes the peer URLs of the member with given ID.
+func (c *Client) UpdateMember(id string, peerURLs []string) error {
+	body, err := json.Marshal(struct {
+		PeerURLs []string `json:"peerURLs"`
+	}{peerURLs})
+	if err != nil {
+		return maskAny(err)
+	}
+	return maskAny(c.doJSON("PUT", "/v2/members/"+id, body, nil))
+}
+
+// RemoveMember removes the member with given ID from the cluster.
+func (c *Client) RemoveMember(id string) error {
+	return maskAny(c.doJSON("DELETE", "/v2/members/"+id, nil, nil))
+}
+
+// Healthy returns nil if the cluster has a leader and can commit proposals,
+// as reported by the /health endpoint of the first endpoint that responds.
+func (c *Client) Healthy() error {
+	var resp struct {
+		Health string `json:"health"`
+	}
+	if err := c.do("GET", "/health", nil, nil, &resp); err != nil {
+		return maskAny(err)
+	}
+	if resp.Health != "true" {
+		return maskAny(fmt.Errorf("ETCD cluster is not healthy"))
+	}
+	return nil
+}
+
+// doJSON sends a request with a JSON body to the first endpoint that can be reached.
+func (c *Client) doJSON(method, urlPath string, body []byte, result interface{}) error {
+	if len(c.endpoints) == 0 {
+		return maskAny(fmt.Errorf("No ETCD endpoints"))
+	}
+	var lastErr error
+	for _, ep := range c.endpoints {
+		req, err := http.NewRequest(method, strings.TrimSuffix(ep, "/")+urlPath, bytes.NewReader(body))
+		if err != nil {
+			return maskAny(err)
+		}
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		resp, err := c.client.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		err = decodeResponse(resp, result)
+		resp.Body.Close()
+		return maskAny(err)
+	}
+	return maskAny(lastErr)
+}
@@ -15,11 +15,23 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
 
 	"github.com/juju/errgo"
-	"github.com/pulcy/gluon/service"
 	"github.com/spf13/cobra"
+
+	"github.com/pulcy/gluon/service"
+	"github.com/pulcy/gluon/update"
+	"github.com/pulcy/gluon/util"
+)
+
+const (
+	defaultCheckTimeout = time.Second * 5
 )
 
 var (
@@ -28,16 +40,64 @@ var (
 		Run: showUsage,
 	}
 	cmdMemberList = &cobra.Command{
-		Use: "list",
-		Run: runMemberList,
+		Use:   "list",
+		Short: "List all cluster members",
+		Run:   runMemberList,
+	}
+	cmdMemberAdd = &cobra.Command{
+		Use:   "add <machine-id> <ip>",
+		Short: "Add a member to the cluster members of all machines and run setup on them",
+		Run:   runMemberAdd,
+	}
+	cmdMemberRemove = &cobra.Command{
+		Use:   "remove <machine-id|ip>",
+		Short: "Remove a member from the cluster members of all other machines and run setup on them",
+		Run:   runMemberRemove,
+	}
+	cmdMemberKeyscan = &cobra.Command{
+		Use:   "keyscan",
+		Short: "Add the SSH host keys of all members to the known hosts file",
+		Long:  "Add the SSH host keys of all members to the known hosts file (--known-hosts or ~/.ssh/known_hosts). The keys are not verified, so only run this on a trusted network.",
+		Run:   runMemberKeyscan,
+	}
+	cmdMemberReplace = &cobra.Command{
+		Use:   "replace",
+		Short: "Replace the cluster members of this machine with the JSON list read from stdin",
+		Run:   runMemberReplace,
+	}
+	memberFlags   = &update.MemberFlags{}
+	memberOptions struct {
+		service.ClusterMember
+	}
+	listOptions struct {
+		Output       string
+		Check        bool
+		CheckTimeout time.Duration
 	}
 
 	maskAny = errgo.MaskFunc(errgo.Any)
 )
 
 func init() {
+	addSSHFlags(cmdMember.PersistentFlags(), &memberFlags.SSH)
+	cmdMember.PersistentFlags().BoolVar(&memberFlags.NoSetup, "no-setup", false, "If set, gluon setup is not run on the machines")
+	cmdMemberList.Flags().StringVarP(&listOptions.Output, "output", "o", "table", "Output format: table|json|yaml|ips")
+	cmdMemberList.Flags().BoolVar(&listOptions.Check, "check", false, "Probe etcd, consul, kubelet & SSH of every member")
+	cmdMemberList.Flags().DurationVar(&listOptions.CheckTimeout, "check-timeout", defaultCheckTimeout, "Timeout of a single probe")
+	cmdMemberList.Flags().StringVar(&memberFlags.Network.ClusterIP, "private-ip", "", "IP address of this host in the cluster network")
+	cmdMemberList.Flags().BoolVar(&memberFlags.Kubernetes.Enabled, "k8s-enabled", defaultKubernetesEnabled(), "If set, kubelet is probed")
+	cmdMemberAdd.Flags().BoolVar(&memberOptions.EtcdProxy, "etcd-proxy", false, "If set, the member runs ETCD as proxy")
+	cmdMemberAdd.Flags().StringVar(&memberOptions.PrivateHostIP, "private-host-ip", "", "IP address of the member host (defaults to ip)")
+	cmdMemberAdd.Flags().StringVar(&memberOptions.Hostname, "hostname", "", "Hostname of the member")
+	cmdMemberAdd.Flags().StringVar(&memberOptions.Zone, "zone", "", "Failure domain of the member")
+	cmdMemberAdd.Flags().StringSliceVar(&memberOptions.Roles, "roles", nil, "Roles of the member (comma separated)")
+
 	cmdMain.AddCommand(cmdMember)
 	cmdMember.AddCommand(cmdMemberList)
+	cmdMember.AddCommand(cmdMemberAdd)
+	cmdMember.AddCommand(cmdMemberRemove)
+	cmdMember.AddCommand(cmdMemberKeyscan)
+	cmdMember.AddCommand(cmdMemberReplace)
 }
 
 func runMemberList(cmd *cobra.Command, args []string) {
@@ -47,8 +107,12 @@ func runMemberList(cmd *cobra.Command, args []string) {
 }
 
 func listMembers() error {
-	flags := service.ServiceFlags{}
-	if err := flags.SetupDefaults(log); err != nil {
+	target := util.NewTarget(log, "", nil)
+	flags := &memberFlags.ServiceFlags
+	if flags.Network.ClusterIP == "" {
+		flags.Network.ClusterIP = defaultPrivateIPv4(target)
+	}
+	if err := flags.SetupDefaults(target); err != nil {
 		return maskAny(err)
 	}
 	// Get all members
@@ -56,8 +120,204 @@ func listMembers() error {
 	if err != nil {
 		return maskAny(err)
 	}
+	infos := make([]memberInfo, 0, len(members))
 	for _, m := range members {
-		fmt.Println(m.ClusterIP)
+		etcd := "proxy"
+		if m.HasRole(service.RoleEtcd) {
+			etcd = "peer"
+		}
+		infos = append(infos, memberInfo{
+			MachineID:     m.MachineID,
+			ClusterIP:     m.ClusterIP,
+			PrivateHostIP: m.PrivateHostIP,
+			Etcd:          etcd,
+			Local:         m.ClusterIP == flags.Network.ClusterIP,
+		})
+	}
+	healthy := true
+	if listOptions.Check {
+		healths := update.CheckMembers(members, update.CheckFlags{
+			SSH:               memberFlags.SSH,
+			EtcdClientPort:    flags.Etcd.ClientPort,
+			KubernetesEnabled: flags.Kubernetes.IsEnabled(),
+			Timeout:           listOptions.CheckTimeout,
+		})
+		for i := range infos {
+			infos[i].Health = &healths[i]
+			healthy = healthy && healths[i].Healthy()
+		}
+	}
+
+	switch listOptions.Output {
+	case "ips":
+		for _, m := range infos {
+			fmt.Println(m.ClusterIP)
+		}
+	case "table":
+		printMemberTable(os.Stdout, infos)
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(infos); err != nil {
+			return maskAny(err)
+		}
+	case "yaml":
+		if err := printMemberYAML(os.Stdout, infos); err != nil {
+			return maskAny(err)
+		}
+	default:
+		return maskAny(fmt.Errorf("Unknown output format '%s', expected table, json, yaml or ips", listOptions.Output))
+	}
+	if !healthy {
+		os.Exit(1)
+	}
+	return nil
+}
+
+// memberInfo is the information about a cluster member shown by `member list`.
+type memberInfo struct {
+	MachineID     string               `json:"machine-id"`
+	ClusterIP     string               `json:"cluster-ip"`
+	PrivateHostIP string               `json:"private-host-ip"`
+	Etcd          string               `json:"etcd"` // peer|proxy
+	Local         bool                 `json:"local"`
+	Health        *update.MemberHealth `json:"health,omitempty"`
+}
+
+// printMemberTable writes a table of the given members to the given writer.
+// The local member is marked with a '*'.
+func printMemberTable(w io.Writer, infos []memberInfo) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	header := "\tMACHINE-ID\tCLUSTER-IP\tPRIVATE-HOST-IP\tETCD"
+	if listOptions.Check {
+		header += "\tETCD-PORT\tCONSUL\tKUBELET\tSSH"
+	}
+	fmt.Fprintln(tw, header)
+	for _, m := range infos {
+		local := ""
+		if m.Local {
+			local = "*"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s", local, m.MachineID, m.ClusterIP, m.PrivateHostIP, m.Etcd)
+		if h := m.Health; h != nil {
+			fmt.Fprintf(tw, "\t%s\t%s\t%s\t%s", healthCell(h.Etcd), healthCell(h.Consul), healthCell(h.Kubelet), healthCell(h.SSH))
+		}
+		fmt.Fprintln(tw)
+	}
+	tw.Flush()
+}
+
+// healthCell returns a short version of the given probe result.
+// The reason of a failure is available in the json & yaml output.
+func healthCell(result string) string {
+	switch result {
+	case "":
+		return "-"
+	case update.HealthOK:
+		return result
+	default:
+		return "fail"
+	}
+}
+
+// printMemberYAML writes the given members as YAML to the given writer.
+// Every value is written as JSON, which is valid YAML.
+func printMemberYAML(w io.Writer, infos []memberInfo) error {
+	for _, m := range infos {
+		raw, err := json.Marshal(m)
+		if err != nil {
+			return maskAny(err)
+		}
+		var fields map[string]interface{}
+		if err := json.Unmarshal(raw, &fields); err != nil {
+			return maskAny(err)
+		}
+		// Keep the order of the struct fields
+		prefix := "- "
+		for _, key := range []string{"machine-id", "cluster-ip", "private-host-ip", "etcd", "local", "health"} {
+			value, found := fields[key]
+			if !found {
+				continue
+			}
+			encoded, err := json.Marshal(value)
+			if err != nil {
+				return maskAny(err)
+			}
+			fmt.Fprintf(w, "%s%s: %s\n", prefix, key, encoded)
+			prefix = "  "
+		}
 	}
 	return nil
 }
+
+func runMemberAdd(cmd *cobra.Command, args []string) {
+	if len(args) != 2 {
+		Exitf("Expected <machine-id> <ip> arguments\n")
+	}
+	member := memberOptions.ClusterMember
+	member.MachineID = args[0]
+	member.ClusterIP = args[1]
+	if member.PrivateHostIP == "" {
+		member.PrivateHostIP = member.ClusterIP
+	}
+	if _, err := util.WeaveNameFromMachineID(member.MachineID); err != nil {
+		Exitf("Invalid machine ID '%s': %v\n", member.MachineID, err)
+	}
+	if err := memberFlags.SetupDefaults(log); err != nil {
+		Exitf("SetupDefaults failed: %#v\n", err)
+	}
+	if err := update.AddMember(memberFlags, member, log); err != nil {
+		Exitf("Failed to add member: %v\n", errgo.Cause(err))
+	}
+	log.Info("Done")
+}
+
+func runMemberRemove(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		Exitf("Expected <machine-id|ip> argument\n")
+	}
+	if err := memberFlags.SetupDefaults(log); err != nil {
+		Exitf("SetupDefaults failed: %#v\n", err)
+	}
+	if err := update.RemoveMember(memberFlags, args[0], log); err != nil {
+		Exitf("Failed to remove member: %v\n", errgo.Cause(err))
+	}
+	log.Info("Done")
+}
+
+func runMemberKeyscan(cmd *cobra.Command, args []string) {
+	if err := memberFlags.SetupDefaults(log); err != nil {
+		Exitf("SetupDefaults failed: %#v\n", err)
+	}
+	knownHostsFile := memberFlags.SSH.KnownHostsFile
+	if knownHostsFile == "" {
+		knownHostsFile = update.DefaultKnownHostsFile()
+	}
+	members, err := memberFlags.GetClusterMembers(log)
+	if err != nil {
+		Exitf("Failed to get members: %#v\n", err)
+	}
+	if err := update.CollectHostKeys(members, knownHostsFile, log); err != nil {
+		Exitf("Failed to collect host keys: %v\n", errgo.Cause(err))
+	}
+}
+
+func runMemberReplace(cmd *cobra.Command, args []string) {
+	var members []service.ClusterMember
+	if err := json.NewDecoder(os.Stdin).Decode(&members); err != nil {
+		Exitf("Cannot decode members: %v\n", err)
+	}
+	if len(members) == 0 {
+		Exitf("Refusing to replace members with an empty list\n")
+	}
+	changed, err := service.UpdateConfig(util.NewTarget(log, "", nil), func(cfg *service.Config) error {
+		cfg.Members = members
+		return nil
+	})
+	if err != nil {
+		Exitf("Failed to update members: %#v\n", err)
+	}
+	if changed {
+		log.Infof("Updated members in %s", service.ConfigPath)
+	}
+}
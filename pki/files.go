@@ -0,0 +1,133 @@
+// Copyright (c) 2017 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pki
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// LoadCA reads the CA from the given certificate & key files.
+func LoadCA(certPath, keyPath string) (*CA, error) {
+	certPEM, err := ioutil.ReadFile(certPath)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	keyPEM, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	ca, err := ParseCA(certPEM, keyPEM)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	return ca, nil
+}
+
+// InitDir creates the files of a new cluster CA (named after the given common name) and a
+// service accounts key in the given directory.
+// Existing files are never replaced, so the CA of a cluster is kept when called again.
+// Returns the paths of the files that have been created.
+func InitDir(dir, commonName string) ([]string, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, maskAny(err)
+	}
+	var created []string
+	certPath, keyPath := filepath.Join(dir, caCertFileName), filepath.Join(dir, caKeyFileName)
+	if _, err := os.Stat(certPath); os.IsNotExist(err) {
+		if _, err := os.Stat(keyPath); err == nil {
+			return nil, maskAny(fmt.Errorf("%s exists without %s", keyPath, certPath))
+		}
+		certPEM, keyPEM, err := CreateCA(commonName)
+		if err != nil {
+			return nil, maskAny(err)
+		}
+		if err := writeFile(keyPath, keyPEM, 0600); err != nil {
+			return nil, maskAny(err)
+		}
+		if err := writeFile(certPath, certPEM, 0644); err != nil {
+			return nil, maskAny(err)
+		}
+		created = append(created, certPath, keyPath)
+	} else if err != nil {
+		return nil, maskAny(err)
+	}
+	saKeyPath := filepath.Join(dir, serviceAccountsKeyFileName)
+	if _, err := os.Stat(saKeyPath); os.IsNotExist(err) {
+		keyPEM, err := CreateKey()
+		if err != nil {
+			return nil, maskAny(err)
+		}
+		if err := writeFile(saKeyPath, keyPEM, 0600); err != nil {
+			return nil, maskAny(err)
+		}
+		created = append(created, saKeyPath)
+	} else if err != nil {
+		return nil, maskAny(err)
+	}
+	return created, nil
+}
+
+// IssueFiles writes a certificate for the given request, its private key & the CA certificate
+// to the given paths with given mode.
+// Nothing is written when the existing certificate does not need renewal and the CA
+// certificate is up to date, so it can be called periodically.
+// Returns true if any file has been written, false otherwise.
+func (ca *CA) IssueFiles(req Request, certPath, keyPath, caPath string, mode os.FileMode) (bool, error) {
+	existingCA, _ := ioutil.ReadFile(caPath)
+	if existingCert, err := ioutil.ReadFile(certPath); err == nil && bytes.Equal(existingCA, ca.CertPEM) {
+		if _, err := os.Stat(keyPath); err == nil && !ca.NeedsRenewal(existingCert, req, time.Now()) {
+			return false, nil
+		}
+	}
+	certPEM, keyPEM, err := ca.Issue(req)
+	if err != nil {
+		return false, maskAny(err)
+	}
+	// Write the key first, so the certificate never refers to a key that is not there yet
+	for _, f := range []struct {
+		Path    string
+		Content []byte
+	}{
+		{keyPath, keyPEM},
+		{certPath, certPEM},
+		{caPath, ca.CertPEM},
+	} {
+		if err := writeFile(f.Path, f.Content, mode); err != nil {
+			return false, maskAny(err)
+		}
+	}
+	return true, nil
+}
+
+// writeFile replaces the file at given path atomically.
+func writeFile(path string, content []byte, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return maskAny(err)
+	}
+	tmpPath := path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, content, mode); err != nil {
+		return maskAny(err)
+	}
+	// WriteFile does not change the mode of an existing file
+	if err := os.Chmod(tmpPath, mode); err != nil {
+		return maskAny(err)
+	}
+	return maskAny(os.Rename(tmpPath, path))
+}
@@ -0,0 +1,252 @@
+// Copyright (c) 2017 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package pki implements the self-managed cluster CA that is used instead of vault
+// when the CA mode is local.
+package pki
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/juju/errgo"
+)
+
+const (
+	// CADir is the directory holding the cluster CA. It must have the same content on all machines.
+	CADir = "/etc/pulcy/ca"
+	// CACertPath is the path of the certificate of the cluster CA.
+	CACertPath = CADir + "/" + caCertFileName
+	// CAKeyPath is the path of the private key of the cluster CA.
+	CAKeyPath = CADir + "/" + caKeyFileName
+	// ServiceAccountsKeyPath is the path of the key used to sign kubernetes service account tokens.
+	ServiceAccountsKeyPath = CADir + "/" + serviceAccountsKeyFileName
+
+	caCertFileName             = "ca-cert.pem"
+	caKeyFileName              = "ca-key.pem"
+	serviceAccountsKeyFileName = "serviceaccounts-key.pem"
+
+	// CAValidity is the lifetime of a new cluster CA.
+	CAValidity = time.Hour * 24 * 365 * 10
+	// CertValidity is the lifetime of an issued certificate.
+	CertValidity = time.Hour * 24 * 365
+	// RenewBefore is the time before expiration at which an issued certificate is replaced.
+	RenewBefore = time.Hour * 24 * 30
+
+	keyBits = 2048
+)
+
+var (
+	maskAny = errgo.MaskFunc(errgo.Any)
+)
+
+// CA is a certificate authority that issues certificates.
+type CA struct {
+	Cert    *x509.Certificate
+	CertPEM []byte
+	key     *rsa.PrivateKey
+}
+
+// Request describes the certificate to issue.
+type Request struct {
+	CommonName string
+	AltNames   []string // DNS names
+	IPSans     []string // IP addresses
+}
+
+// CreateCA creates a new self-signed CA with given common name.
+// It returns the PEM encoded certificate & private key.
+func CreateCA(commonName string) (certPEM, keyPEM []byte, err error) {
+	key, err := rsa.GenerateKey(rand.Reader, keyBits)
+	if err != nil {
+		return nil, nil, maskAny(err)
+	}
+	serial, err := newSerialNumber()
+	if err != nil {
+		return nil, nil, maskAny(err)
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(CAValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, maskAny(err)
+	}
+	return encodeCert(der), encodeKey(key), nil
+}
+
+// CreateKey creates a new PEM encoded RSA private key.
+func CreateKey() ([]byte, error) {
+	key, err := rsa.GenerateKey(rand.Reader, keyBits)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	return encodeKey(key), nil
+}
+
+// ParseCA parses the PEM encoded certificate & private key of a CA.
+func ParseCA(certPEM, keyPEM []byte) (*CA, error) {
+	cert, err := parseCert(certPEM)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	if !cert.IsCA {
+		return nil, maskAny(fmt.Errorf("Certificate '%s' is not a CA", cert.Subject.CommonName))
+	}
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, maskAny(fmt.Errorf("No PEM data found in CA key"))
+	}
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, maskAny(fmt.Errorf("CA certificate must use an RSA key"))
+	}
+	if key.PublicKey.N.Cmp(pub.N) != 0 {
+		return nil, maskAny(fmt.Errorf("CA key does not match CA certificate"))
+	}
+	return &CA{Cert: cert, CertPEM: certPEM, key: key}, nil
+}
+
+// Issue creates a certificate, signed by the CA, that can be used by servers & clients.
+// It returns the PEM encoded certificate & private key.
+func (ca *CA) Issue(req Request) (certPEM, keyPEM []byte, err error) {
+	key, err := rsa.GenerateKey(rand.Reader, keyBits)
+	if err != nil {
+		return nil, nil, maskAny(err)
+	}
+	serial, err := newSerialNumber()
+	if err != nil {
+		return nil, nil, maskAny(err)
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: req.CommonName},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(CertValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     req.AltNames,
+	}
+	if template.NotAfter.After(ca.Cert.NotAfter) {
+		template.NotAfter = ca.Cert.NotAfter
+	}
+	for _, x := range req.IPSans {
+		ip := net.ParseIP(x)
+		if ip == nil {
+			return nil, nil, maskAny(fmt.Errorf("Invalid IP SAN '%s'", x))
+		}
+		template.IPAddresses = append(template.IPAddresses, ip)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.Cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return nil, nil, maskAny(err)
+	}
+	return encodeCert(der), encodeKey(key), nil
+}
+
+// NeedsRenewal returns true if the given PEM encoded certificate is not signed by the CA,
+// does not match the given request or expires within RenewBefore from now.
+func (ca *CA) NeedsRenewal(certPEM []byte, req Request, now time.Time) bool {
+	cert, err := parseCert(certPEM)
+	if err != nil {
+		return true
+	}
+	if err := cert.CheckSignatureFrom(ca.Cert); err != nil {
+		return true
+	}
+	if now.Add(RenewBefore).After(cert.NotAfter) {
+		return true
+	}
+	var ips []string
+	for _, ip := range cert.IPAddresses {
+		ips = append(ips, ip.String())
+	}
+	var reqIPs []string
+	for _, x := range req.IPSans {
+		if ip := net.ParseIP(x); ip != nil {
+			reqIPs = append(reqIPs, ip.String())
+		}
+	}
+	return cert.Subject.CommonName != req.CommonName ||
+		!sameSet(cert.DNSNames, req.AltNames) ||
+		!sameSet(ips, reqIPs)
+}
+
+// newSerialNumber creates a random certificate serial number.
+func newSerialNumber() (*big.Int, error) {
+	limit := new(big.Int).Lsh(big.NewInt(1), 128)
+	serial, err := rand.Int(rand.Reader, limit)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	return serial, nil
+}
+
+func parseCert(certPEM []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return nil, maskAny(fmt.Errorf("No PEM data found in certificate"))
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	return cert, nil
+}
+
+func encodeCert(der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func encodeKey(key *rsa.PrivateKey) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+}
+
+// sameSet returns true if a and b contain the same elements, ignoring order & duplicates.
+func sameSet(a, b []string) bool {
+	normalize := func(list []string) string {
+		m := make(map[string]struct{})
+		for _, x := range list {
+			m[x] = struct{}{}
+		}
+		var keys []string
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		return strings.Join(keys, ",")
+	}
+	return normalize(a) == normalize(b)
+}
@@ -0,0 +1,125 @@
+package pki
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestIssue(t *testing.T) {
+	certPEM, keyPEM, err := CreateCA("test-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := ParseCA(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPEM, otherKeyPEM, err := CreateCA("other-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseCA(certPEM, otherKeyPEM); err == nil {
+		t.Errorf("Expected mismatching key to fail")
+	}
+	if _, err := ParseCA(createECDSACA(t), keyPEM); err == nil {
+		t.Errorf("Expected ECDSA CA certificate to fail")
+	}
+
+	req := Request{CommonName: "etcd", AltNames: []string{"etcd.local"}, IPSans: []string{"10.0.0.1", "127.0.0.1"}}
+	issued, _, err := ca.Issue(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := parseCert(issued)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(certPEM)
+	if _, err := cert.Verify(x509.VerifyOptions{DNSName: "10.0.0.1", Roots: roots}); err != nil {
+		t.Errorf("Verify failed: %v", err)
+	}
+
+	now := time.Now()
+	if ca.NeedsRenewal(issued, Request{CommonName: "etcd", AltNames: []string{"etcd.local"}, IPSans: []string{"127.0.0.1", "10.0.0.1"}}, now) {
+		t.Errorf("Expected no renewal for the same request")
+	}
+	if !ca.NeedsRenewal(issued, Request{CommonName: "etcd", IPSans: req.IPSans}, now) {
+		t.Errorf("Expected renewal for changed alt names")
+	}
+	if !ca.NeedsRenewal(issued, req, now.Add(CertValidity-RenewBefore+time.Hour)) {
+		t.Errorf("Expected renewal close to expiration")
+	}
+	other, err := ParseCA(otherPEM, otherKeyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !other.NeedsRenewal(issued, req, now) {
+		t.Errorf("Expected renewal for another CA")
+	}
+}
+
+func TestIssueFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pki")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	created, err := InitDir(dir, "test-ca")
+	if err != nil || len(created) != 3 {
+		t.Fatalf("Expected 3 files to be created, got %v %v", created, err)
+	}
+	if created, err := InitDir(dir, "test-ca"); err != nil || len(created) != 0 {
+		t.Fatalf("Expected existing files to be kept, got %v %v", created, err)
+	}
+	ca, err := LoadCA(filepath.Join(dir, caCertFileName), filepath.Join(dir, caKeyFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPath, keyPath, caPath := filepath.Join(dir, "c.pem"), filepath.Join(dir, "k.pem"), filepath.Join(dir, "ca.pem")
+	req := Request{CommonName: "kubelet", IPSans: []string{"10.0.0.1"}}
+	if changed, err := ca.IssueFiles(req, certPath, keyPath, caPath, 0660); err != nil || !changed {
+		t.Fatalf("Expected files to be written, got %v %v", changed, err)
+	}
+	if info, err := os.Stat(keyPath); err != nil || info.Mode().Perm() != 0660 {
+		t.Errorf("Unexpected key file %v %v", info, err)
+	}
+	if changed, err := ca.IssueFiles(req, certPath, keyPath, caPath, 0660); err != nil || changed {
+		t.Errorf("Expected files to be kept, got %v %v", changed, err)
+	}
+	req.IPSans = append(req.IPSans, "10.0.0.2")
+	if changed, err := ca.IssueFiles(req, certPath, keyPath, caPath, 0660); err != nil || !changed {
+		t.Errorf("Expected files to be replaced, got %v %v", changed, err)
+	}
+}
+
+// createECDSACA creates a PEM encoded, self-signed CA certificate that uses an ECDSA key.
+func createECDSACA(t *testing.T) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ecdsa-ca"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encodeCert(der)
+}
@@ -0,0 +1,145 @@
+// Copyright (c) 2017 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	logging "github.com/op/go-logging"
+	"github.com/spf13/cobra"
+
+	"github.com/pulcy/gluon/reboot"
+	"github.com/pulcy/gluon/service"
+	"github.com/pulcy/gluon/util"
+)
+
+const (
+	// statusLoggerName is the logger used while checking the health of this machine.
+	// Checking runs setup against a plan, which logs what it would do, which is just noise here.
+	statusLoggerName = "gluon-status"
+)
+
+var (
+	cmdReboot = &cobra.Command{
+		Use:   "reboot",
+		Short: "Reboot this machine after taking the reboot locks of its roles",
+		Run:   runReboot,
+	}
+	cmdRebootRelease = &cobra.Command{
+		Use:   "release",
+		Short: "Release the reboot locks of this machine once it is healthy",
+		Run:   runRebootRelease,
+	}
+	cmdRebootStatus = &cobra.Command{
+		Use:   "status",
+		Short: "Show which machines hold reboot locks",
+		Run:   runRebootStatus,
+	}
+	rebootFlags = &reboot.RebootFlags{}
+)
+
+func init() {
+	cmdReboot.Flags().BoolVar(&rebootFlags.IfNeeded, "if-needed", false, "If set, only reboot when the OS needs it")
+	cmdReboot.Flags().DurationVar(&rebootFlags.LockTimeout, "lock-timeout", 0, "Maximum time to wait for the reboot locks (default 1h)")
+	cmdRebootRelease.Flags().DurationVar(&rebootFlags.HealthTimeout, "health-timeout", 0, "Maximum time this machine may take to become healthy (default 15m)")
+	for _, c := range []*cobra.Command{cmdReboot, cmdRebootRelease, cmdRebootStatus} {
+		addServiceFlags(c, &rebootFlags.ServiceFlags)
+	}
+
+	cmdReboot.AddCommand(cmdRebootRelease)
+	cmdReboot.AddCommand(cmdRebootStatus)
+	cmdMain.AddCommand(cmdReboot)
+}
+
+// setupRebootFlags fills the reboot flags with defaults for this machine.
+func setupRebootFlags() {
+	if rebootFlags.Network.ClusterIP == "" {
+		rebootFlags.Network.ClusterIP = defaultPrivateIPv4(util.NewTarget(log, "", nil))
+	}
+	if rebootFlags.Docker.DockerIP == "" {
+		rebootFlags.Docker.DockerIP = rebootFlags.Network.ClusterIP
+	}
+	if err := rebootFlags.SetupDefaults(log); err != nil {
+		Exitf("SetupDefaults failed: %#v\n", err)
+	}
+	assertArgIsSet(rebootFlags.Network.ClusterIP, "--private-ip")
+}
+
+func runReboot(cmd *cobra.Command, args []string) {
+	setupRebootFlags()
+	if err := reboot.Reboot(rebootFlags, log); err != nil {
+		Exitf("Reboot failed: %v\n", err)
+	}
+}
+
+func runRebootRelease(cmd *cobra.Command, args []string) {
+	setupRebootFlags()
+	statusLog := logging.MustGetLogger(statusLoggerName)
+	logging.SetLevel(logging.ERROR, statusLoggerName)
+	healthy := func() error {
+		target := util.NewTarget(statusLog, "", nil)
+		sdc := newSystemdClient(target)
+		defer sdc.Close()
+		deps := service.ServiceDependencies{
+			Systemd: sdc,
+			Logger:  statusLog,
+			Target:  target,
+		}
+		statuses, healthy, err := collectStatus(deps, &rebootFlags.ServiceFlags)
+		if err != nil {
+			return maskAny(err)
+		}
+		if !healthy {
+			var names []string
+			for _, s := range statuses {
+				if !s.Healthy() {
+					names = append(names, s.Name)
+				}
+			}
+			return maskAny(fmt.Errorf("Services not healthy: %s", strings.Join(names, ", ")))
+		}
+		return nil
+	}
+	if err := reboot.Release(rebootFlags, healthy, log); err != nil {
+		Exitf("Release failed: %v\n", err)
+	}
+}
+
+func runRebootStatus(cmd *cobra.Command, args []string) {
+	setupRebootFlags()
+	holders, err := reboot.Status(rebootFlags, log)
+	if err != nil {
+		Exitf("Cannot load reboot locks: %v\n", err)
+	}
+	if len(holders) == 0 {
+		fmt.Println("No reboot locks held")
+		return
+	}
+	var groups []string
+	for g := range holders {
+		groups = append(groups, g)
+	}
+	sort.Strings(groups)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ROLE\tHOLDERS")
+	for _, g := range groups {
+		fmt.Fprintf(tw, "%s\t%s\n", g, strings.Join(holders[g], ", "))
+	}
+	tw.Flush()
+}
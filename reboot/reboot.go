@@ -0,0 +1,285 @@
+// Copyright (c) 2017 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reboot
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/juju/errgo"
+	logging "github.com/op/go-logging"
+
+	"github.com/pulcy/gluon/etcdclient"
+	"github.com/pulcy/gluon/service"
+	"github.com/pulcy/gluon/service/etcd"
+	"github.com/pulcy/gluon/util"
+)
+
+const (
+	// statePath contains the reboot locks held by this machine, so they can be released after the reboot.
+	statePath = "/var/lib/gluon/reboot-lock.json"
+	// rebootRequiredPath is created by package managers when a reboot is needed.
+	rebootRequiredPath = "/var/run/reboot-required"
+
+	defaultLockTimeout   = time.Hour
+	defaultHealthTimeout = time.Minute * 15
+	lockRetryInterval    = time.Second * 30
+	healthCheckInterval  = time.Second * 10
+)
+
+var (
+	// quorumRoles are roles of which at most one machine reboots at a time,
+	// since the services behind them need a quorum (or a single instance) to stay available.
+	quorumRoles = []string{service.RoleEtcd, service.RoleConsulServer, service.RoleWeaveSeed, service.RoleAPIServer, service.RoleVault}
+)
+
+type RebootFlags struct {
+	service.ServiceFlags
+	LockTimeout   time.Duration // Maximum time to wait for the reboot locks
+	HealthTimeout time.Duration // Maximum time the machine may take to become healthy after a reboot
+	IfNeeded      bool          // If set, only reboot when the OS asks for it
+}
+
+func (flags *RebootFlags) SetupDefaults(log *logging.Logger) error {
+	if err := flags.ServiceFlags.SetupDefaults(util.NewTarget(log, "", nil)); err != nil {
+		return maskAny(err)
+	}
+	if flags.Reboot.MaxConcurrent < 1 {
+		flags.Reboot.MaxConcurrent = 1
+	}
+	if flags.LockTimeout == 0 {
+		flags.LockTimeout = defaultLockTimeout
+	}
+	if flags.HealthTimeout == 0 {
+		flags.HealthTimeout = defaultHealthTimeout
+	}
+	return nil
+}
+
+// rebootState is stored in statePath while this machine holds reboot locks.
+type rebootState struct {
+	Holder string   `json:"holder"`
+	Groups []string `json:"groups"`
+}
+
+// lockGroups returns the semaphore groups the given member must lock before rebooting,
+// sorted by name, with the maximum number of machines per group.
+func lockGroups(member service.ClusterMember, maxConcurrent int) ([]string, map[string]int) {
+	limits := make(map[string]int)
+	for _, role := range service.KnownRoles {
+		if role == service.RoleCore || !member.HasRole(role) {
+			continue
+		}
+		limits[role] = maxConcurrent
+		for _, q := range quorumRoles {
+			if q == role {
+				limits[role] = 1
+			}
+		}
+	}
+	var groups []string
+	for g := range limits {
+		groups = append(groups, g)
+	}
+	sort.Strings(groups)
+	return groups, limits
+}
+
+// Reboot takes the reboot locks of all roles of this machine and reboots it.
+// The locks are released by Release once the machine is healthy again.
+func Reboot(flags *RebootFlags, log *logging.Logger) error {
+	if flags.IfNeeded {
+		needed, err := rebootNeeded()
+		if err != nil {
+			return maskAny(err)
+		}
+		if !needed {
+			log.Infof("No reboot needed")
+			return nil
+		}
+	}
+	member, err := flags.ThisMember(log)
+	if err != nil {
+		return maskAny(err)
+	}
+	client, err := etcd.NewClient(&flags.ServiceFlags, log)
+	if err != nil {
+		return maskAny(err)
+	}
+	groups, limits := lockGroups(member, flags.Reboot.MaxConcurrent)
+
+	// Record the groups before locking, so an interrupted reboot still releases them
+	state := rebootState{Holder: member.MachineID, Groups: groups}
+	if err := writeState(state); err != nil {
+		return maskAny(err)
+	}
+	if err := acquireAll(client, state, limits, flags.LockTimeout, log); err != nil {
+		os.Remove(statePath)
+		return maskAny(err)
+	}
+
+	log.Infof("Rebooting %s...", member.ClusterIP)
+	if out, err := exec.Command("systemctl", "reboot").CombinedOutput(); err != nil {
+		return maskAny(fmt.Errorf("Reboot failed: %v %s", err, strings.TrimSpace(string(out))))
+	}
+	return nil
+}
+
+// acquireAll takes the locks of all groups in the given state, in order.
+// When a lock is not available, the locks taken so far are released and all locks
+// are tried again later, so two machines never wait for each other.
+func acquireAll(client *etcdclient.Client, state rebootState, limits map[string]int, timeout time.Duration, log *logging.Logger) error {
+	start := time.Now()
+	for {
+		var taken []string
+		var lastErr error
+		for _, g := range state.Groups {
+			if err := acquire(client, g, limits[g], state.Holder); err != nil {
+				lastErr = err
+				break
+			}
+			taken = append(taken, g)
+		}
+		if lastErr == nil {
+			log.Infof("Took reboot locks of %s", strings.Join(state.Groups, ", "))
+			return nil
+		}
+		for _, g := range taken {
+			if err := release(client, g, state.Holder); err != nil {
+				log.Warningf("Cannot release reboot lock of %s: %v", g, err)
+			}
+		}
+		if !IsSemaphoreFull(lastErr) {
+			return maskAny(lastErr)
+		}
+		if time.Since(start) > timeout {
+			return maskAny(fmt.Errorf("No reboot lock after %s: %v", timeout, errgo.Cause(lastErr)))
+		}
+		log.Infof("%v, waiting...", errgo.Cause(lastErr))
+		time.Sleep(lockRetryInterval)
+	}
+}
+
+// Release waits until this machine is healthy and then releases the reboot locks it holds.
+// If the machine does not become healthy in time, the locks stay held, so no other machine
+// of the same roles reboots.
+func Release(flags *RebootFlags, healthy func() error, log *logging.Logger) error {
+	state, err := readState()
+	if err != nil {
+		return maskAny(err)
+	}
+	if state == nil {
+		log.Infof("No reboot locks held")
+		return nil
+	}
+
+	start := time.Now()
+	for {
+		err := healthy()
+		if err == nil {
+			break
+		}
+		if time.Since(start) > flags.HealthTimeout {
+			return maskAny(fmt.Errorf("Machine not healthy after %s, keeping reboot locks of %s: %v", flags.HealthTimeout, strings.Join(state.Groups, ", "), errgo.Cause(err)))
+		}
+		log.Debugf("Machine not yet healthy: %v", errgo.Cause(err))
+		time.Sleep(healthCheckInterval)
+	}
+
+	client, err := etcd.NewClient(&flags.ServiceFlags, log)
+	if err != nil {
+		return maskAny(err)
+	}
+	for _, g := range state.Groups {
+		if err := release(client, g, state.Holder); err != nil {
+			return maskAny(err)
+		}
+	}
+	if err := os.Remove(statePath); err != nil && !os.IsNotExist(err) {
+		return maskAny(err)
+	}
+	log.Infof("Released reboot locks of %s", strings.Join(state.Groups, ", "))
+	return nil
+}
+
+// Status returns the holders of the reboot locks of all groups, by group.
+func Status(flags *RebootFlags, log *logging.Logger) (map[string][]string, error) {
+	client, err := etcd.NewClient(&flags.ServiceFlags, log)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	result := make(map[string][]string)
+	for _, role := range service.KnownRoles {
+		sem, _, err := getSemaphore(client, role)
+		if err != nil {
+			return nil, maskAny(err)
+		}
+		if sem != nil && len(sem.Holders) > 0 {
+			result[role] = sem.Holders
+		}
+	}
+	return result, nil
+}
+
+// rebootNeeded returns true if the OS asks for a reboot, either because a package
+// manager created /var/run/reboot-required, or because update_engine installed an update.
+func rebootNeeded() (bool, error) {
+	if _, err := os.Stat(rebootRequiredPath); err == nil {
+		return true, nil
+	} else if !os.IsNotExist(err) {
+		return false, maskAny(err)
+	}
+	out, err := exec.Command("update_engine_client", "-status").CombinedOutput()
+	if err != nil {
+		if _, ok := err.(*exec.Error); ok {
+			// No update_engine on this OS
+			return false, nil
+		}
+		return false, maskAny(fmt.Errorf("update_engine_client failed: %v %s", err, strings.TrimSpace(string(out))))
+	}
+	return strings.Contains(string(out), "UPDATE_STATUS_UPDATED_NEED_REBOOT"), nil
+}
+
+func readState() (*rebootState, error) {
+	content, err := ioutil.ReadFile(statePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, maskAny(err)
+	}
+	var state rebootState
+	if err := json.Unmarshal(content, &state); err != nil {
+		return nil, maskAny(fmt.Errorf("Cannot parse %s: %v", statePath, err))
+	}
+	return &state, nil
+}
+
+func writeState(state rebootState) error {
+	content, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return maskAny(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(statePath), 0755); err != nil {
+		return maskAny(err)
+	}
+	return maskAny(ioutil.WriteFile(statePath, content, 0644))
+}
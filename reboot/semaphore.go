@@ -0,0 +1,154 @@
+// Copyright (c) 2017 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reboot
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/juju/errgo"
+
+	"github.com/pulcy/gluon/etcdclient"
+)
+
+var (
+	maskAny = errgo.MaskFunc(errgo.Any)
+)
+
+const (
+	// semaphorePrefix is the ETCD key under which the semaphore of every group is stored.
+	semaphorePrefix = "/pulcy/reboot"
+	// casAttempts is the number of times a compare-and-swap is retried when another machine changed the semaphore.
+	casAttempts = 10
+)
+
+// semaphore is the value stored in ETCD for every group of machines.
+// Holders contains the machine IDs of the machines that are allowed to reboot.
+type semaphore struct {
+	Holders []string `json:"holders"`
+}
+
+// SemaphoreFullError is returned when all slots of a semaphore are taken.
+type SemaphoreFullError struct {
+	Group   string
+	Holders []string
+}
+
+func (e *SemaphoreFullError) Error() string {
+	return fmt.Sprintf("Reboot lock of %s is held by %s", e.Group, strings.Join(e.Holders, ", "))
+}
+
+// IsSemaphoreFull returns true if the given error is a SemaphoreFullError.
+func IsSemaphoreFull(err error) bool {
+	_, ok := errgo.Cause(err).(*SemaphoreFullError)
+	return ok
+}
+
+func semaphoreKey(group string) string {
+	return path.Join(semaphorePrefix, group)
+}
+
+// getSemaphore loads the semaphore of the given group.
+// Returns nil (without error) if it does not exist.
+func getSemaphore(c *etcdclient.Client, group string) (*semaphore, uint64, error) {
+	node, err := c.Get(semaphoreKey(group), false)
+	if etcdclient.IsKeyNotFound(err) {
+		return nil, 0, nil
+	} else if err != nil {
+		return nil, 0, maskAny(err)
+	}
+	var sem semaphore
+	if err := json.Unmarshal([]byte(node.Value), &sem); err != nil {
+		return nil, 0, maskAny(fmt.Errorf("Cannot parse reboot lock of %s: %v", group, err))
+	}
+	return &sem, node.ModifiedIndex, nil
+}
+
+// acquire takes a slot of the semaphore of the given group for the given holder.
+// Taking a slot that is already held by the same holder succeeds.
+// If all (max) slots are taken, a SemaphoreFullError is returned.
+func acquire(c *etcdclient.Client, group string, max int, holder string) error {
+	key := semaphoreKey(group)
+	for attempt := 0; attempt < casAttempts; attempt++ {
+		sem, index, err := getSemaphore(c, group)
+		if err != nil {
+			return maskAny(err)
+		}
+		if sem == nil {
+			value, _ := json.Marshal(semaphore{Holders: []string{holder}})
+			if _, err := c.Create(key, string(value), 0); etcdclient.IsNodeExist(err) {
+				continue
+			} else if err != nil {
+				return maskAny(err)
+			}
+			return nil
+		}
+		for _, h := range sem.Holders {
+			if h == holder {
+				return nil
+			}
+		}
+		if len(sem.Holders) >= max {
+			return maskAny(&SemaphoreFullError{Group: group, Holders: sem.Holders})
+		}
+		sem.Holders = append(sem.Holders, holder)
+		value, _ := json.Marshal(sem)
+		if _, err := c.CompareAndSwap(key, string(value), 0, index); etcdclient.IsTestFailed(err) {
+			continue
+		} else if err != nil {
+			return maskAny(err)
+		}
+		return nil
+	}
+	return maskAny(fmt.Errorf("Reboot lock of %s keeps changing, giving up", group))
+}
+
+// release frees the slot of the semaphore of the given group held by the given holder.
+// Releasing a slot that is not held succeeds.
+func release(c *etcdclient.Client, group string, holder string) error {
+	key := semaphoreKey(group)
+	for attempt := 0; attempt < casAttempts; attempt++ {
+		sem, index, err := getSemaphore(c, group)
+		if err != nil {
+			return maskAny(err)
+		}
+		if sem == nil {
+			return nil
+		}
+		var remaining []string
+		for _, h := range sem.Holders {
+			if h != holder {
+				remaining = append(remaining, h)
+			}
+		}
+		if len(remaining) == len(sem.Holders) {
+			return nil
+		}
+		sem.Holders = remaining
+		if sem.Holders == nil {
+			sem.Holders = []string{}
+		}
+		value, _ := json.Marshal(sem)
+		if _, err := c.CompareAndSwap(key, string(value), 0, index); etcdclient.IsTestFailed(err) {
+			continue
+		} else if err != nil {
+			return maskAny(err)
+		}
+		return nil
+	}
+	return maskAny(fmt.Errorf("Reboot lock of %s keeps changing, giving up", group))
+}
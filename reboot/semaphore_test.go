@@ -0,0 +1,101 @@
+package reboot
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pulcy/gluon/etcdclient"
+	"github.com/pulcy/gluon/service"
+)
+
+// fakeEtcd implements the parts of the ETCD v2 keys API used by the semaphore.
+type fakeEtcd struct {
+	mutex sync.Mutex
+	index uint64
+	keys  map[string]string
+	mods  map[string]uint64
+}
+
+func (f *fakeEtcd) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	key := strings.TrimPrefix(r.URL.Path, "/v2/keys")
+	value, found := f.keys[key]
+	fail := func(status, code int) {
+		w.WriteHeader(status)
+		fmt.Fprintf(w, `{"errorCode":%d,"message":"error %d"}`, code, code)
+	}
+	switch r.Method {
+	case "GET":
+		if !found {
+			fail(http.StatusNotFound, 100)
+			return
+		}
+	case "PUT":
+		q := r.URL.Query()
+		if q.Get("prevExist") == "false" && found {
+			fail(http.StatusPreconditionFailed, 105)
+			return
+		}
+		if prev := q.Get("prevIndex"); prev != "" && prev != strconv.FormatUint(f.mods[key], 10) {
+			fail(http.StatusPreconditionFailed, 101)
+			return
+		}
+		f.index++
+		value = r.FormValue("value")
+		f.keys[key] = value
+		f.mods[key] = f.index
+	}
+	fmt.Fprintf(w, `{"action":"get","node":{"key":%q,"value":%q,"modifiedIndex":%d}}`, key, value, f.mods[key])
+}
+
+func TestSemaphore(t *testing.T) {
+	server := httptest.NewServer(&fakeEtcd{keys: map[string]string{}, mods: map[string]uint64{}})
+	defer server.Close()
+	c := etcdclient.NewClient([]string{server.URL}, nil)
+
+	if err := acquire(c, "worker", 2, "a"); err != nil {
+		t.Fatalf("acquire a failed: %v", err)
+	}
+	if err := acquire(c, "worker", 2, "a"); err != nil {
+		t.Fatalf("acquire a again failed: %v", err)
+	}
+	if err := acquire(c, "worker", 2, "b"); err != nil {
+		t.Fatalf("acquire b failed: %v", err)
+	}
+	if err := acquire(c, "worker", 2, "c"); !IsSemaphoreFull(err) {
+		t.Fatalf("Expected semaphore full, got %v", err)
+	}
+	if err := release(c, "worker", "a"); err != nil {
+		t.Fatalf("release a failed: %v", err)
+	}
+	if err := acquire(c, "worker", 2, "c"); err != nil {
+		t.Fatalf("acquire c failed: %v", err)
+	}
+	sem, _, err := getSemaphore(c, "worker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"b", "c"}; !reflect.DeepEqual(sem.Holders, expected) {
+		t.Errorf("Expected holders %v, got %v", expected, sem.Holders)
+	}
+	if err := release(c, "etcd", "a"); err != nil {
+		t.Errorf("release of unknown group failed: %v", err)
+	}
+}
+
+func TestLockGroups(t *testing.T) {
+	groups, limits := lockGroups(service.ClusterMember{Roles: []string{service.RoleEtcd, service.RoleWorker}}, 3)
+	if expected := []string{service.RoleEtcd, service.RoleWorker}; !reflect.DeepEqual(groups, expected) {
+		t.Errorf("Expected groups %v, got %v", expected, groups)
+	}
+	if limits[service.RoleEtcd] != 1 || limits[service.RoleWorker] != 3 {
+		t.Errorf("Unexpected limits %v", limits)
+	}
+}
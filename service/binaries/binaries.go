@@ -16,7 +16,6 @@ package binaries
 
 import (
 	"os"
-	"os/exec"
 
 	"github.com/juju/errgo"
 
@@ -49,6 +48,10 @@ func (t *binariesService) Name() string {
 	return "binaries"
 }
 
+func (t *binariesService) Dependencies() []string {
+	return nil
+}
+
 func (t *binariesService) Setup(deps service.ServiceDependencies, flags *service.ServiceFlags) error {
 	changedMount, err := createBinariesMount(deps, flags)
 	if err != nil {
@@ -74,6 +77,15 @@ func (t *binariesService) Setup(deps service.ServiceDependencies, flags *service
 	return nil
 }
 
+// Teardown removes the /usr/bin overlay mount.
+// The binaries in the overlay itself are left alone, since gluon is one of them.
+func (t *binariesService) Teardown(deps service.ServiceDependencies, flags *service.ServiceFlags) error {
+	if err := deps.Systemd.StopAndRemove(mountName, mountPath); err != nil {
+		return maskAny(err)
+	}
+	return maskAny(deps.Systemd.Reload())
+}
+
 func createBinariesMount(deps service.ServiceDependencies, flags *service.ServiceFlags) (bool, error) {
 	deps.Logger.Info("creating %s", mountPath)
 	opts := struct {
@@ -87,14 +99,13 @@ func createBinariesMount(deps service.ServiceDependencies, flags *service.Servic
 		UpperDir:   upperDir,
 		WorkDir:    workDir,
 	}
-	changed, err := templates.Render(deps.Logger, mountTemplate, mountPath, opts, fileMode)
+	changed, err := templates.Render(deps.Target, mountTemplate, mountPath, opts, fileMode)
 	return changed, maskAny(err)
 }
 
 func remount(deps service.ServiceDependencies, path string) error {
 	deps.Logger.Infof("Remounting %s", path)
-	cmd := exec.Command("mount", "-o", "remount", path)
-	output, err := cmd.CombinedOutput()
+	output, err := deps.Target.Run("mount", "-o", "remount", path)
 	if err != nil {
 		deps.Logger.Errorf("Remount failed: %s", string(output))
 		return maskAny(err)
@@ -0,0 +1,55 @@
+// Copyright (c) 2017 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import "fmt"
+
+// CA config
+type CA struct {
+	Mode string // vault|local
+}
+
+const (
+	// CAModeVault issues certificates using vault-monkey.
+	CAModeVault = "vault"
+	// CAModeLocal issues certificates using the cluster CA found in /etc/pulcy/ca.
+	CAModeLocal = "local"
+)
+
+// setupDefaults fills given flags with default value
+func (flags *CA) setupDefaults(cfg *Config) error {
+	if flags.Mode == "" {
+		flags.Mode = cfg.CA.Mode
+	}
+	if flags.Mode == "" {
+		flags.Mode = CAModeVault
+	}
+	if flags.Mode != CAModeVault && flags.Mode != CAModeLocal {
+		return maskAny(fmt.Errorf("Unsupported CA mode '%s', expected %s or %s", flags.Mode, CAModeVault, CAModeLocal))
+	}
+	return nil
+}
+
+// save applicable flags to the given configuration
+func (flags *CA) save(cfg *Config) {
+	if flags.Mode != "" {
+		cfg.CA.Mode = flags.Mode
+	}
+}
+
+// IsLocal returns true if certificates are issued by the local cluster CA.
+func (flags *CA) IsLocal() bool {
+	return flags.Mode == CAModeLocal
+}
@@ -0,0 +1,311 @@
+// Copyright (c) 2017 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/op/go-logging"
+
+	"github.com/pulcy/gluon/util"
+)
+
+const (
+	// ConfigPath is the path of the configuration file of gluon.
+	ConfigPath    = "/etc/pulcy/gluon.json"
+	configVersion = 1
+	gluonEnvPath  = "/etc/pulcy/gluon.env"
+)
+
+// Config is the content of /etc/pulcy/gluon.json.
+// It holds all settings of a node that are not passed on the commandline.
+type Config struct {
+	Version      int               `json:"version"`
+	GluonImage   string            `json:"gluon-image,omitempty"`
+	Roles        []string          `json:"roles,omitempty"`
+	Members      []ClusterMember   `json:"members,omitempty"`
+	MemberSource string            `json:"member-source,omitempty"`
+	Docker       DockerConfig      `json:"docker"`
+	Etcd         EtcdConfig        `json:"etcd"`
+	CA           CAConfig          `json:"ca"`
+	Kubernetes   KubernetesConfig  `json:"kubernetes"`
+	Weave        WeaveConfig       `json:"weave"`
+	Environment  map[string]string `json:"environment,omitempty"`
+}
+
+type DockerConfig struct {
+	PrivateRegistryUrl string `json:"private-registry-url,omitempty"`
+}
+
+// CAConfig configures where TLS certificates of etcd & kubernetes components come from.
+type CAConfig struct {
+	Mode string `json:"mode,omitempty"` // vault (default) or local
+}
+
+type EtcdConfig struct {
+	Version      int                    `json:"version,omitempty"` // Major version of ETCD (2|3)
+	ClusterState string                 `json:"cluster-state,omitempty"`
+	Backup       *EtcdBackupConfig      `json:"backup,omitempty"`
+	Maintenance  *EtcdMaintenanceConfig `json:"maintenance,omitempty"`
+}
+
+// EtcdMaintenanceConfig configures the periodic compaction & defragmentation of the ETCD backend database.
+type EtcdMaintenanceConfig struct {
+	Enabled           bool   `json:"enabled"`
+	Interval          string `json:"interval,omitempty"`            // Time between 2 maintenance runs (duration, e.g. 24h)
+	KeepRevisions     int64  `json:"keep-revisions,omitempty"`      // Number of key revisions kept by the compaction
+	QuotaBackendBytes int64  `json:"quota-backend-bytes,omitempty"` // Maximum size of the backend database (0 uses the ETCD default of 2GB)
+}
+
+// EtcdBackupConfig configures the periodic snapshots of the ETCD data on ETCD peers.
+type EtcdBackupConfig struct {
+	Enabled  bool   `json:"enabled"`
+	Dir      string `json:"dir,omitempty"`      // Local directory containing the snapshots
+	Interval string `json:"interval,omitempty"` // Time between 2 snapshots (systemd time span)
+	Keep     int    `json:"keep,omitempty"`     // Maximum number of local snapshots
+	MaxAge   string `json:"max-age,omitempty"`  // Local snapshots older than this are removed (e.g. 168h)
+	// S3 compatible storage snapshots are uploaded to (optional).
+	// Credentials are taken from AWS_ACCESS_KEY_ID & AWS_SECRET_ACCESS_KEY in /etc/pulcy/etcd-backup.env.
+	S3 *S3Config `json:"s3,omitempty"`
+}
+
+type S3Config struct {
+	Endpoint string `json:"endpoint"`         // URL of the storage (e.g. https://s3.eu-central-1.amazonaws.com or http://minio:9000)
+	Bucket   string `json:"bucket"`           // Name of the bucket
+	Region   string `json:"region,omitempty"` // Region of the bucket (default us-east-1)
+	Prefix   string `json:"prefix,omitempty"` // Prefix of the object names
+}
+
+type KubernetesConfig struct {
+	Metadata []string `json:"metadata,omitempty"`
+}
+
+type WeaveConfig struct {
+	Seed    string `json:"seed,omitempty"`
+	IPRange string `json:"iprange,omitempty"`
+	IPInit  string `json:"ipinit,omitempty"`
+}
+
+// LoadConfig reads /etc/pulcy/gluon.json from the given target.
+// Settings found in legacy files (e.g. /etc/pulcy/gluon-image) override the settings
+// in gluon.json, since such files are only written by older tools.
+// The paths of the legacy files that were imported are returned, so they can be removed
+// once the configuration has been saved.
+func LoadConfig(target *util.Target) (*Config, []string, error) {
+	cfg := &Config{Version: configVersion}
+	content, err := target.ReadFile(ConfigPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, maskAny(err)
+	} else if err == nil {
+		if err := json.Unmarshal(content, cfg); err != nil {
+			return nil, nil, maskAny(fmt.Errorf("Cannot parse %s: %v", ConfigPath, err))
+		}
+		if cfg.Version > configVersion {
+			return nil, nil, maskAny(fmt.Errorf("%s has version %d, this gluon supports up to version %d", ConfigPath, cfg.Version, configVersion))
+		}
+		cfg.Version = configVersion
+		for i := range cfg.Members {
+			cfg.Members[i].normalize()
+		}
+	}
+	legacyFiles, err := cfg.importLegacyFiles(target)
+	if err != nil {
+		return nil, nil, maskAny(err)
+	}
+	return cfg, legacyFiles, nil
+}
+
+// Save writes the configuration to /etc/pulcy/gluon.json on the given target.
+// Returns true if the file has changed, false otherwise
+func (cfg *Config) Save(target *util.Target) (bool, error) {
+	content, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return false, maskAny(err)
+	}
+	changed, err := updateContent(target, ConfigPath, string(content), 0644)
+	return changed, maskAny(err)
+}
+
+// UpdateConfig loads the configuration from the given target, applies the given update function
+// and saves the result. Legacy files that have been imported are removed.
+// Returns true if anything has changed, false otherwise
+func UpdateConfig(target *util.Target, update func(cfg *Config) error) (bool, error) {
+	cfg, legacyFiles, err := LoadConfig(target)
+	if err != nil {
+		return false, maskAny(err)
+	}
+	if err := update(cfg); err != nil {
+		return false, maskAny(err)
+	}
+	changed, err := cfg.Save(target)
+	if err != nil {
+		return false, maskAny(err)
+	}
+	for _, path := range legacyFiles {
+		if err := target.Remove(path); err != nil {
+			return false, maskAny(err)
+		}
+		changed = true
+	}
+	return changed, nil
+}
+
+// importLegacyFiles reads all legacy configuration files that exist on the
+// given target into the config. It returns the paths of all files that were found.
+func (cfg *Config) importLegacyFiles(target *util.Target) ([]string, error) {
+	var found []string
+	readLegacy := func(path string) ([]string, error) {
+		content, err := target.ReadFile(path)
+		if os.IsNotExist(err) {
+			return nil, nil
+		} else if err != nil {
+			return nil, maskAny(err)
+		}
+		found = append(found, path)
+		return trimLines(strings.Split(string(content), "\n")), nil
+	}
+	importString := func(path string, value *string, sep string) error {
+		lines, err := readLegacy(path)
+		if err != nil {
+			return maskAny(err)
+		}
+		if lines != nil {
+			*value = strings.Join(lines, sep)
+		}
+		return nil
+	}
+	importList := func(path string, value *[]string) error {
+		lines, err := readLegacy(path)
+		if err != nil {
+			return maskAny(err)
+		}
+		if lines != nil {
+			*value = splitList(strings.Join(lines, ","))
+		}
+		return nil
+	}
+
+	if err := importString(gluonImagePath, &cfg.GluonImage, ""); err != nil {
+		return nil, maskAny(err)
+	}
+	if err := importList(rolesPath, &cfg.Roles); err != nil {
+		return nil, maskAny(err)
+	}
+	if err := importString(privateRegistryUrlPath, &cfg.Docker.PrivateRegistryUrl, ""); err != nil {
+		return nil, maskAny(err)
+	}
+	if err := importString(etcdClusterStatePath, &cfg.Etcd.ClusterState, " "); err != nil {
+		return nil, maskAny(err)
+	}
+	if err := importList(obsoleteFleetMetadataPath, &cfg.Kubernetes.Metadata); err != nil {
+		return nil, maskAny(err)
+	}
+	if err := importList(kubeletMetadataPath, &cfg.Kubernetes.Metadata); err != nil {
+		return nil, maskAny(err)
+	}
+	if err := importString(weaveSeedPath, &cfg.Weave.Seed, ""); err != nil {
+		return nil, maskAny(err)
+	}
+	if err := importString(weaveIPRangePath, &cfg.Weave.IPRange, ""); err != nil {
+		return nil, maskAny(err)
+	}
+	if err := importString(weaveIPInitPath, &cfg.Weave.IPInit, ""); err != nil {
+		return nil, maskAny(err)
+	}
+	if lines, err := readLegacy(clusterMembersPath); err != nil {
+		return nil, maskAny(err)
+	} else if lines != nil {
+		cfg.Members = parseClusterMembers(lines, target.Logger)
+	}
+	if lines, err := readLegacy(gluonEnvPath); err != nil {
+		return nil, maskAny(err)
+	} else if lines != nil {
+		if cfg.Environment == nil {
+			cfg.Environment = make(map[string]string)
+		}
+		for k, v := range parseEnvironment(lines) {
+			cfg.Environment[k] = v
+		}
+	}
+	sort.Strings(found)
+	return found, nil
+}
+
+// parseClusterMembers parses the lines of a legacy /etc/pulcy/cluster-members file.
+// Every line has the format `<machine-id>=<cluster-ip> [etcd-proxy] [private-host-ip=<ip>]`.
+func parseClusterMembers(lines []string, log *logging.Logger) []ClusterMember {
+	members := []ClusterMember{}
+	for _, line := range lines {
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		id := parts[0]
+		parts = strings.Split(parts[1], " ")
+		clusterIP := parts[0]
+		privateHostIP := clusterIP
+		etcdProxy := false
+		for index, x := range parts {
+			if index == 0 {
+				continue
+			}
+			switch x {
+			case "etcd-proxy":
+				etcdProxy = true
+			default:
+				if strings.HasPrefix(x, privateHostIPPrefix) {
+					privateHostIP = x[len(privateHostIPPrefix):]
+				} else if log != nil {
+					log.Errorf("Unknown option '%s' in %s", x, clusterMembersPath)
+				}
+			}
+		}
+
+		members = append(members, ClusterMember{
+			MachineID:     id,
+			ClusterIP:     clusterIP,
+			PrivateHostIP: privateHostIP,
+			EtcdProxy:     etcdProxy,
+		})
+	}
+	return members
+}
+
+// parseEnvironment parses the lines of an environment file (key=value, # starts a comment).
+func parseEnvironment(lines []string) map[string]string {
+	result := make(map[string]string)
+	for _, line := range lines {
+		// Trim comments
+		parts := strings.SplitN(line, "#", 2)
+		line = strings.TrimSpace(parts[0])
+		// Split in key=value
+		parts = strings.SplitN(line, "=", 2)
+		if len(parts) == 2 {
+			key := strings.TrimSpace(parts[0])
+			value := strings.TrimSpace(parts[1])
+			result[key] = value
+		}
+	}
+	return result
+}
+
+// splitList splits a comma separated list, leaving out empty elements.
+func splitList(list string) []string {
+	return trimLines(strings.Split(list, ","))
+}
@@ -0,0 +1,92 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	logging "github.com/op/go-logging"
+
+	"github.com/pulcy/gluon/util"
+)
+
+func writeTestFile(t *testing.T, root, path, content string) {
+	path = filepath.Join(root, path)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLegacyFilesAreMigrated(t *testing.T) {
+	root, err := ioutil.TempDir("", "gluon-config-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	writeTestFile(t, root, clusterMembersPath, "b7bdc73a4e4311ee80cabd7d1e4658a1=192.168.0.1 private-host-ip=10.0.0.1\n")
+	writeTestFile(t, root, gluonImagePath, "pulcy/gluon:old\n")
+	writeTestFile(t, root, rolesPath, "core\nlb,worker\n")
+	writeTestFile(t, root, kubeletMetadataPath, "region=eu\nzone=a\n")
+	writeTestFile(t, root, weaveSeedPath, "seed1")
+	writeTestFile(t, root, gluonEnvPath, "FOO=bar # comment\n")
+
+	target := util.NewTarget(logging.MustGetLogger("test"), root, nil)
+	flags := &ServiceFlags{}
+	flags.GluonImage = "pulcy/gluon:new"
+	if err := flags.SetupDefaults(target); err != nil {
+		t.Fatalf("SetupDefaults failed: %v", err)
+	}
+	if flags.GluonImage != "pulcy/gluon:new" {
+		t.Errorf("Expected flag to take precedence, got %s", flags.GluonImage)
+	}
+	if len(flags.Roles) != 3 || flags.Roles[2] != "worker" {
+		t.Errorf("Unexpected roles %v", flags.Roles)
+	}
+	if flags.Kubernetes.Metadata != "region=eu,zone=a" {
+		t.Errorf("Unexpected metadata %s", flags.Kubernetes.Metadata)
+	}
+	if flags.Weave.Seed != "seed1" {
+		t.Errorf("Unexpected weave seed %s", flags.Weave.Seed)
+	}
+
+	if _, err := flags.Save(target); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	for _, path := range []string{clusterMembersPath, gluonImagePath, rolesPath, kubeletMetadataPath, weaveSeedPath, gluonEnvPath} {
+		if _, err := target.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be removed, got %v", path, err)
+		}
+	}
+
+	// Load again, now only from gluon.json
+	cfg, legacyFiles, err := LoadConfig(target)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if len(legacyFiles) != 0 {
+		t.Errorf("Expected no legacy files, got %v", legacyFiles)
+	}
+	if cfg.GluonImage != "pulcy/gluon:new" {
+		t.Errorf("Unexpected gluon image %s", cfg.GluonImage)
+	}
+	if len(cfg.Members) != 1 || cfg.Members[0].PrivateHostIP != "10.0.0.1" {
+		t.Errorf("Unexpected members %v", cfg.Members)
+	}
+	if cfg.Environment["FOO"] != "bar" {
+		t.Errorf("Unexpected environment %v", cfg.Environment)
+	}
+
+	// A legacy file written by an older tool overrides gluon.json
+	writeTestFile(t, root, gluonImagePath, "pulcy/gluon:rollback\n")
+	cfg, legacyFiles, err = LoadConfig(target)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if cfg.GluonImage != "pulcy/gluon:rollback" || len(legacyFiles) != 1 {
+		t.Errorf("Expected legacy gluon-image to be imported, got %s (%v)", cfg.GluonImage, legacyFiles)
+	}
+}
@@ -45,6 +45,10 @@ func (t *consulService) Name() string {
 	return "consul"
 }
 
+func (t *consulService) Dependencies() []string {
+	return []string{"binaries"}
+}
+
 func (t *consulService) Setup(deps service.ServiceDependencies, flags *service.ServiceFlags) error {
 	changed, err := createService(deps, flags)
 	if err != nil {
@@ -66,6 +70,13 @@ func (t *consulService) Setup(deps service.ServiceDependencies, flags *service.S
 	return nil
 }
 
+func (t *consulService) Teardown(deps service.ServiceDependencies, flags *service.ServiceFlags) error {
+	if err := deps.Systemd.StopAndRemove(consulServiceName, consulServicePath); err != nil {
+		return maskAny(err)
+	}
+	return maskAny(deps.Systemd.Reload())
+}
+
 func createService(deps service.ServiceDependencies, flags *service.ServiceFlags) (bool, error) {
 	deps.Logger.Info("creating %s", consulServicePath)
 	members, err := flags.GetClusterMembers(deps.Logger)
@@ -75,7 +86,7 @@ func createService(deps service.ServiceDependencies, flags *service.ServiceFlags
 	var join []string
 	bootstrapExpect := 0
 	for _, m := range members {
-		if !m.EtcdProxy {
+		if m.HasRole(service.RoleConsulServer) {
 			bootstrapExpect++
 			if m.ClusterIP != flags.Network.ClusterIP {
 				join = append(join, fmt.Sprintf("-retry-join %s", m.ClusterIP))
@@ -95,14 +106,14 @@ func createService(deps service.ServiceDependencies, flags *service.ServiceFlags
 	}{
 		Flags: args,
 	}
-	changed, err := templates.Render(deps.Logger, consulServiceTmpl, consulServicePath, opts, serviceFileMode)
+	changed, err := templates.Render(deps.Target, consulServiceTmpl, consulServicePath, opts, serviceFileMode)
 	return changed, maskAny(err)
 }
 
 func isServer(members []service.ClusterMember, flags *service.ServiceFlags) (bool, error) {
 	for _, member := range members {
 		if member.ClusterIP == flags.Network.ClusterIP {
-			return !member.EtcdProxy, nil
+			return member.HasRole(service.RoleConsulServer), nil
 		}
 	}
 
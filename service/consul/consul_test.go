@@ -0,0 +1,67 @@
+package consul
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	logging "github.com/op/go-logging"
+
+	"github.com/pulcy/gluon/service"
+	"github.com/pulcy/gluon/systemd"
+	"github.com/pulcy/gluon/util"
+)
+
+func TestSetupRestartsOnlyOnChange(t *testing.T) {
+	root, err := ioutil.TempDir("", "gluon-consul-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	membersPath := filepath.Join(root, "etc/pulcy/cluster-members")
+	if err := os.MkdirAll(filepath.Dir(membersPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	members := "" +
+		"b7bdc73a4e4311ee80cabd7d1e4658a1=192.168.0.1\n" +
+		"b7bdc73a4e4311ee80cabd7d1e4658a2=192.168.0.2\n" +
+		"b7bdc73a4e4311ee80cabd7d1e4658a3=192.168.0.3 etcd-proxy\n"
+	if err := ioutil.WriteFile(membersPath, []byte(members), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	log := logging.MustGetLogger("test")
+	target := util.NewTarget(log, root, nil)
+	flags := &service.ServiceFlags{}
+	flags.Network.ClusterIP = "192.168.0.1"
+	if err := flags.SetupDefaults(target); err != nil {
+		t.Fatalf("SetupDefaults failed: %v", err)
+	}
+	sdc := systemd.NewFakeClient()
+	deps := service.ServiceDependencies{
+		Systemd: sdc,
+		Logger:  log,
+		Target:  target,
+	}
+
+	// First run creates the unit, so consul must be restarted
+	if err := NewService().Setup(deps, flags); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	if !sdc.Called("restart", consulServiceName) {
+		t.Errorf("Expected %s to be restarted, got %v", consulServiceName, sdc.Calls())
+	}
+	if u := sdc.Unit(consulServiceName); !u.Enabled || !u.Active {
+		t.Errorf("Expected %s to be enabled & active, got %+v", consulServiceName, u)
+	}
+
+	// Second run changes nothing, so consul must be left alone
+	sdc.Reset()
+	if err := NewService().Setup(deps, flags); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	if calls := sdc.Calls(); len(calls) != 0 {
+		t.Errorf("Expected no systemd calls, got %v", calls)
+	}
+}
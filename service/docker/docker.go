@@ -24,7 +24,6 @@ import (
 
 	"github.com/pulcy/gluon/service"
 	"github.com/pulcy/gluon/templates"
-	"github.com/pulcy/gluon/util"
 )
 
 var (
@@ -71,6 +70,11 @@ func (t *dockerService) Name() string {
 	return "docker"
 }
 
+// Docker adds its own rules, so it must be restarted after iptables.
+func (t *dockerService) Dependencies() []string {
+	return []string{"binaries", "iptables"}
+}
+
 func (t *dockerService) Setup(deps service.ServiceDependencies, flags *service.ServiceFlags) error {
 	changedConfig1, err := createDockerConfig(rootConfigPath1, deps, flags)
 	if err != nil {
@@ -102,6 +106,19 @@ func (t *dockerService) Setup(deps service.ServiceDependencies, flags *service.S
 	return nil
 }
 
+// Teardown removes the docker service, its registry configuration and the cleanup script.
+func (t *dockerService) Teardown(deps service.ServiceDependencies, flags *service.ServiceFlags) error {
+	if err := deps.Systemd.StopAndRemove(ServiceName, servicePath); err != nil {
+		return maskAny(err)
+	}
+	for _, p := range []string{rootConfigPath1, rootConfigPath2, cleanupPath} {
+		if err := deps.Target.Remove(p); err != nil {
+			return maskAny(err)
+		}
+	}
+	return maskAny(deps.Systemd.Reload())
+}
+
 func createDockerService(deps service.ServiceDependencies, flags *service.ServiceFlags) (bool, error) {
 	deps.Logger.Info("creating %s", servicePath)
 	opts := struct {
@@ -113,7 +130,7 @@ func createDockerService(deps service.ServiceDependencies, flags *service.Servic
 		IPTables: !flags.Kubernetes.IsEnabled(),
 		IPMasq:   !flags.Kubernetes.IsEnabled(),
 	}
-	changed, err := templates.Render(deps.Logger, serviceTemplate, servicePath, opts, serviceFileMode)
+	changed, err := templates.Render(deps.Target, serviceTemplate, servicePath, opts, serviceFileMode)
 	return changed, maskAny(err)
 }
 
@@ -135,12 +152,12 @@ func createDockerConfig(rootConfigPath string, deps service.ServiceDependencies,
 		}
 
 		// Save
-		os.MkdirAll(filepath.Dir(rootConfigPath), 0700)
+		deps.Target.EnsureDirectory(filepath.Dir(rootConfigPath), 0700)
 		raw, err := json.MarshalIndent(cf, "", "\t")
 		if err != nil {
 			return false, maskAny(err)
 		}
-		changed, err := util.UpdateFile(deps.Logger, rootConfigPath, raw, 0600)
+		changed, err := deps.Target.UpdateFile(rootConfigPath, raw, 0600)
 		return changed, maskAny(err)
 	} else {
 		deps.Logger.Warning("Skip creating .docker config")
@@ -167,6 +184,6 @@ func createDockerCleanup(deps service.ServiceDependencies, flags *service.Servic
 		return false, maskAny(err)
 	}
 
-	changed, err := util.UpdateFile(deps.Logger, cleanupPath, asset, scriptFileMode)
+	changed, err := deps.Target.UpdateFile(cleanupPath, asset, scriptFileMode)
 	return changed, maskAny(err)
 }
@@ -20,11 +20,8 @@ import (
 
 	"github.com/juju/errgo"
 
-	"io/ioutil"
-
 	"github.com/pulcy/gluon/service"
 	"github.com/pulcy/gluon/templates"
-	"github.com/pulcy/gluon/util"
 )
 
 var (
@@ -49,6 +46,10 @@ func (t *envService) Name() string {
 	return "env"
 }
 
+func (t *envService) Dependencies() []string {
+	return nil
+}
+
 func (t *envService) Setup(deps service.ServiceDependencies, flags *service.ServiceFlags) error {
 	if err := createBashrc(deps, flags); err != nil {
 		return maskAny(err)
@@ -60,15 +61,21 @@ func (t *envService) Setup(deps service.ServiceDependencies, flags *service.Serv
 	return nil
 }
 
+// Teardown removes the generated .bashrc.
+// The nameserver added to /etc/resolv.conf is left in place, since that file is shared.
+func (t *envService) Teardown(deps service.ServiceDependencies, flags *service.ServiceFlags) error {
+	return maskAny(deps.Target.Remove(bashrcPath))
+}
+
 func createBashrc(deps service.ServiceDependencies, flags *service.ServiceFlags) error {
 	deps.Logger.Info("creating %s", bashrcPath)
-	os.Remove(bashrcPath)
+	deps.Target.Remove(bashrcPath)
 	opts := struct {
 		KubernetesEnabled bool
 	}{
 		KubernetesEnabled: flags.Kubernetes.IsEnabled(),
 	}
-	if _, err := templates.Render(deps.Logger, bashrcTemplate, bashrcPath, opts, fileMode); err != nil {
+	if _, err := templates.Render(deps.Target, bashrcTemplate, bashrcPath, opts, fileMode); err != nil {
 		return maskAny(err)
 	}
 
@@ -76,7 +83,7 @@ func createBashrc(deps service.ServiceDependencies, flags *service.ServiceFlags)
 }
 
 func addGoogleDNS(deps service.ServiceDependencies, flags *service.ServiceFlags) error {
-	content, err := ioutil.ReadFile(resolvConf)
+	content, err := deps.Target.ReadFile(resolvConf)
 	if os.IsNotExist(err) {
 		content = []byte("")
 	} else if err != nil {
@@ -93,7 +100,7 @@ func addGoogleDNS(deps service.ServiceDependencies, flags *service.ServiceFlags)
 	// Append google dns
 	lines = append(lines, dnsLine)
 	content = []byte(strings.Join(lines, "\n") + "\n")
-	if _, err := util.UpdateFile(deps.Logger, resolvConf, content, 0755); err != nil {
+	if _, err := deps.Target.UpdateFile(resolvConf, content, 0755); err != nil {
 		return maskAny(err)
 	}
 	return nil
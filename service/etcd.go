@@ -16,54 +16,89 @@ package service
 
 import (
 	"fmt"
-	"io/ioutil"
-	"os"
-	"strings"
-
-	"github.com/op/go-logging"
+	"time"
 )
 
 // ETCD
 type Etcd struct {
+	Version       int // Major version of ETCD (2|3)
 	ClusterState  string
-	UseVaultCA    bool // If set, use vault to create peer (and optional client) TLS certificates
+	UseVaultCA    bool // If set, use vault to create peer (and optional client) TLS certificates (the local CA mode always creates them)
 	SecureClients bool // If set, force clients to connect over TLS
 	ClientPort    int
+	Backup        EtcdBackupConfig      // Taken from the configuration file
+	Maintenance   EtcdMaintenanceConfig // Taken from the configuration file
 }
 
 const (
-	defaultEtcdClientPort = 2379
+	defaultEtcdVersion             = 2
+	defaultEtcdClientPort          = 2379
+	defaultEtcdBackupDir           = "/var/lib/etcd-backup"
+	defaultEtcdBackupInterval      = "1h"
+	defaultEtcdBackupKeep          = 24
+	defaultEtcdBackupMaxAge        = "168h"
+	defaultEtcdMaintenanceInterval = "24h"
+	defaultEtcdKeepRevisions       = 10000
 )
 
 // setupDefaults fills given flags with default value
-func (flags *Etcd) setupDefaults(log *logging.Logger) error {
+func (flags *Etcd) setupDefaults(cfg *Config) error {
 	if flags.ClientPort == 0 {
 		flags.ClientPort = defaultEtcdClientPort
 	}
 	if flags.ClusterState == "" {
-		raw, err := ioutil.ReadFile(etcdClusterStatePath)
-		if err != nil && !os.IsNotExist(err) {
-			return maskAny(err)
-		} else if err == nil {
-			lines := trimLines(strings.Split(string(raw), "\n"))
-			flags.ClusterState = strings.TrimSpace(strings.Join(lines, " "))
-		}
+		flags.ClusterState = cfg.Etcd.ClusterState
+	}
+	if flags.Version == 0 {
+		flags.Version = cfg.Etcd.Version
+	}
+	if flags.Version == 0 {
+		flags.Version = defaultEtcdVersion
+	}
+	if flags.Version != 2 && flags.Version != 3 {
+		return maskAny(fmt.Errorf("Unsupported etcd version %d, expected 2 or 3", flags.Version))
+	}
+	if cfg.Etcd.Backup != nil {
+		flags.Backup = *cfg.Etcd.Backup
+	}
+	if flags.Backup.Dir == "" {
+		flags.Backup.Dir = defaultEtcdBackupDir
+	}
+	if flags.Backup.Interval == "" {
+		flags.Backup.Interval = defaultEtcdBackupInterval
+	}
+	if flags.Backup.Keep == 0 {
+		flags.Backup.Keep = defaultEtcdBackupKeep
+	}
+	if flags.Backup.MaxAge == "" {
+		flags.Backup.MaxAge = defaultEtcdBackupMaxAge
+	}
+	if _, err := time.ParseDuration(flags.Backup.MaxAge); err != nil {
+		return maskAny(fmt.Errorf("Invalid etcd backup max-age '%s': %v", flags.Backup.MaxAge, err))
+	}
+	if cfg.Etcd.Maintenance != nil {
+		flags.Maintenance = *cfg.Etcd.Maintenance
+	}
+	if flags.Maintenance.Interval == "" {
+		flags.Maintenance.Interval = defaultEtcdMaintenanceInterval
+	}
+	if _, err := time.ParseDuration(flags.Maintenance.Interval); err != nil {
+		return maskAny(fmt.Errorf("Invalid etcd maintenance interval '%s': %v", flags.Maintenance.Interval, err))
+	}
+	if flags.Maintenance.KeepRevisions == 0 {
+		flags.Maintenance.KeepRevisions = defaultEtcdKeepRevisions
 	}
 	return nil
 }
 
-// save applicable flags to their respective files
-// Returns true if anything has changed, false otherwise
-func (flags *Etcd) save(log *logging.Logger) (bool, error) {
-	changes := 0
+// save applicable flags to the given configuration
+func (flags *Etcd) save(cfg *Config) {
 	if flags.ClusterState != "" {
-		if changed, err := updateContent(log, etcdClusterStatePath, flags.ClusterState, 0644); err != nil {
-			return false, maskAny(err)
-		} else if changed {
-			changes++
-		}
+		cfg.Etcd.ClusterState = flags.ClusterState
+	}
+	if flags.Version != 0 {
+		cfg.Etcd.Version = flags.Version
 	}
-	return (changes > 0), nil
 }
 
 // CreateEndpoint returns the client URL to reach an ETCD server at the given cluster IP.
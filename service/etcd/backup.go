@@ -0,0 +1,297 @@
+// Copyright (c) 2017 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcd
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/op/go-logging"
+
+	"github.com/pulcy/gluon/service"
+	"github.com/pulcy/gluon/templates"
+)
+
+const (
+	backupServiceName     = "etcd-backup.service"
+	backupServiceTemplate = "templates/etcd/" + backupServiceName + ".tmpl"
+	backupServicePath     = "/etc/systemd/system/" + backupServiceName
+	backupTimerName       = "etcd-backup.timer"
+	backupTimerTemplate   = "templates/etcd/" + backupTimerName + ".tmpl"
+	backupTimerPath       = "/etc/systemd/system/" + backupTimerName
+	backupEnvPath         = "/etc/pulcy/etcd-backup.env"
+
+	snapshotPrefix     = "etcd-"
+	snapshotSuffix     = ".tar.gz"
+	snapshotTimeFormat = "20060102-150405"
+)
+
+// setupBackup creates (or removes) the etcd-backup service & timer.
+func setupBackup(deps service.ServiceDependencies, flags *service.ServiceFlags) error {
+	if !flags.Etcd.Backup.Enabled {
+		return maskAny(removeBackupService(deps))
+	}
+	changedService, err := createBackupService(deps, flags)
+	if err != nil {
+		return maskAny(err)
+	}
+	changedTimer, err := createBackupTimer(deps, flags)
+	if err != nil {
+		return maskAny(err)
+	}
+	isActive, err := deps.Systemd.IsActive(backupTimerName)
+	if err != nil {
+		return maskAny(err)
+	}
+	if !isActive || changedService || changedTimer || flags.Force {
+		if err := deps.Systemd.Reload(); err != nil {
+			return maskAny(err)
+		}
+		if err := deps.Systemd.Enable(backupTimerName); err != nil {
+			return maskAny(err)
+		}
+		if err := deps.Systemd.Restart(backupTimerName); err != nil {
+			return maskAny(err)
+		}
+	}
+	return nil
+}
+
+// removeBackupService stops & removes the etcd-backup timer and service.
+// Existing snapshots are left in place.
+func removeBackupService(deps service.ServiceDependencies) error {
+	if err := deps.Systemd.StopAndRemove(backupTimerName, backupTimerPath); err != nil {
+		return maskAny(err)
+	}
+	return maskAny(deps.Systemd.StopAndRemove(backupServiceName, backupServicePath))
+}
+
+// createBackupService creates the etcd-backup service.
+func createBackupService(deps service.ServiceDependencies, flags *service.ServiceFlags) (bool, error) {
+	deps.Logger.Info("creating %s", backupServicePath)
+	opts := struct {
+		EnvPath string
+		After   string
+	}{
+		EnvPath: backupEnvPath,
+		After:   layoutFor(flags.Etcd.Version).ServiceName,
+	}
+	changed, err := templates.Render(deps.Target, backupServiceTemplate, backupServicePath, opts, serviceFileMode)
+	return changed, maskAny(err)
+}
+
+// createBackupTimer creates the etcd-backup timer.
+func createBackupTimer(deps service.ServiceDependencies, flags *service.ServiceFlags) (bool, error) {
+	deps.Logger.Info("creating %s", backupTimerPath)
+	opts := struct {
+		Interval string
+	}{
+		Interval: flags.Etcd.Backup.Interval,
+	}
+	changed, err := templates.Render(deps.Target, backupTimerTemplate, backupTimerPath, opts, serviceFileMode)
+	return changed, maskAny(err)
+}
+
+// Backup takes a snapshot of the ETCD data of this machine, stores it in the backup directory,
+// uploads it (when configured) and removes snapshots according to the retention settings.
+// Returns the path of the snapshot.
+func Backup(deps service.ServiceDependencies, flags *service.ServiceFlags) (string, error) {
+	cfg := flags.Etcd.Backup
+	layout := layoutFor(flags.Etcd.Version)
+	if err := os.MkdirAll(cfg.Dir, 0700); err != nil {
+		return "", maskAny(err)
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", maskAny(err)
+	}
+
+	// Let etcdctl create a consistent copy of the data directory
+	tmpDir, err := ioutil.TempDir(cfg.Dir, ".backup-")
+	if err != nil {
+		return "", maskAny(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	if out, err := deps.Target.Run("/usr/bin/env", "ETCDCTL_API=2", "etcdctl", "backup", "--data-dir", layout.DataPath, "--backup-dir", tmpDir, "--with-v3"); err != nil {
+		return "", maskAny(fmt.Errorf("etcdctl backup failed: %v %s", err, strings.TrimSpace(string(out))))
+	}
+
+	name := fmt.Sprintf("%s%s-%s%s", snapshotPrefix, hostname, time.Now().UTC().Format(snapshotTimeFormat), snapshotSuffix)
+	snapshotPath := filepath.Join(cfg.Dir, name)
+	if err := createArchive(tmpDir, snapshotPath); err != nil {
+		return "", maskAny(err)
+	}
+	deps.Logger.Infof("Created snapshot %s", snapshotPath)
+
+	if cfg.S3 != nil {
+		content, err := ioutil.ReadFile(snapshotPath)
+		if err != nil {
+			return "", maskAny(err)
+		}
+		if err := uploadS3(*cfg.S3, name, content); err != nil {
+			return "", maskAny(err)
+		}
+		deps.Logger.Infof("Uploaded snapshot to %s/%s", cfg.S3.Endpoint, cfg.S3.Bucket)
+	}
+
+	maxAge, err := time.ParseDuration(cfg.MaxAge)
+	if err != nil {
+		return "", maskAny(err)
+	}
+	if err := pruneSnapshots(cfg.Dir, cfg.Keep, maxAge, time.Now(), deps.Logger); err != nil {
+		return "", maskAny(err)
+	}
+	return snapshotPath, nil
+}
+
+// pruneSnapshots removes the oldest snapshots in the given directory, so at most keep snapshots
+// remain, and removes all snapshots older than maxAge. The newest snapshot is always kept.
+func pruneSnapshots(dir string, keep int, maxAge time.Duration, now time.Time, log *logging.Logger) error {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return maskAny(err)
+	}
+	var snapshots []os.FileInfo
+	for _, info := range infos {
+		if !info.IsDir() && strings.HasPrefix(info.Name(), snapshotPrefix) && strings.HasSuffix(info.Name(), snapshotSuffix) {
+			snapshots = append(snapshots, info)
+		}
+	}
+	// Newest first
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].ModTime().After(snapshots[j].ModTime()) })
+	for i, info := range snapshots {
+		if i == 0 {
+			continue
+		}
+		if (keep > 0 && i >= keep) || (maxAge > 0 && now.Sub(info.ModTime()) > maxAge) {
+			log.Infof("Removing snapshot %s", info.Name())
+			if err := os.Remove(filepath.Join(dir, info.Name())); err != nil {
+				return maskAny(err)
+			}
+		}
+	}
+	return nil
+}
+
+// createArchive writes a gzipped tar archive of the content of the given directory to the given path.
+// The archive is written to a temporary file first, so an interrupted backup never leaves a partial snapshot.
+func createArchive(srcDir, archivePath string) error {
+	tmpPath := archivePath + ".part"
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return maskAny(err)
+	}
+	defer os.Remove(tmpPath)
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+	err = filepath.Walk(srcDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return maskAny(err)
+		}
+		rel, err := filepath.Rel(srcDir, p)
+		if err != nil || rel == "." {
+			return maskAny(err)
+		}
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return maskAny(err)
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if err := tw.WriteHeader(hdr); err != nil {
+			return maskAny(err)
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		src, err := os.Open(p)
+		if err != nil {
+			return maskAny(err)
+		}
+		defer src.Close()
+		_, err = io.Copy(tw, src)
+		return maskAny(err)
+	})
+	if err != nil {
+		f.Close()
+		return maskAny(err)
+	}
+	if err := tw.Close(); err != nil {
+		f.Close()
+		return maskAny(err)
+	}
+	if err := gw.Close(); err != nil {
+		f.Close()
+		return maskAny(err)
+	}
+	if err := f.Close(); err != nil {
+		return maskAny(err)
+	}
+	return maskAny(os.Rename(tmpPath, archivePath))
+}
+
+// extractArchive extracts the gzipped tar archive at the given path into the given directory.
+func extractArchive(archivePath, destDir string) error {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return maskAny(err)
+	}
+	defer f.Close()
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return maskAny(err)
+	}
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return maskAny(err)
+		}
+		p := filepath.Join(destDir, filepath.FromSlash(hdr.Name))
+		if !strings.HasPrefix(p, filepath.Clean(destDir)+string(filepath.Separator)) {
+			return maskAny(fmt.Errorf("Invalid path '%s' in %s", hdr.Name, archivePath))
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(p, 0700); err != nil {
+				return maskAny(err)
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+				return maskAny(err)
+			}
+			out, err := os.OpenFile(p, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(hdr.Mode)&os.ModePerm)
+			if err != nil {
+				return maskAny(err)
+			}
+			if _, err := io.Copy(out, tr); err != nil {
+				out.Close()
+				return maskAny(err)
+			}
+			if err := out.Close(); err != nil {
+				return maskAny(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package etcd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/op/go-logging"
+)
+
+func TestPruneSnapshots(t *testing.T) {
+	dir, err := ioutil.TempDir("", "etcd-backup-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	for i, age := range []time.Duration{time.Hour, 2 * time.Hour, 3 * time.Hour, 30 * time.Hour} {
+		p := filepath.Join(dir, snapshotPrefix+string('a'+rune(i))+snapshotSuffix)
+		if err := ioutil.WriteFile(p, nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(p, now.Add(-age), now.Add(-age)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "other"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	log := logging.MustGetLogger("test")
+	// Keep at most 3, none older than a day
+	if err := pruneSnapshots(dir, 3, 24*time.Hour, now, log); err != nil {
+		t.Fatal(err)
+	}
+	expectFiles(t, dir, "etcd-a.tar.gz", "etcd-b.tar.gz", "etcd-c.tar.gz", "other")
+
+	// The newest snapshot is never removed
+	if err := pruneSnapshots(dir, 0, time.Minute, now, log); err != nil {
+		t.Fatal(err)
+	}
+	expectFiles(t, dir, "etcd-a.tar.gz", "other")
+}
+
+func TestArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "etcd-archive-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "src")
+	if err := os.MkdirAll(filepath.Join(src, "member", "wal"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "member", "wal", "0.wal"), []byte("data"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	archive := filepath.Join(dir, "snapshot.tar.gz")
+	if err := createArchive(src, archive); err != nil {
+		t.Fatal(err)
+	}
+	dest := filepath.Join(dir, "dest")
+	if err := extractArchive(archive, dest); err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadFile(filepath.Join(dest, "member", "wal", "0.wal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "data" {
+		t.Errorf("Unexpected content '%s'", content)
+	}
+}
+
+func expectFiles(t *testing.T, dir string, expected ...string) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+	sort.Strings(names)
+	if len(names) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, names)
+	}
+	for i := range names {
+		if names[i] != expected[i] {
+			t.Fatalf("Expected %v, got %v", expected, names)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright (c) 2017 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcd
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/op/go-logging"
+
+	"github.com/pulcy/gluon/etcdclient"
+	"github.com/pulcy/gluon/service"
+)
+
+// NewClient creates a client for the ETCD peers of the cluster.
+// The peer running on this machine (if any) is tried first.
+func NewClient(flags *service.ServiceFlags, log *logging.Logger) (*etcdclient.Client, error) {
+	members, err := flags.GetClusterMembers(log)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	var endpoints []string
+	for _, m := range members {
+		if !m.HasRole(service.RoleEtcd) {
+			continue
+		}
+		ep := flags.Etcd.CreateEndpoint(m.ClusterIP)
+		if m.ClusterIP == flags.Network.ClusterIP {
+			endpoints = append([]string{ep}, endpoints...)
+		} else {
+			endpoints = append(endpoints, ep)
+		}
+	}
+	if len(endpoints) == 0 {
+		return nil, maskAny(fmt.Errorf("No etcd members found"))
+	}
+	return newClient(flags, endpoints)
+}
+
+// newClient creates a client for the given endpoints.
+// When clients must use TLS, the certificates of this machine are used.
+func newClient(flags *service.ServiceFlags, endpoints []string) (*etcdclient.Client, error) {
+	var tlsConfig *tls.Config
+	if flags.Etcd.SecureClients {
+		cert, err := tls.LoadX509KeyPair(CertsCertPath, CertsKeyPath)
+		if err != nil {
+			return nil, maskAny(err)
+		}
+		ca, err := ioutil.ReadFile(CertsCAPath)
+		if err != nil {
+			return nil, maskAny(err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, maskAny(fmt.Errorf("No certificates found in %s", CertsCAPath))
+		}
+		tlsConfig = &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			RootCAs:      pool,
+		}
+	}
+	return etcdclient.NewClient(endpoints, tlsConfig), nil
+}
@@ -17,7 +17,6 @@ package etcd
 import (
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 
@@ -35,20 +34,18 @@ var (
 )
 
 const (
-	confName             = "99-etcd2.conf"
-	confDir              = "/etc/systemd/system/" + serviceName + ".d/"
-	confPath             = confDir + confName
-	serviceName          = "etcd2.service"
 	serviceTemplate      = "templates/etcd/etcd.service.tmpl"
-	servicePath          = "/etc/systemd/system/" + serviceName
+	gatewayServiceName   = "etcd-gateway.service"
+	gatewayTemplate      = "templates/etcd/" + gatewayServiceName + ".tmpl"
+	gatewayServicePath   = "/etc/systemd/system/" + gatewayServiceName
 	environmentPath      = "/etc/environment"
 	etcdUser             = "etcd"
-	dataPath             = "/var/lib/etcd2"
 	initTemplate         = "templates/etcd/etcd-init.sh.tmpl"
 	initPath             = "/root/etcd-init.sh"
 	certsServiceName     = "etcd-certs.service"
 	certsServiceTemplate = "templates/etcd/" + certsServiceName + ".tmpl"
 	certsServicePath     = "/etc/systemd/system/" + certsServiceName
+	localCertsTemplate   = "templates/ca/certs.service.tmpl"
 	certsTimerName       = "etcd-certs.timer"
 	certsTimerTemplate   = "templates/etcd/" + certsTimerName + ".tmpl"
 	certsTimerPath       = "/etc/systemd/system/" + certsTimerName
@@ -63,6 +60,52 @@ const (
 	initFileMode = os.FileMode(0755)
 )
 
+// etcdLayout describes the systemd unit & data directory used for an ETCD major version.
+type etcdLayout struct {
+	Description string
+	ServiceName string
+	ConfName    string // Name of the drop-in containing the generated configuration
+	DataPath    string
+}
+
+var (
+	layoutV2 = etcdLayout{
+		Description: "etcd2",
+		ServiceName: "etcd2.service",
+		ConfName:    "99-etcd2.conf",
+		DataPath:    "/var/lib/etcd2",
+	}
+	layoutV3 = etcdLayout{
+		Description: "etcd",
+		ServiceName: "etcd-member.service",
+		ConfName:    "99-etcd.conf",
+		DataPath:    "/var/lib/etcd",
+	}
+)
+
+// layoutFor returns the layout of the given ETCD major version.
+func layoutFor(version int) etcdLayout {
+	if version == 3 {
+		return layoutV3
+	}
+	return layoutV2
+}
+
+// ServicePath returns the path of the systemd unit.
+func (l etcdLayout) ServicePath() string {
+	return "/etc/systemd/system/" + l.ServiceName
+}
+
+// ConfDir returns the path of the drop-in directory of the systemd unit.
+func (l etcdLayout) ConfDir() string {
+	return l.ServicePath() + ".d/"
+}
+
+// ConfPath returns the path of the drop-in containing the generated configuration.
+func (l etcdLayout) ConfPath() string {
+	return l.ConfDir() + l.ConfName
+}
+
 func NewService() service.Service {
 	return &etcdService{}
 }
@@ -73,18 +116,40 @@ func (t *etcdService) Name() string {
 	return "etcd"
 }
 
+// CertificateFiles returns the paths of the etcd certificates, if they are created by vault or the local CA.
+func (t *etcdService) CertificateFiles(flags *service.ServiceFlags) []string {
+	if !useCertificates(flags) {
+		return nil
+	}
+	return []string{CertsCertPath, CertsKeyPath, CertsCAPath}
+}
+
+// The etcd certificates are fetched from vault using a docker container.
+func (t *etcdService) Dependencies() []string {
+	return []string{"docker", "vault"}
+}
+
 func (t *etcdService) Setup(deps service.ServiceDependencies, flags *service.ServiceFlags) error {
 	cfg, err := createEtcdConfig(deps, flags)
 	if err != nil {
 		return maskAny(err)
 	}
+	layout := layoutFor(cfg.Version)
+	if !cfg.IsProxy {
+		if err := checkMigrated(deps, cfg.Version); err != nil {
+			return maskAny(err)
+		}
+		if err := reconcileMembership(deps, flags, &cfg); err != nil {
+			return maskAny(err)
+		}
+	}
 
 	_, err = createEtcdEnvironment(deps, cfg)
 	if err != nil {
 		return maskAny(err)
 	}
 
-	if err := createEtcdUserAndPath(deps); err != nil {
+	if err := createEtcdUserAndPath(deps, layout); err != nil {
 		return maskAny(err)
 	}
 	if err := addCoreToEtcdGroup(deps, flags); err != nil {
@@ -92,7 +157,7 @@ func (t *etcdService) Setup(deps service.ServiceDependencies, flags *service.Ser
 	}
 
 	var certsTimerChanged, certsServiceChanged bool
-	if flags.Etcd.UseVaultCA {
+	if cfg.UseCertificates {
 		// Create etcd-certs.service and template file
 		if certsServiceChanged, err = createCertsService(deps, flags, cfg); err != nil {
 			return maskAny(err)
@@ -123,78 +188,154 @@ func (t *etcdService) Setup(deps service.ServiceDependencies, flags *service.Ser
 			}
 		}
 	} else {
-		// etcd-certs.timer no longer needed, remove it
-		if exists, err := deps.Systemd.Exists(certsTimerName); err != nil {
+		// etcd-certs.timer & service no longer needed, remove them
+		if err := removeCertsService(deps); err != nil {
 			return maskAny(err)
-		} else if exists {
-			if err := deps.Systemd.Disable(certsTimerName); err != nil {
-				deps.Logger.Errorf("Disabling %s failed: %#v", certsTimerName, err)
-			} else {
-				os.Remove(certsTimerPath)
-			}
 		}
-		// etcd-certs.service no longer needed, remove it
-		if exists, err := deps.Systemd.Exists(certsServiceName); err != nil {
-			return maskAny(err)
-		} else if exists {
-			if err := deps.Systemd.Disable(certsServiceName); err != nil {
-				deps.Logger.Errorf("Disabling %s failed: %#v", certsServiceName, err)
-			} else {
-				os.Remove(certsServicePath)
+	}
+
+	// Remove the service of the other major version
+	for _, l := range []etcdLayout{layoutV2, layoutV3} {
+		if l != layout {
+			if err := removeService(deps, l); err != nil {
+				return maskAny(err)
 			}
 		}
 	}
 
 	if cfg.IsProxy {
 		// We do not want an etcd service, remove it
-		if exists, err := deps.Systemd.Exists(serviceName); err != nil {
+		if err := removeService(deps, layout); err != nil {
 			return maskAny(err)
-		} else if exists {
-			if err := deps.Systemd.Disable(serviceName); err != nil {
-				deps.Logger.Errorf("Disabling %s failed: %#v", serviceName, err)
-			} else {
-				os.Remove(servicePath)
-				os.RemoveAll(confDir)
+		}
+		if err := removeBackupService(deps); err != nil {
+			return maskAny(err)
+		}
+		if err := removeMaintenanceService(deps); err != nil {
+			return maskAny(err)
+		}
+		if cfg.Version == 3 {
+			// Forward local clients to the cluster using the v3 gateway
+			if err := setupGateway(deps, cfg, flags.Force); err != nil {
+				return maskAny(err)
 			}
+		} else if err := removeGateway(deps); err != nil {
+			return maskAny(err)
 		}
 	} else {
+		if err := removeGateway(deps); err != nil {
+			return maskAny(err)
+		}
 
-		changedService, err := createService(deps, flags)
+		changedService, err := createService(deps, flags, layout)
 		if err != nil {
 			return maskAny(err)
 		}
-		changedConf, err := createEtcd2Conf(deps, cfg)
+		changedConf, err := createEtcdConf(deps, cfg, layout)
 		if err != nil {
 			return maskAny(err)
 		}
 		if err := deps.Systemd.Reload(); err != nil {
 			return maskAny(err)
 		}
-		isActive, err := deps.Systemd.IsActive(serviceName)
+		isActive, err := deps.Systemd.IsActive(layout.ServiceName)
 		if err != nil {
 			return maskAny(err)
 		}
 
 		if !isActive || certsServiceChanged || changedService || changedConf || flags.Force {
-			if err := deps.Systemd.Enable(serviceName); err != nil {
+			if err := deps.Systemd.Enable(layout.ServiceName); err != nil {
 				return maskAny(err)
 			}
 			if err := deps.Systemd.Reload(); err != nil {
 				return maskAny(err)
 			}
-			if err := deps.Systemd.Restart(serviceName); err != nil {
+			if err := deps.Systemd.Restart(layout.ServiceName); err != nil {
 				return maskAny(err)
 			}
 		}
+
+		if err := setupBackup(deps, flags); err != nil {
+			return maskAny(err)
+		}
+		if err := setupMaintenance(deps, flags); err != nil {
+			return maskAny(err)
+		}
+	}
+
+	return nil
+}
+
+// Teardown removes the etcd service or gateway, its certificate, backup & maintenance services and timers and the init script.
+// The etcd data directory, the snapshots and the variables added to /etc/environment are left in place.
+func (t *etcdService) Teardown(deps service.ServiceDependencies, flags *service.ServiceFlags) error {
+	for _, l := range []etcdLayout{layoutV2, layoutV3} {
+		if err := removeService(deps, l); err != nil {
+			return maskAny(err)
+		}
+	}
+	if err := removeGateway(deps); err != nil {
+		return maskAny(err)
+	}
+	if err := removeCertsService(deps); err != nil {
+		return maskAny(err)
 	}
+	if err := removeBackupService(deps); err != nil {
+		return maskAny(err)
+	}
+	if err := removeMaintenanceService(deps); err != nil {
+		return maskAny(err)
+	}
+	if err := deps.Target.Remove(initPath); err != nil {
+		return maskAny(err)
+	}
+	return maskAny(deps.Systemd.Reload())
+}
+
+// removeService stops & removes the etcd service of the given layout and its drop-in configuration.
+// Nothing is done when the configuration drop-in of gluon does not exist, since the unit
+// (e.g. the etcd-member.service shipped with the OS) is then not managed by gluon.
+func removeService(deps service.ServiceDependencies, layout etcdLayout) error {
+	if _, err := deps.Target.Stat(layout.ConfPath()); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return maskAny(err)
+	}
+	return maskAny(deps.Systemd.StopAndRemove(layout.ServiceName, layout.ServicePath(), layout.ConfDir()))
+}
 
+// checkMigrated returns an error when the given version is 3 and this machine still has
+// ETCD v2 data that has not been migrated yet, since starting ETCD v3 would create a new empty member.
+func checkMigrated(deps service.ServiceDependencies, version int) error {
+	if version != 3 {
+		return nil
+	}
+	if _, err := deps.Target.Stat(filepath.Join(layoutV3.DataPath, "member")); err == nil {
+		return nil
+	}
+	if _, err := deps.Target.Stat(filepath.Join(layoutV2.DataPath, "member")); err == nil {
+		return maskAny(fmt.Errorf("%s contains etcd v2 data, run 'gluon etcd migrate' before switching to etcd v3", layoutV2.DataPath))
+	}
 	return nil
 }
 
+// useCertificates returns true if ETCD uses certificates issued by vault or the local CA,
+// false if it uses automatically generated peer certificates.
+func useCertificates(flags *service.ServiceFlags) bool {
+	return flags.Etcd.UseVaultCA || flags.CA.IsLocal()
+}
+
+// removeCertsService stops & removes the etcd-certs timer and service.
+func removeCertsService(deps service.ServiceDependencies) error {
+	if err := deps.Systemd.StopAndRemove(certsTimerName, certsTimerPath); err != nil {
+		return maskAny(err)
+	}
+	return maskAny(deps.Systemd.StopAndRemove(certsServiceName, certsServicePath))
+}
+
 func addCoreToEtcdGroup(deps service.ServiceDependencies, flags *service.ServiceFlags) error {
 	for _, g := range []string{"etcd"} {
-		cmd := exec.Command("gpasswd", "-a", "core", g)
-		out, err := cmd.CombinedOutput()
+		out, err := deps.Target.Run("gpasswd", "-a", "core", g)
 		if err != nil {
 			fmt.Println(string(out))
 			return maskAny(err)
@@ -203,22 +344,27 @@ func addCoreToEtcdGroup(deps service.ServiceDependencies, flags *service.Service
 	return nil
 }
 
-// createEtcdUserAndPath ensures the ETCD user exists.
-func createEtcdUserAndPath(deps service.ServiceDependencies) error {
+// createEtcdUserAndPath ensures the ETCD user and the data directory of the given layout exist.
+func createEtcdUserAndPath(deps service.ServiceDependencies, layout etcdLayout) error {
 	deps.Logger.Info("creating %s", initPath)
-	if _, err := templates.Render(deps.Logger, initTemplate, initPath, nil, initFileMode); err != nil {
+	opts := struct {
+		DataPath string
+	}{
+		DataPath: layout.DataPath,
+	}
+	if _, err := templates.Render(deps.Target, initTemplate, initPath, opts, initFileMode); err != nil {
 		return maskAny(err)
 	}
 	// Call init script
 	deps.Logger.Info("running %s", initPath)
-	cmd := exec.Command(initPath)
-	if err := cmd.Run(); err != nil {
+	if _, err := deps.Target.Run(initPath); err != nil {
 		return maskAny(err)
 	}
 	return nil
 }
 
 type etcdConfig struct {
+	Version             int // Major version of ETCD
 	ClusterState        string
 	ClusterIP           string
 	IsProxy             bool
@@ -229,11 +375,13 @@ type etcdConfig struct {
 	ListenClientURLs    string // Listen URLs for client-ETCD communication
 	AdvertiseClientURLs string // Advertised URLs for client-ETCD communication
 	Endpoints           string // URLs for client-ETCD communication
+	GatewayEndpoints    string // host:port of all ETCD peers, used by the v3 gateway
+	QuotaBackendBytes   int64  // Maximum size of the backend database (0 for the default)
 	InitialCluster      string
 	Host                string // IP of 1 ETCD host
 	Port                string // Port of 1 ETCD host
 	Scheme              string // URL scheme of 1 ETCD host
-	UseVaultCA          bool
+	UseCertificates     bool   // If set, use certificates issued by vault or the local CA
 	SecureClients       bool
 }
 
@@ -248,12 +396,15 @@ func createEtcdConfig(deps service.ServiceDependencies, flags *service.ServiceFl
 	}
 
 	result := etcdConfig{
-		ClusterIP:     flags.Network.ClusterIP,
-		UseVaultCA:    flags.Etcd.UseVaultCA,
-		SecureClients: flags.Etcd.SecureClients,
+		Version:           flags.Etcd.Version,
+		QuotaBackendBytes: flags.Etcd.Maintenance.QuotaBackendBytes,
+		ClusterIP:         flags.Network.ClusterIP,
+		UseCertificates:   useCertificates(flags),
+		SecureClients:     flags.Etcd.SecureClients,
 	}
 	initialCluster := []string{}
 	endpoints := []string{}
+	gatewayEndpoints := []string{}
 	hosts := []string{}
 	clientPort := flags.Etcd.ClientPort
 	result.ClusterState = flags.Etcd.ClusterState
@@ -266,19 +417,21 @@ func createEtcdConfig(deps service.ServiceDependencies, flags *service.ServiceFl
 		clientScheme = "https"
 	}
 	for index, cm := range members {
-		if !cm.EtcdProxy {
+		isPeer := cm.HasRole(service.RoleEtcd)
+		if isPeer {
 			initialCluster = append(initialCluster,
 				fmt.Sprintf("%s=https://%s:2380", cm.MachineID, cm.ClusterIP),
 				fmt.Sprintf("%s=https://%s:2381", cm.MachineID, cm.PrivateHostIP),
 			)
 			endpoints = append(endpoints, fmt.Sprintf("%s://%s:%d", clientScheme, cm.ClusterIP, clientPort))
+			gatewayEndpoints = append(gatewayEndpoints, fmt.Sprintf("%s:%d", cm.ClusterIP, clientPort))
 			hosts = append(hosts, cm.ClusterIP)
 		}
 		if cm.ClusterIP == flags.Network.ClusterIP {
 			result.Name = cm.MachineID
-			result.IsProxy = cm.EtcdProxy
+			result.IsProxy = !isPeer
 			result.PrivateHostIP = cm.PrivateHostIP
-			if cm.EtcdProxy {
+			if !isPeer {
 				memberIndex = index
 			} else {
 				memberIndex = len(hosts) - 1
@@ -299,8 +452,12 @@ func createEtcdConfig(deps service.ServiceDependencies, flags *service.ServiceFl
 		fmt.Sprintf("%s://%s:4001", clientScheme, flags.Network.ClusterIP),
 	}, ",")
 
+	if len(hosts) == 0 {
+		return etcdConfig{}, maskAny(fmt.Errorf("No etcd members found, all cluster members are etcd proxies"))
+	}
 	result.InitialCluster = strings.Join(initialCluster, ",")
 	result.Endpoints = strings.Join(endpoints, ",")
+	result.GatewayEndpoints = strings.Join(gatewayEndpoints, ",")
 	result.Host = hosts[memberIndex%len(hosts)]
 	result.Port = strconv.Itoa(clientPort)
 	result.Scheme = clientScheme
@@ -312,11 +469,36 @@ func createEtcdConfig(deps service.ServiceDependencies, flags *service.ServiceFl
 // createCertsService creates the etcd-certs service.
 func createCertsService(deps service.ServiceDependencies, flags *service.ServiceFlags, config etcdConfig) (bool, error) {
 	deps.Logger.Info("creating %s", certsServicePath)
-	clusterID, err := flags.ReadClusterID()
+	hostname, err := os.Hostname()
 	if err != nil {
 		return false, maskAny(err)
 	}
-	hostname, err := os.Hostname()
+	ipSans := []string{config.ClusterIP, config.PrivateHostIP, "127.0.0.1"}
+	if flags.CA.IsLocal() {
+		opts := struct {
+			Description string
+			CommonName  string
+			AltNames    []string
+			IPSans      []string
+			CertPath    string
+			KeyPath     string
+			CAPath      string
+			FileMode    uint32
+			Owner       string
+		}{
+			Description: "ETCD certificates",
+			CommonName:  hostname,
+			IPSans:      ipSans,
+			CertPath:    CertsCertPath,
+			KeyPath:     CertsKeyPath,
+			CAPath:      CertsCAPath,
+			FileMode:    0660,
+			Owner:       etcdUser + ":" + etcdUser,
+		}
+		changed, err := templates.Render(deps.Target, localCertsTemplate, certsServicePath, opts, serviceFileMode)
+		return changed, maskAny(err)
+	}
+	clusterID, err := flags.ReadClusterID()
 	if err != nil {
 		return false, maskAny(err)
 	}
@@ -341,7 +523,7 @@ func createCertsService(deps service.ServiceDependencies, flags *service.Service
 		CommonName:         hostname,
 		Role:               "member",
 		AltNames:           nil,
-		IPSans:             []string{config.ClusterIP, config.PrivateHostIP, "127.0.0.1"},
+		IPSans:             ipSans,
 		CertFileName:       filepath.Base(CertsCertPath),
 		KeyFileName:        filepath.Base(CertsKeyPath),
 		CAFileName:         filepath.Base(CertsCAPath),
@@ -351,38 +533,49 @@ func createCertsService(deps service.ServiceDependencies, flags *service.Service
 		CAPath:             CertsCAPath,
 		FileMode:           0660,
 	}
-	changed, err := templates.Render(deps.Logger, certsServiceTemplate, certsServicePath, opts, serviceFileMode)
+	changed, err := templates.Render(deps.Target, certsServiceTemplate, certsServicePath, opts, serviceFileMode)
 	return changed, maskAny(err)
 }
 
 // createCertsTimer creates the etcd-certs timer.
 func createCertsTimer(deps service.ServiceDependencies, flags *service.ServiceFlags) (bool, error) {
 	deps.Logger.Info("creating %s", certsTimerPath)
-	changed, err := templates.Render(deps.Logger, certsTimerTemplate, certsTimerPath, nil, serviceFileMode)
+	changed, err := templates.Render(deps.Target, certsTimerTemplate, certsTimerPath, nil, serviceFileMode)
 	return changed, maskAny(err)
 }
 
-func createService(deps service.ServiceDependencies, flags *service.ServiceFlags) (bool, error) {
-	deps.Logger.Info("creating %s", servicePath)
+func createService(deps service.ServiceDependencies, flags *service.ServiceFlags, layout etcdLayout) (bool, error) {
+	deps.Logger.Info("creating %s", layout.ServicePath())
 	opts := struct {
-		Requires []string
-		After    []string
+		Description string
+		DataPath    string
+		Conflicts   []string
+		Requires    []string
+		After       []string
 	}{
-		Requires: []string{},
-		After:    []string{},
+		Description: layout.Description,
+		DataPath:    layout.DataPath,
+		Conflicts:   []string{"etcd.service"},
+		Requires:    []string{},
+		After:       []string{},
+	}
+	for _, l := range []etcdLayout{layoutV2, layoutV3} {
+		if l != layout {
+			opts.Conflicts = append(opts.Conflicts, l.ServiceName)
+		}
 	}
-	if flags.Etcd.UseVaultCA {
+	if useCertificates(flags) {
 		opts.After = append(opts.After, certsServiceName)
 	}
-	changed, err := templates.Render(deps.Logger, serviceTemplate, servicePath, opts, serviceFileMode)
+	changed, err := templates.Render(deps.Target, serviceTemplate, layout.ServicePath(), opts, serviceFileMode)
 	return changed, maskAny(err)
 }
 
-func createEtcd2Conf(deps service.ServiceDependencies, cfg etcdConfig) (bool, error) {
+func createEtcdConf(deps service.ServiceDependencies, cfg etcdConfig, layout etcdLayout) (bool, error) {
 	if cfg.ClusterIP == "" {
 		return false, maskAny(fmt.Errorf("ClusterIP empty"))
 	}
-	deps.Logger.Info("creating %s", confPath)
+	deps.Logger.Info("creating %s", layout.ConfPath())
 
 	lines := []string{
 		"[Service]",
@@ -393,7 +586,7 @@ func createEtcd2Conf(deps service.ServiceDependencies, cfg etcdConfig) (bool, er
 		"Environment=ETCD_INITIAL_ADVERTISE_PEER_URLS=" + cfg.AdvertisePeerURLs,
 		"Environment=ETCD_ADVERTISE_CLIENT_URLS=" + cfg.AdvertiseClientURLs,
 	}
-	if cfg.UseVaultCA {
+	if cfg.UseCertificates {
 		lines = append(lines,
 			"Environment=ETCD_PEER_CERT_FILE="+CertsCertPath,
 			"Environment=ETCD_PEER_KEY_FILE="+CertsKeyPath,
@@ -414,11 +607,14 @@ func createEtcd2Conf(deps service.ServiceDependencies, cfg etcdConfig) (bool, er
 	if cfg.Name != "" {
 		lines = append(lines, "Environment=ETCD_NAME="+cfg.Name)
 	}
-	if cfg.IsProxy {
+	if cfg.QuotaBackendBytes > 0 && cfg.Version == 3 {
+		lines = append(lines, fmt.Sprintf("Environment=ETCD_QUOTA_BACKEND_BYTES=%d", cfg.QuotaBackendBytes))
+	}
+	if cfg.IsProxy && cfg.Version != 3 {
 		lines = append(lines, "Environment=ETCD_PROXY=on")
 	}
 
-	changed, err := util.UpdateFile(deps.Logger, confPath, []byte(strings.Join(lines, "\n")), configFileMode)
+	changed, err := deps.Target.UpdateFile(layout.ConfPath(), []byte(strings.Join(lines, "\n")), configFileMode)
 	return changed, maskAny(err)
 }
 
@@ -453,6 +649,6 @@ func createEtcdEnvironment(deps service.ServiceDependencies, cfg etcdConfig) (bo
 		)
 	}
 
-	changed, err := util.AppendEnvironmentFile(environmentPath, kv, configFileMode)
+	changed, err := deps.Target.AppendEnvironmentFile(environmentPath, kv, configFileMode)
 	return changed, maskAny(err)
 }
@@ -0,0 +1,61 @@
+// Copyright (c) 2017 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcd
+
+import (
+	"fmt"
+
+	"github.com/pulcy/gluon/service"
+	"github.com/pulcy/gluon/templates"
+)
+
+// setupGateway creates & (re)starts the etcd-gateway service, which forwards local
+// clients on the client port to the ETCD peers.
+// It replaces the ETCD v2 proxy on machines that are not an ETCD peer.
+func setupGateway(deps service.ServiceDependencies, cfg etcdConfig, force bool) error {
+	deps.Logger.Info("creating %s", gatewayServicePath)
+	opts := struct {
+		Endpoints  string
+		ListenAddr string
+	}{
+		Endpoints:  cfg.GatewayEndpoints,
+		ListenAddr: fmt.Sprintf("127.0.0.1:%s", cfg.Port),
+	}
+	changed, err := templates.Render(deps.Target, gatewayTemplate, gatewayServicePath, opts, serviceFileMode)
+	if err != nil {
+		return maskAny(err)
+	}
+	isActive, err := deps.Systemd.IsActive(gatewayServiceName)
+	if err != nil {
+		return maskAny(err)
+	}
+	if !isActive || changed || force {
+		if err := deps.Systemd.Reload(); err != nil {
+			return maskAny(err)
+		}
+		if err := deps.Systemd.Enable(gatewayServiceName); err != nil {
+			return maskAny(err)
+		}
+		if err := deps.Systemd.Restart(gatewayServiceName); err != nil {
+			return maskAny(err)
+		}
+	}
+	return nil
+}
+
+// removeGateway stops & removes the etcd-gateway service.
+func removeGateway(deps service.ServiceDependencies) error {
+	return maskAny(deps.Systemd.StopAndRemove(gatewayServiceName, gatewayServicePath))
+}
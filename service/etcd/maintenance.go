@@ -0,0 +1,260 @@
+// Copyright (c) 2017 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/op/go-logging"
+
+	"github.com/pulcy/gluon/etcdclient"
+	"github.com/pulcy/gluon/service"
+	"github.com/pulcy/gluon/templates"
+)
+
+const (
+	maintenanceServiceName     = "etcd-maintenance.service"
+	maintenanceServiceTemplate = "templates/etcd/" + maintenanceServiceName + ".tmpl"
+	maintenanceServicePath     = "/etc/systemd/system/" + maintenanceServiceName
+	maintenanceTimerName       = "etcd-maintenance.timer"
+	maintenanceTimerTemplate   = "templates/etcd/" + maintenanceTimerName + ".tmpl"
+	maintenanceTimerPath       = "/etc/systemd/system/" + maintenanceTimerName
+
+	defragHealthTimeout = time.Minute * 2
+	// defaultQuotaBackendBytes is the quota ETCD uses when none is configured.
+	defaultQuotaBackendBytes = 2 * 1024 * 1024 * 1024
+)
+
+// setupMaintenance creates (or removes) the etcd-maintenance service & timer.
+// Maintenance uses the v3 API, so it is never installed for ETCD v2.
+func setupMaintenance(deps service.ServiceDependencies, flags *service.ServiceFlags) error {
+	if !flags.Etcd.Maintenance.Enabled || flags.Etcd.Version != 3 {
+		return maskAny(removeMaintenanceService(deps))
+	}
+	deps.Logger.Info("creating %s", maintenanceServicePath)
+	serviceOpts := struct {
+		After string
+	}{
+		After: layoutFor(flags.Etcd.Version).ServiceName,
+	}
+	changedService, err := templates.Render(deps.Target, maintenanceServiceTemplate, maintenanceServicePath, serviceOpts, serviceFileMode)
+	if err != nil {
+		return maskAny(err)
+	}
+	deps.Logger.Info("creating %s", maintenanceTimerPath)
+	timerOpts := struct {
+		Interval string
+	}{
+		Interval: flags.Etcd.Maintenance.Interval,
+	}
+	changedTimer, err := templates.Render(deps.Target, maintenanceTimerTemplate, maintenanceTimerPath, timerOpts, serviceFileMode)
+	if err != nil {
+		return maskAny(err)
+	}
+	isActive, err := deps.Systemd.IsActive(maintenanceTimerName)
+	if err != nil {
+		return maskAny(err)
+	}
+	if !isActive || changedService || changedTimer || flags.Force {
+		if err := deps.Systemd.Reload(); err != nil {
+			return maskAny(err)
+		}
+		if err := deps.Systemd.Enable(maintenanceTimerName); err != nil {
+			return maskAny(err)
+		}
+		if err := deps.Systemd.Restart(maintenanceTimerName); err != nil {
+			return maskAny(err)
+		}
+	}
+	return nil
+}
+
+// removeMaintenanceService stops & removes the etcd-maintenance timer and service.
+func removeMaintenanceService(deps service.ServiceDependencies) error {
+	if err := deps.Systemd.StopAndRemove(maintenanceTimerName, maintenanceTimerPath); err != nil {
+		return maskAny(err)
+	}
+	return maskAny(deps.Systemd.StopAndRemove(maintenanceServiceName, maintenanceServicePath))
+}
+
+// PeerStatus is the status of a single ETCD peer.
+type PeerStatus struct {
+	ClusterIP string
+	Endpoint  string
+	Status    *etcdclient.Status // nil if the peer cannot be reached
+	Error     error
+}
+
+// Status returns the status (including the size of the backend database) of all ETCD peers
+// and the alarms raised in the cluster.
+func Status(flags *service.ServiceFlags, log *logging.Logger) ([]PeerStatus, []etcdclient.Alarm, error) {
+	client, peers, err := newMaintenanceClient(flags, log)
+	if err != nil {
+		return nil, nil, maskAny(err)
+	}
+	statuses := peerStatuses(client, flags, peers)
+	alarms, err := client.Alarms()
+	if err != nil {
+		return statuses, nil, maskAny(err)
+	}
+	return statuses, alarms, nil
+}
+
+// Maintain compacts the key history of the cluster, defragments the backend database of all
+// peers (one at a time) and disarms NOSPACE alarms once space has been reclaimed.
+// It only acts when this machine runs the leader, so a single machine maintains the cluster
+// even though all peers run the maintenance timer. Nothing is done when any peer cannot be reached.
+// The health endpoint is not used, since a cluster with an alarm may report itself unhealthy.
+func Maintain(flags *service.ServiceFlags, log *logging.Logger) error {
+	client, peers, err := newMaintenanceClient(flags, log)
+	if err != nil {
+		return maskAny(err)
+	}
+	statuses := peerStatuses(client, flags, peers)
+	return maskAny(maintain(client, statuses, flags.Network.ClusterIP, flags.Etcd.Maintenance, log))
+}
+
+// maintain performs the maintenance of Maintain with the given statuses of all peers,
+// when the peer with given cluster IP is the leader.
+func maintain(client *etcdclient.Client, statuses []PeerStatus, clusterIP string, cfg service.EtcdMaintenanceConfig, log *logging.Logger) error {
+	var leader *PeerStatus
+	var followers []PeerStatus
+	for _, ps := range statuses {
+		if ps.Status == nil {
+			return maskAny(fmt.Errorf("etcd peer %s is not healthy, skipping maintenance: %v", ps.ClusterIP, ps.Error))
+		}
+		if ps.Status.IsLeader() {
+			ps := ps
+			leader = &ps
+		} else {
+			followers = append(followers, ps)
+		}
+	}
+	if leader == nil || leader.ClusterIP != clusterIP {
+		log.Infof("This machine does not run the etcd leader, leaving maintenance to the leader")
+		return nil
+	}
+
+	// Compact old revisions, so defragmentation can release their space
+	if revision := leader.Status.Revision - cfg.KeepRevisions; revision > 0 {
+		log.Infof("Compacting etcd key history up to revision %d", revision)
+		if err := client.Compact(revision); err != nil {
+			if !strings.Contains(err.Error(), "compacted") {
+				return maskAny(err)
+			}
+			log.Infof("Revision %d has already been compacted", revision)
+		}
+	}
+
+	// Defragment one peer at a time, the leader last
+	dbSizes := make(map[uint64]int64)
+	for _, ps := range append(followers, *leader) {
+		log.Infof("Defragmenting etcd on %s (%s)", ps.ClusterIP, formatSize(ps.Status.DBSize))
+		if err := client.Defragment(ps.Endpoint); err != nil {
+			return maskAny(err)
+		}
+		s, err := waitMemberReady(client, ps.Endpoint, defragHealthTimeout)
+		if err != nil {
+			return maskAny(err)
+		}
+		log.Infof("etcd database on %s is now %s", ps.ClusterIP, formatSize(s.DBSize))
+		dbSizes[ps.Status.MemberID] = s.DBSize
+	}
+
+	// Accept writes again on members that are below their quota now
+	quota := cfg.QuotaBackendBytes
+	if quota == 0 {
+		quota = defaultQuotaBackendBytes
+	}
+	alarms, err := client.Alarms()
+	if err != nil {
+		return maskAny(err)
+	}
+	var full []string
+	for _, a := range alarms {
+		if a.Alarm != etcdclient.AlarmNoSpace {
+			continue
+		}
+		if size, found := dbSizes[a.MemberID]; !found || size >= quota {
+			// Disarming would only raise the alarm again
+			full = append(full, fmt.Sprintf("%x", a.MemberID))
+			continue
+		}
+		log.Infof("Disarming %s alarm of etcd member %x", a.Alarm, a.MemberID)
+		if err := client.DisarmAlarm(a); err != nil {
+			return maskAny(err)
+		}
+	}
+	if len(full) > 0 {
+		return maskAny(fmt.Errorf("etcd members %s still exceed the quota of %s, keeping their %s alarm", strings.Join(full, ","), formatSize(quota), etcdclient.AlarmNoSpace))
+	}
+	return nil
+}
+
+// newMaintenanceClient creates a client for all ETCD peers, using their client endpoints.
+func newMaintenanceClient(flags *service.ServiceFlags, log *logging.Logger) (*etcdclient.Client, []service.ClusterMember, error) {
+	members, err := flags.GetClusterMembers(log)
+	if err != nil {
+		return nil, nil, maskAny(err)
+	}
+	var peers []service.ClusterMember
+	for _, m := range members {
+		if m.HasRole(service.RoleEtcd) {
+			peers = append(peers, m)
+		}
+	}
+	client, err := NewClient(flags, log)
+	if err != nil {
+		return nil, nil, maskAny(err)
+	}
+	return client, peers, nil
+}
+
+// peerStatuses fetches the status of all given peers.
+func peerStatuses(client *etcdclient.Client, flags *service.ServiceFlags, peers []service.ClusterMember) []PeerStatus {
+	var result []PeerStatus
+	for _, m := range peers {
+		ps := PeerStatus{
+			ClusterIP: m.ClusterIP,
+			Endpoint:  flags.Etcd.CreateEndpoint(m.ClusterIP),
+		}
+		ps.Status, ps.Error = client.MemberStatus(ps.Endpoint)
+		result = append(result, ps)
+	}
+	return result
+}
+
+// waitMemberReady waits until the member at the given endpoint answers and has a leader.
+func waitMemberReady(client *etcdclient.Client, endpoint string, timeout time.Duration) (*etcdclient.Status, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		s, err := client.MemberStatus(endpoint)
+		if err == nil && s.Leader != 0 {
+			return s, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, maskAny(fmt.Errorf("etcd member at %s not ready after %s", endpoint, timeout))
+		}
+		time.Sleep(time.Second * 2)
+	}
+}
+
+// formatSize returns a human readable version of the given number of bytes.
+func formatSize(bytes int64) string {
+	const mb = 1024 * 1024
+	return fmt.Sprintf("%.1fMB", float64(bytes)/mb)
+}
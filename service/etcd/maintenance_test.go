@@ -0,0 +1,146 @@
+package etcd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/op/go-logging"
+
+	"github.com/pulcy/gluon/etcdclient"
+	"github.com/pulcy/gluon/service"
+)
+
+// fakeMaintenanceCluster serves the maintenance API of a number of ETCD peers
+// and records the maintenance requests they receive.
+type fakeMaintenanceCluster struct {
+	mutex    sync.Mutex
+	requests []string
+	alarms   []uint64 // IDs of members with a NOSPACE alarm
+	servers  []*httptest.Server
+}
+
+type fakePeer struct {
+	ID              uint64
+	Leader          uint64
+	DBSize          int64
+	SizeAfterDefrag int64
+}
+
+func newFakeMaintenanceCluster(peers []*fakePeer, alarms []uint64) *fakeMaintenanceCluster {
+	c := &fakeMaintenanceCluster{alarms: alarms}
+	for _, p := range peers {
+		p := p
+		c.servers = append(c.servers, httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			c.mutex.Lock()
+			defer c.mutex.Unlock()
+			switch r.URL.Path {
+			case "/v3alpha/maintenance/status":
+				fmt.Fprintf(w, `{"header":{"member_id":"%d","revision":"50000"},"dbSize":"%d","leader":"%d"}`, p.ID, p.DBSize, p.Leader)
+			case "/v3alpha/maintenance/defragment":
+				c.requests = append(c.requests, fmt.Sprintf("defragment %d", p.ID))
+				p.DBSize = p.SizeAfterDefrag
+				fmt.Fprint(w, `{}`)
+			case "/v3alpha/kv/compaction":
+				var req struct{ Revision string }
+				json.NewDecoder(r.Body).Decode(&req)
+				c.requests = append(c.requests, "compact "+req.Revision)
+				fmt.Fprint(w, `{}`)
+			case "/v3alpha/maintenance/alarm":
+				var req struct {
+					Action   int
+					MemberID string
+				}
+				json.NewDecoder(r.Body).Decode(&req)
+				if req.Action != 0 {
+					c.requests = append(c.requests, "disarm "+req.MemberID)
+					fmt.Fprint(w, `{}`)
+					return
+				}
+				var alarms []string
+				for _, id := range c.alarms {
+					alarms = append(alarms, fmt.Sprintf(`{"memberID":"%d","alarm":"NOSPACE"}`, id))
+				}
+				fmt.Fprintf(w, `{"alarms":[%s]}`, strings.Join(alarms, ","))
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		})))
+	}
+	return c
+}
+
+func (c *fakeMaintenanceCluster) Close() {
+	for _, s := range c.servers {
+		s.Close()
+	}
+}
+
+// statuses returns the client & the status of all peers, as used by Maintain.
+func (c *fakeMaintenanceCluster) statuses() (*etcdclient.Client, []PeerStatus) {
+	var endpoints []string
+	for _, s := range c.servers {
+		endpoints = append(endpoints, s.URL)
+	}
+	client := etcdclient.NewClient(endpoints, nil)
+	var result []PeerStatus
+	for i, ep := range endpoints {
+		ps := PeerStatus{ClusterIP: fmt.Sprintf("10.0.0.%d", i+1), Endpoint: ep}
+		ps.Status, ps.Error = client.MemberStatus(ep)
+		result = append(result, ps)
+	}
+	return client, result
+}
+
+func TestMaintain(t *testing.T) {
+	log := logging.MustGetLogger("test")
+	cfg := service.EtcdMaintenanceConfig{KeepRevisions: 10000, QuotaBackendBytes: 1000}
+	newPeers := func() []*fakePeer {
+		return []*fakePeer{
+			{ID: 1, Leader: 2, DBSize: 1500, SizeAfterDefrag: 500},
+			{ID: 2, Leader: 2, DBSize: 1500, SizeAfterDefrag: 600},
+			{ID: 3, Leader: 2, DBSize: 1500, SizeAfterDefrag: 2000},
+		}
+	}
+
+	// The leader compacts, defragments the followers before itself and only disarms
+	// the alarms of members that are below the quota now
+	cluster := newFakeMaintenanceCluster(newPeers(), []uint64{1, 3})
+	client, statuses := cluster.statuses()
+	if err := maintain(client, statuses, "10.0.0.2", cfg, log); err == nil {
+		t.Errorf("Expected an error for a member that still exceeds its quota")
+	}
+	expected := []string{"compact 40000", "defragment 1", "defragment 3", "defragment 2", "disarm 1"}
+	if !reflect.DeepEqual(cluster.requests, expected) {
+		t.Errorf("Expected requests %v, got %v", expected, cluster.requests)
+	}
+	cluster.Close()
+
+	// Followers leave the maintenance to the leader
+	cluster = newFakeMaintenanceCluster(newPeers(), []uint64{1})
+	client, statuses = cluster.statuses()
+	if err := maintain(client, statuses, "10.0.0.1", cfg, log); err != nil {
+		t.Errorf("Expected no error on a follower, got %v", err)
+	}
+	if len(cluster.requests) != 0 {
+		t.Errorf("Expected no requests from a follower, got %v", cluster.requests)
+	}
+	cluster.Close()
+
+	// Nothing is done when a peer cannot be reached
+	cluster = newFakeMaintenanceCluster(newPeers(), []uint64{1})
+	cluster.servers[2].Close()
+	client, statuses = cluster.statuses()
+	if err := maintain(client, statuses, "10.0.0.2", cfg, log); err == nil {
+		t.Errorf("Expected an error when a peer cannot be reached")
+	}
+	if len(cluster.requests) != 0 {
+		t.Errorf("Expected no requests when a peer cannot be reached, got %v", cluster.requests)
+	}
+	cluster.Close()
+}
@@ -0,0 +1,148 @@
+// Copyright (c) 2017 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pulcy/gluon/etcdclient"
+	"github.com/pulcy/gluon/service"
+)
+
+const (
+	clusterStateExisting = "existing"
+)
+
+// peerURLs returns the peer URLs of the given member.
+func peerURLs(m service.ClusterMember) []string {
+	return []string{
+		fmt.Sprintf("https://%s:2380", m.ClusterIP),
+		fmt.Sprintf("https://%s:2381", m.PrivateHostIP),
+	}
+}
+
+// reconcileMembership compares the configured ETCD peers with the members of the running cluster.
+// If this machine is a configured peer but not a member of the cluster, it is added and joins
+// with cluster state `existing`. Members that are no longer configured peers are removed.
+// At most one member is added or removed per run, and only when the cluster is healthy and has
+// no other member that has been added but not started yet.
+// Nothing is changed when the cluster cannot be reached (e.g. when it is being bootstrapped).
+func reconcileMembership(deps service.ServiceDependencies, flags *service.ServiceFlags, cfg *etcdConfig) error {
+	if deps.Target.DryRun() || deps.Target.IsOffline() {
+		return nil
+	}
+	members, err := flags.GetClusterMembers(deps.Logger)
+	if err != nil {
+		return maskAny(err)
+	}
+	var self service.ClusterMember
+	var endpoints []string
+	configured := make(map[string]bool)
+	for _, m := range members {
+		if !m.HasRole(service.RoleEtcd) {
+			continue
+		}
+		configured[peerURLs(m)[0]] = true
+		if m.ClusterIP == cfg.ClusterIP {
+			self = m
+		} else {
+			// Ask the other peers, the local peer may not be running (yet)
+			endpoints = append(endpoints, flags.Etcd.CreateEndpoint(m.ClusterIP))
+		}
+	}
+	if self.ClusterIP == "" || len(endpoints) == 0 {
+		return nil
+	}
+	client, err := newClient(flags, endpoints)
+	if err != nil {
+		return maskAny(err)
+	}
+	live, err := client.Members()
+	if err != nil {
+		deps.Logger.Info("cannot reach etcd cluster, assuming it is being bootstrapped: %v", err)
+		return nil
+	}
+	if err := client.Healthy(); err != nil {
+		deps.Logger.Warning("etcd cluster is not healthy, leaving its members as they are: %v", err)
+		return nil
+	}
+
+	selfURLs := peerURLs(self)
+	var liveSelf *etcdclient.Member
+	var pending, stale []etcdclient.Member
+	for _, m := range live {
+		m := m
+		if m.HasPeerURL(selfURLs[0]) {
+			liveSelf = &m
+			continue
+		}
+		if !m.Started() {
+			pending = append(pending, m)
+		}
+		isConfigured := false
+		for _, u := range m.PeerURLs {
+			isConfigured = isConfigured || configured[u]
+		}
+		if !isConfigured {
+			stale = append(stale, m)
+		}
+	}
+	if len(pending) > 0 {
+		deps.Logger.Warning("etcd member %s has been added but not started, leaving members as they are until it has joined", strings.Join(pending[0].PeerURLs, ","))
+		return nil
+	}
+
+	if len(stale) > 0 {
+		// Remove one stale member, the others are removed by the next setup
+		m := stale[0]
+		deps.Logger.Info("removing etcd member %s (%s), it is no longer a configured peer", m.Name, strings.Join(m.PeerURLs, ","))
+		if err := client.RemoveMember(m.ID); err != nil {
+			return maskAny(err)
+		}
+		return nil
+	}
+
+	if liveSelf == nil {
+		// Join the existing cluster
+		deps.Logger.Info("adding this machine to the etcd cluster")
+		added, err := client.AddMember(selfURLs)
+		if err != nil {
+			return maskAny(err)
+		}
+		liveSelf = added
+		live = append(live, *added)
+	}
+
+	// This machine is a member of an existing cluster, it must never bootstrap a new one
+	if !liveSelf.Started() {
+		var initialCluster []string
+		for _, m := range live {
+			name := m.Name
+			if m.ID == liveSelf.ID {
+				name = cfg.Name
+			}
+			for _, u := range m.PeerURLs {
+				initialCluster = append(initialCluster, fmt.Sprintf("%s=%s", name, u))
+			}
+		}
+		cfg.InitialCluster = strings.Join(initialCluster, ",")
+	}
+	if cfg.ClusterState != clusterStateExisting {
+		deps.Logger.Info("switching etcd cluster state to %s", clusterStateExisting)
+		cfg.ClusterState = clusterStateExisting
+	}
+	return nil
+}
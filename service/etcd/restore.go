@@ -0,0 +1,155 @@
+// Copyright (c) 2017 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcd
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pulcy/gluon/etcdclient"
+	"github.com/pulcy/gluon/service"
+)
+
+const (
+	restoreConfName      = "98-restore.conf"
+	localEndpoint        = "http://127.0.0.1:4001"
+	restoreHealthTimeout = time.Minute * 2
+)
+
+// Restore replaces the ETCD data of this machine with the content of the given snapshot and
+// starts it as a new single member cluster with a new cluster token.
+// The current data directory is moved aside, not removed.
+// Other peers must remove their data directory and run setup to join the new cluster.
+func Restore(deps service.ServiceDependencies, flags *service.ServiceFlags, snapshotPath string) error {
+	self, err := flags.ThisMember(deps.Logger)
+	if err != nil {
+		return maskAny(err)
+	}
+	if !self.HasRole(service.RoleEtcd) {
+		return maskAny(fmt.Errorf("This machine is not an etcd peer"))
+	}
+	if _, err := os.Stat(snapshotPath); err != nil {
+		return maskAny(err)
+	}
+	layout := layoutFor(flags.Etcd.Version)
+	serviceName := layout.ServiceName
+	dataPath := layout.DataPath
+	restoreConfPath := layout.ConfDir() + restoreConfName
+
+	deps.Logger.Info("stopping %s", serviceName)
+	if err := deps.Systemd.Stop(serviceName); err != nil {
+		return maskAny(err)
+	}
+	asidePath := fmt.Sprintf("%s.before-restore-%s", dataPath, time.Now().UTC().Format(snapshotTimeFormat))
+	if _, err := os.Stat(dataPath); err == nil {
+		deps.Logger.Info("moving %s to %s", dataPath, asidePath)
+		if err := os.Rename(dataPath, asidePath); err != nil {
+			return maskAny(err)
+		}
+	}
+	deps.Logger.Info("extracting %s into %s", snapshotPath, dataPath)
+	if err := os.MkdirAll(dataPath, dataPathMode); err != nil {
+		return maskAny(err)
+	}
+	if err := extractArchive(snapshotPath, dataPath); err != nil {
+		return maskAny(err)
+	}
+	if out, err := deps.Target.Run("chown", "-R", etcdUser+":"+etcdUser, dataPath); err != nil {
+		return maskAny(fmt.Errorf("chown failed: %v %s", err, strings.TrimSpace(string(out))))
+	}
+
+	// Start once as a new cluster
+	token, err := newClusterToken()
+	if err != nil {
+		return maskAny(err)
+	}
+	lines := []string{
+		"[Service]",
+		"Environment=ETCD_FORCE_NEW_CLUSTER=true",
+		"Environment=ETCD_INITIAL_CLUSTER_TOKEN=" + token,
+	}
+	if _, err := deps.Target.UpdateFile(restoreConfPath, []byte(strings.Join(lines, "\n")), configFileMode); err != nil {
+		return maskAny(err)
+	}
+	if err := deps.Systemd.Reload(); err != nil {
+		return maskAny(err)
+	}
+	deps.Logger.Info("starting %s as a new cluster", serviceName)
+	if err := deps.Systemd.Start(serviceName); err != nil {
+		return maskAny(err)
+	}
+	client := etcdclient.NewClient([]string{localEndpoint}, nil)
+	if err := waitHealthy(client, restoreHealthTimeout); err != nil {
+		return maskAny(err)
+	}
+
+	// The restored member still advertises the peer URLs of the machine the snapshot was taken on
+	members, err := client.Members()
+	if err != nil {
+		return maskAny(err)
+	}
+	if len(members) != 1 {
+		return maskAny(fmt.Errorf("Expected 1 member after restore, got %d", len(members)))
+	}
+	if err := client.UpdateMember(members[0].ID, peerURLs(self)); err != nil {
+		return maskAny(err)
+	}
+
+	// Forcing a new cluster must happen only once
+	if err := deps.Target.Remove(restoreConfPath); err != nil {
+		return maskAny(err)
+	}
+	if err := deps.Systemd.Reload(); err != nil {
+		return maskAny(err)
+	}
+	if _, err := service.UpdateConfig(deps.Target, func(cfg *service.Config) error {
+		cfg.Etcd.ClusterState = clusterStateExisting
+		return nil
+	}); err != nil {
+		return maskAny(err)
+	}
+
+	deps.Logger.Info("restored %s, previous data is in %s", snapshotPath, asidePath)
+	deps.Logger.Info("on all other etcd peers: stop %s, remove %s and run gluon setup to join the new cluster", serviceName, dataPath)
+	return nil
+}
+
+// waitHealthy waits until the given client reports a healthy cluster.
+func waitHealthy(client *etcdclient.Client, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		err := client.Healthy()
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return maskAny(fmt.Errorf("ETCD not healthy after %s: %v", timeout, err))
+		}
+		time.Sleep(time.Second * 2)
+	}
+}
+
+// newClusterToken creates a random ETCD cluster token.
+func newClusterToken() (string, error) {
+	raw := make([]byte, 8)
+	if _, err := rand.Read(raw); err != nil {
+		return "", maskAny(err)
+	}
+	return "etcd-cluster-" + hex.EncodeToString(raw), nil
+}
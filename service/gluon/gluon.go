@@ -46,15 +46,20 @@ func (t *gluonService) Name() string {
 	return "gluon"
 }
 
+// The gluon service is only installed after all other services are setup successfully.
+func (t *gluonService) Dependencies() []string {
+	return []string{service.AllServices}
+}
+
 func (t *gluonService) Setup(deps service.ServiceDependencies, flags *service.ServiceFlags) error {
-	if err := flags.SetupDefaults(deps.Logger); err != nil {
+	if err := flags.SetupDefaults(deps.Target); err != nil {
 		return maskAny(err)
 	}
 	if flags.Docker.DockerSubnet == "" {
 		return errgo.New("docker-subnet is missing")
 	}
 
-	changedFlags, err := flags.Save(deps.Logger)
+	changedFlags, err := flags.Save(deps.Target)
 	if err != nil {
 		return maskAny(err)
 	}
@@ -65,7 +70,7 @@ func (t *gluonService) Setup(deps service.ServiceDependencies, flags *service.Se
 	}
 
 	if flags.Force || changedFlags || changedService {
-		if err := os.Remove(gluonPath); err != nil {
+		if err := deps.Target.Remove(gluonPath); err != nil {
 			if !os.IsNotExist(err) {
 				return maskAny(err)
 			}
@@ -82,6 +87,15 @@ func (t *gluonService) Setup(deps service.ServiceDependencies, flags *service.Se
 	return nil
 }
 
+// Teardown removes the gluon service, so setup is no longer run at boot.
+// The configuration in /etc/pulcy and the gluon binary itself are left in place.
+func (t *gluonService) Teardown(deps service.ServiceDependencies, flags *service.ServiceFlags) error {
+	if err := deps.Systemd.StopAndRemove(serviceName, servicePath); err != nil {
+		return maskAny(err)
+	}
+	return maskAny(deps.Systemd.Reload())
+}
+
 func createService(deps service.ServiceDependencies, flags *service.ServiceFlags) (bool, error) {
 	deps.Logger.Info("creating %s", servicePath)
 	opts := struct {
@@ -97,6 +111,6 @@ func createService(deps service.ServiceDependencies, flags *service.ServiceFlags
 		RktSubnet:            flags.Rkt.RktSubnet,
 		WeaveHostname:        flags.Weave.Hostname,
 	}
-	changed, err := templates.Render(deps.Logger, serviceTemplate, servicePath, opts, fileMode)
+	changed, err := templates.Render(deps.Target, serviceTemplate, servicePath, opts, fileMode)
 	return changed, maskAny(err)
 }
@@ -0,0 +1,190 @@
+// Copyright (c) 2017 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// AllServices can be returned as dependency by a service that must be setup after all other services.
+	AllServices = "*"
+)
+
+// Order returns the given services in an order in which every service comes after all of its dependencies.
+// Services that do not depend on each other keep the relative order in which they are given.
+// An error is returned when a dependency is unknown, or when dependencies contain a cycle.
+func Order(services []Service) ([]Service, error) {
+	deps, err := dependencyMap(services)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	var result []Service
+	done := make(map[string]bool)
+	for len(result) < len(services) {
+		progress := false
+		for _, s := range services {
+			if done[s.Name()] || !isReady(deps[s.Name()], done) {
+				continue
+			}
+			result = append(result, s)
+			done[s.Name()] = true
+			progress = true
+			break
+		}
+		if !progress {
+			return nil, maskAny(fmt.Errorf("Dependency cycle: %s", strings.Join(findCycle(services, deps, done), " -> ")))
+		}
+	}
+	return result, nil
+}
+
+// Run calls fn for all given services, such that fn is called for a service
+// only after it has returned for all of its dependencies.
+// Up to concurrency calls are made at the same time. Services that are ready
+// at the same time are started in the order in which they are given.
+// Once fn fails, no more calls are started and the first error is returned.
+func Run(services []Service, concurrency int, fn func(Service) error) error {
+	deps, err := dependencyMap(services)
+	if err != nil {
+		return maskAny(err)
+	}
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	type result struct {
+		service Service
+		err     error
+	}
+	results := make(chan result)
+	pending := append([]Service(nil), services...)
+	done := make(map[string]bool)
+	running := 0
+	var firstErr error
+	for {
+		if firstErr == nil {
+			for i := 0; i < len(pending) && running < concurrency; {
+				s := pending[i]
+				if !isReady(deps[s.Name()], done) {
+					i++
+					continue
+				}
+				pending = append(pending[:i], pending[i+1:]...)
+				running++
+				go func(s Service) {
+					results <- result{s, fn(s)}
+				}(s)
+			}
+		}
+		if running == 0 {
+			break
+		}
+		r := <-results
+		running--
+		done[r.service.Name()] = true
+		if r.err != nil && firstErr == nil {
+			firstErr = r.err
+		}
+	}
+	if firstErr != nil {
+		return maskAny(firstErr)
+	}
+	if len(pending) > 0 {
+		return maskAny(fmt.Errorf("Dependency cycle: %s", strings.Join(findCycle(services, deps, done), " -> ")))
+	}
+	return nil
+}
+
+// dependencyMap returns the names of the dependencies of all given services, by service name.
+// AllServices is expanded to all services that do not depend on AllServices themselves.
+// All dependencies must be found in the given services.
+func dependencyMap(services []Service) (map[string][]string, error) {
+	names := make(map[string]bool)
+	for _, s := range services {
+		names[s.Name()] = true
+	}
+	isLast := func(s Service) bool {
+		for _, d := range s.Dependencies() {
+			if d == AllServices {
+				return true
+			}
+		}
+		return false
+	}
+	result := make(map[string][]string)
+	for _, s := range services {
+		var deps []string
+		for _, d := range s.Dependencies() {
+			switch {
+			case d == AllServices:
+				for _, x := range services {
+					if x.Name() != s.Name() && !isLast(x) {
+						deps = append(deps, x.Name())
+					}
+				}
+			case names[d]:
+				deps = append(deps, d)
+			default:
+				return nil, maskAny(fmt.Errorf("Service %s depends on unknown service %s", s.Name(), d))
+			}
+		}
+		result[s.Name()] = deps
+	}
+	return result, nil
+}
+
+// isReady returns true if all given dependencies are done.
+func isReady(deps []string, done map[string]bool) bool {
+	for _, d := range deps {
+		if !done[d] {
+			return false
+		}
+	}
+	return true
+}
+
+// findCycle returns the names of services that form a dependency cycle among the services that are not done.
+func findCycle(services []Service, deps map[string][]string, done map[string]bool) []string {
+	for _, s := range services {
+		if done[s.Name()] {
+			continue
+		}
+		// Every service that is not done has a dependency that is not done, so following
+		// those dependencies must eventually visit a service for the second time.
+		var path []string
+		visited := make(map[string]int)
+		name := s.Name()
+		for {
+			if index, found := visited[name]; found {
+				return append(path[index:], name)
+			}
+			visited[name] = len(path)
+			path = append(path, name)
+			next := ""
+			for _, d := range deps[name] {
+				if !done[d] {
+					next = d
+					break
+				}
+			}
+			if next == "" {
+				break
+			}
+			name = next
+		}
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package service
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+type testService struct {
+	name string
+	deps []string
+}
+
+func (s testService) Name() string                                                 { return s.name }
+func (s testService) Dependencies() []string                                       { return s.deps }
+func (s testService) Setup(deps ServiceDependencies, flags *ServiceFlags) error    { return nil }
+func (s testService) Teardown(deps ServiceDependencies, flags *ServiceFlags) error { return nil }
+
+func names(services []Service) string {
+	var result []string
+	for _, s := range services {
+		result = append(result, s.Name())
+	}
+	return strings.Join(result, ",")
+}
+
+// TestOrder checks that dependencies come first and that the given order is kept otherwise.
+func TestOrder(t *testing.T) {
+	services := []Service{
+		testService{"last", []string{AllServices}},
+		testService{"c", []string{"b"}},
+		testService{"a", nil},
+		testService{"b", []string{"a"}},
+		testService{"d", nil},
+	}
+	ordered, err := Order(services)
+	if err != nil {
+		t.Fatalf("Expected success, got %#v", err)
+	}
+	expected := "a,b,c,d,last"
+	if x := names(ordered); x != expected {
+		t.Errorf("Expected '%s', got '%s'", expected, x)
+	}
+}
+
+// TestOrderCycle checks that a dependency cycle is detected.
+func TestOrderCycle(t *testing.T) {
+	services := []Service{
+		testService{"a", nil},
+		testService{"b", []string{"c"}},
+		testService{"c", []string{"d"}},
+		testService{"d", []string{"b"}},
+	}
+	_, err := Order(services)
+	if err == nil {
+		t.Fatal("Expected error, got success")
+	}
+	if !strings.Contains(err.Error(), "b -> c -> d -> b") {
+		t.Errorf("Expected cycle in error, got '%s'", err.Error())
+	}
+}
+
+// TestOrderUnknown checks that a dependency on an unknown service is detected.
+func TestOrderUnknown(t *testing.T) {
+	services := []Service{
+		testService{"a", []string{"x"}},
+	}
+	if _, err := Order(services); err == nil {
+		t.Fatal("Expected error, got success")
+	}
+}
+
+// TestRun checks that services only run after their dependencies, with limited concurrency.
+func TestRun(t *testing.T) {
+	services := []Service{
+		testService{"a", nil},
+		testService{"b", nil},
+		testService{"c", []string{"a", "b"}},
+		testService{"d", []string{"a"}},
+		testService{"e", []string{AllServices}},
+	}
+	var mutex sync.Mutex
+	done := make(map[string]bool)
+	running, maxRunning := 0, 0
+	err := Run(services, 2, func(s Service) error {
+		mutex.Lock()
+		for _, d := range s.Dependencies() {
+			if d != AllServices && !done[d] {
+				t.Errorf("%s started before %s is done", s.Name(), d)
+			}
+		}
+		if s.Name() == "e" && len(done) != 4 {
+			t.Errorf("e started before all other services are done")
+		}
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mutex.Unlock()
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		running--
+		done[s.Name()] = true
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Expected success, got %#v", err)
+	}
+	if len(done) != len(services) {
+		t.Errorf("Expected all services to run, got %v", done)
+	}
+	if maxRunning > 2 {
+		t.Errorf("Expected at most 2 concurrent services, got %d", maxRunning)
+	}
+}
@@ -16,7 +16,6 @@ package iptables
 
 import (
 	"os"
-	"os/exec"
 
 	"github.com/juju/errgo"
 
@@ -74,6 +73,10 @@ func (t *iptablesService) Name() string {
 	return "iptables"
 }
 
+func (t *iptablesService) Dependencies() []string {
+	return nil
+}
+
 func (t *iptablesService) Setup(deps service.ServiceDependencies, flags *service.ServiceFlags) error {
 	changedV4Members, err := createV4Members(deps, flags)
 	if err != nil {
@@ -117,8 +120,7 @@ func (t *iptablesService) Setup(deps service.ServiceDependencies, flags *service
 	}
 	if flags.Force || changedV4Members {
 		deps.Logger.Debugf("executing %s", v4membersPath)
-		cmd := exec.Command(v4membersPath)
-		output, err := cmd.CombinedOutput()
+		output, err := deps.Target.Run(v4membersPath)
 		if err != nil {
 			deps.Logger.Errorf("%s failed:\n%s\n%#v\n", v4membersPath, string(output), err)
 			return maskAny(err)
@@ -128,6 +130,28 @@ func (t *iptablesService) Setup(deps service.ServiceDependencies, flags *service
 	return nil
 }
 
+func (t *iptablesService) Teardown(deps service.ServiceDependencies, flags *service.ServiceFlags) error {
+	units := []struct {
+		Name string
+		Path string
+	}{
+		{netfilterServiceName, netfilterServicePath},
+		{v4serviceName, v4servicePath},
+		{v6serviceName, v6servicePath},
+	}
+	for _, u := range units {
+		if err := deps.Systemd.StopAndRemove(u.Name, u.Path); err != nil {
+			return maskAny(err)
+		}
+	}
+	for _, p := range []string{v4membersPath, v4rulesPath, v6rulesPath} {
+		if err := deps.Target.Remove(p); err != nil {
+			return maskAny(err)
+		}
+	}
+	return maskAny(deps.Systemd.Reload())
+}
+
 func createV4Members(deps service.ServiceDependencies, flags *service.ServiceFlags) (bool, error) {
 	deps.Logger.Info("creating %s", v4membersPath)
 	members, err := flags.GetClusterMembers(deps.Logger)
@@ -149,7 +173,7 @@ func createV4Members(deps service.ServiceDependencies, flags *service.ServiceFla
 		opts.ClusterMemberIPs = append(opts.ClusterMemberIPs, cm.ClusterIP)
 		opts.PrivateMemberIPs = append(opts.PrivateMemberIPs, cm.PrivateHostIP)
 	}
-	changed, err := templates.Render(deps.Logger, v4membersTemplate, v4membersPath, opts, scriptFileMode)
+	changed, err := templates.Render(deps.Target, v4membersTemplate, v4membersPath, opts, scriptFileMode)
 	return changed, maskAny(err)
 }
 
@@ -172,7 +196,7 @@ func createV4Rules(deps service.ServiceDependencies, flags *service.ServiceFlags
 		KubernetesAPIServer:     flags.Kubernetes.IsEnabled(),
 		KubernetesAPIServerPort: flags.Kubernetes.APIServerPort,
 	}
-	changed, err := templates.Render(deps.Logger, v4rulesTemplate, v4rulesPath, opts, rulesFileMode)
+	changed, err := templates.Render(deps.Target, v4rulesTemplate, v4rulesPath, opts, rulesFileMode)
 	return changed, maskAny(err)
 }
 
@@ -185,24 +209,24 @@ func createV6Rules(deps service.ServiceDependencies, flags *service.ServiceFlags
 		KubernetesAPIServer:     flags.Kubernetes.IsEnabled(),
 		KubernetesAPIServerPort: flags.Kubernetes.APIServerPort,
 	}
-	changed, err := templates.Render(deps.Logger, v6rulesTemplate, v6rulesPath, opts, rulesFileMode)
+	changed, err := templates.Render(deps.Target, v6rulesTemplate, v6rulesPath, opts, rulesFileMode)
 	return changed, maskAny(err)
 }
 
 func createNetfilterService(deps service.ServiceDependencies, flags *service.ServiceFlags) (bool, error) {
 	deps.Logger.Info("creating %s", netfilterServicePath)
-	changed, err := templates.Render(deps.Logger, netfilterTemplate, netfilterServicePath, nil, serviceFileMode)
+	changed, err := templates.Render(deps.Target, netfilterTemplate, netfilterServicePath, nil, serviceFileMode)
 	return changed, maskAny(err)
 }
 
 func createIp4tablesService(deps service.ServiceDependencies, flags *service.ServiceFlags) (bool, error) {
 	deps.Logger.Info("creating %s", v4servicePath)
-	changed, err := templates.Render(deps.Logger, v4serviceTemplate, v4servicePath, nil, serviceFileMode)
+	changed, err := templates.Render(deps.Target, v4serviceTemplate, v4servicePath, nil, serviceFileMode)
 	return changed, maskAny(err)
 }
 
 func createIp6tablesService(deps service.ServiceDependencies, flags *service.ServiceFlags) (bool, error) {
 	deps.Logger.Info("creating %s", v6servicePath)
-	changed, err := templates.Render(deps.Logger, v6serviceTemplate, v6servicePath, nil, serviceFileMode)
+	changed, err := templates.Render(deps.Target, v6serviceTemplate, v6servicePath, nil, serviceFileMode)
 	return changed, maskAny(err)
 }
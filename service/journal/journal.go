@@ -21,7 +21,6 @@ import (
 	"github.com/juju/errgo"
 
 	"github.com/pulcy/gluon/service"
-	"github.com/pulcy/gluon/util"
 )
 
 var (
@@ -45,6 +44,10 @@ func (t *journalService) Name() string {
 	return "journal"
 }
 
+func (t *journalService) Dependencies() []string {
+	return nil
+}
+
 func (t *journalService) Setup(deps service.ServiceDependencies, flags *service.ServiceFlags) error {
 	changedConf, err := createJournalConf(deps, flags)
 	if err != nil {
@@ -69,6 +72,13 @@ func (t *journalService) Setup(deps service.ServiceDependencies, flags *service.
 	return nil
 }
 
+func (t *journalService) Teardown(deps service.ServiceDependencies, flags *service.ServiceFlags) error {
+	if err := deps.Systemd.StopAndRemove(socketName, socketPath); err != nil {
+		return maskAny(err)
+	}
+	return maskAny(deps.Systemd.Reload())
+}
+
 func createJournalConf(deps service.ServiceDependencies, flags *service.ServiceFlags) (bool, error) {
 	lines := []string{
 		"[Unit]",
@@ -82,6 +92,6 @@ func createJournalConf(deps service.ServiceDependencies, flags *service.ServiceF
 		"WantedBy=sockets.target",
 	}
 
-	changed, err := util.UpdateFile(deps.Logger, socketPath, []byte(strings.Join(lines, "\n")), configFileMode)
+	changed, err := deps.Target.UpdateFile(socketPath, []byte(strings.Join(lines, "\n")), configFileMode)
 	return changed, maskAny(err)
 }
@@ -15,11 +15,8 @@
 package service
 
 import (
-	"io/ioutil"
 	"os"
 	"strings"
-
-	"github.com/op/go-logging"
 )
 
 // K8s config
@@ -48,7 +45,7 @@ const (
 )
 
 // setupDefaults fills given flags with default value
-func (flags *Kubernetes) setupDefaults(log *logging.Logger) error {
+func (flags *Kubernetes) setupDefaults(cfg *Config) error {
 	if flags.KubernetesMasterImage == "" {
 		flags.KubernetesMasterImage = defaultKubernetesMasterImage
 	}
@@ -80,34 +77,16 @@ func (flags *Kubernetes) setupDefaults(log *logging.Logger) error {
 		}
 	}
 	if flags.Metadata == "" {
-		raw, err := ioutil.ReadFile(kubeletMetadataPath)
-		if os.IsNotExist(err) {
-			raw, err = ioutil.ReadFile(obsoleteFleetMetadataPath)
-		}
-		if err != nil && !os.IsNotExist(err) {
-			return maskAny(err)
-		} else if err == nil {
-			lines := trimLines(strings.Split(string(raw), "\n"))
-			flags.Metadata = strings.Join(lines, ",")
-		}
+		flags.Metadata = strings.Join(cfg.Kubernetes.Metadata, ",")
 	}
 	return nil
 }
 
-// save applicable flags to their respective files
-// Returns true if anything has changed, false otherwise
-func (flags *Kubernetes) save(log *logging.Logger) (bool, error) {
-	changes := 0
+// save applicable flags to the given configuration
+func (flags *Kubernetes) save(cfg *Config) {
 	if flags.Metadata != "" {
-		parts := strings.Split(flags.Metadata, ",")
-		content := strings.Join(parts, "\n")
-		if changed, err := updateContent(log, kubeletMetadataPath, content, 0644); err != nil {
-			return false, maskAny(err)
-		} else if changed {
-			changes++
-		}
+		cfg.Kubernetes.Metadata = splitList(flags.Metadata)
 	}
-	return (changes > 0), nil
 }
 
 // IsEnabled returns true if kubernetes should be installed on the cluster.
@@ -15,6 +15,7 @@
 package kubernetes
 
 import (
+	"fmt"
 	"net"
 	"path/filepath"
 
@@ -25,16 +26,54 @@ import (
 const (
 	certsServiceTemplate = "templates/kubernetes/certs.service.tmpl"
 	certsTimerTemplate   = "templates/kubernetes/certs.timer.tmpl"
+	localCertsTemplate   = "templates/ca/certs.service.tmpl"
 )
 
 // createCertsService creates the k8s-certs service.
 func createCertsService(deps service.ServiceDependencies, flags *service.ServiceFlags, c Component, altNames []string, addInternalApiServerIP bool) (bool, error) {
 	deps.Logger.Info("creating %s", c.CertificatesServicePath())
-	clusterID, err := flags.ReadClusterID()
+	privateHostIP, err := flags.PrivateHostIP(deps.Logger)
 	if err != nil {
 		return false, maskAny(err)
 	}
-	privateHostIP, err := flags.PrivateHostIP(deps.Logger)
+	ipSans := []string{flags.Network.ClusterIP, privateHostIP}
+	if addInternalApiServerIP {
+		serviceIP, _, err := net.ParseCIDR(flags.Kubernetes.ServiceClusterIPRange)
+		if err != nil {
+			return false, maskAny(err)
+		}
+		serviceIPv4 := serviceIP.To4()
+		if serviceIPv4 == nil {
+			return false, maskAny(fmt.Errorf("Service cluster IP range '%s' is not an IPv4 range", flags.Kubernetes.ServiceClusterIPRange))
+		}
+		internalApiServerIP := net.IPv4(serviceIPv4[0], serviceIPv4[1], serviceIPv4[2], 1)
+		ipSans = append(ipSans, internalApiServerIP.String())
+	}
+	if flags.CA.IsLocal() {
+		opts := struct {
+			Description string
+			CommonName  string
+			AltNames    []string
+			IPSans      []string
+			CertPath    string
+			KeyPath     string
+			CAPath      string
+			FileMode    uint32
+			Owner       string
+		}{
+			Description: fmt.Sprintf("Kubernetes %s certificates", c.Name()),
+			CommonName:  c.Name(),
+			AltNames:    altNames,
+			IPSans:      ipSans,
+			CertPath:    c.CertificatePath(),
+			KeyPath:     c.KeyPath(),
+			CAPath:      c.CAPath(),
+			FileMode:    0600,
+		}
+		changed, err := templates.Render(deps.Target, localCertsTemplate, c.CertificatesServicePath(), opts, serviceFileMode)
+		return changed, maskAny(err)
+	}
+	clusterID, err := flags.ReadClusterID()
 	if err != nil {
 		return false, maskAny(err)
 	}
@@ -57,22 +96,13 @@ func createCertsService(deps service.ServiceDependencies, flags *service.Service
 		CommonName:         c.Name(),
 		Role:               c.Name(),
 		AltNames:           altNames,
-		IPSans:             []string{flags.Network.ClusterIP, privateHostIP},
+		IPSans:             ipSans,
 		CertFileName:       filepath.Base(c.CertificatePath()),
 		KeyFileName:        filepath.Base(c.KeyPath()),
 		CAFileName:         filepath.Base(c.CAPath()),
 		CertificatesFolder: certificatePath(""),
 	}
-	if addInternalApiServerIP {
-		serviceIP, _, err := net.ParseCIDR(flags.Kubernetes.ServiceClusterIPRange)
-		if err != nil {
-			return false, maskAny(err)
-		}
-		internalApiServerIP := serviceIP.To4()
-		internalApiServerIP[3] = 1
-		opts.IPSans = append(opts.IPSans, internalApiServerIP.String())
-	}
-	changed, err := templates.Render(deps.Logger, certsServiceTemplate, c.CertificatesServicePath(), opts, serviceFileMode)
+	changed, err := templates.Render(deps.Target, certsServiceTemplate, c.CertificatesServicePath(), opts, serviceFileMode)
 	return changed, maskAny(err)
 }
 
@@ -84,6 +114,6 @@ func createCertsTimer(deps service.ServiceDependencies, flags *service.ServiceFl
 	}{
 		Component: c.Name(),
 	}
-	changed, err := templates.Render(deps.Logger, certsTimerTemplate, c.CertificatesTimerPath(), opts, serviceFileMode)
+	changed, err := templates.Render(deps.Target, certsTimerTemplate, c.CertificatesTimerPath(), opts, serviceFileMode)
 	return changed, maskAny(err)
 }
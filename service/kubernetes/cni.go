@@ -15,12 +15,10 @@
 package kubernetes
 
 import (
-	"io/ioutil"
 	"os"
 	"path/filepath"
 
 	"github.com/pulcy/gluon/service"
-	"github.com/pulcy/gluon/util"
 )
 
 const (
@@ -29,11 +27,11 @@ const (
 )
 
 func linkCniBinaries(deps service.ServiceDependencies, flags *service.ServiceFlags) error {
-	entries, err := ioutil.ReadDir(cniPluginsSourcePath)
+	entries, err := deps.Target.ReadDir(cniPluginsSourcePath)
 	if err != nil {
 		return maskAny(err)
 	}
-	if err := util.EnsureDirectory(cniPluginsTargetPath, 0755); err != nil {
+	if err := deps.Target.EnsureDirectory(cniPluginsTargetPath, 0755); err != nil {
 		return maskAny(err)
 	}
 	for _, e := range entries {
@@ -42,9 +40,29 @@ func linkCniBinaries(deps service.ServiceDependencies, flags *service.ServiceFla
 		}
 		sourcePath := filepath.Join(cniPluginsSourcePath, e.Name())
 		destPath := filepath.Join(cniPluginsTargetPath, e.Name())
-		if _, err := os.Stat(destPath); os.IsNotExist(err) {
+		if _, err := deps.Target.Stat(destPath); os.IsNotExist(err) {
 			deps.Logger.Debugf("Linking %s to %s", destPath, sourcePath)
-			if err := os.Symlink(sourcePath, destPath); err != nil {
+			if err := deps.Target.Symlink(sourcePath, destPath); err != nil {
+				return maskAny(err)
+			}
+		}
+	}
+	return nil
+}
+
+// unlinkCniBinaries removes the links created by linkCniBinaries.
+func unlinkCniBinaries(deps service.ServiceDependencies) error {
+	entries, err := deps.Target.ReadDir(cniPluginsSourcePath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return maskAny(err)
+	}
+	for _, e := range entries {
+		sourcePath := filepath.Join(cniPluginsSourcePath, e.Name())
+		destPath := filepath.Join(cniPluginsTargetPath, e.Name())
+		if dest, err := deps.Target.Readlink(destPath); err == nil && dest == sourcePath {
+			if err := deps.Target.Remove(destPath); err != nil {
 				return maskAny(err)
 			}
 		}
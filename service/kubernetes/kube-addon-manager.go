@@ -17,7 +17,6 @@ package kubernetes
 import (
 	"github.com/pulcy/gluon/service"
 	"github.com/pulcy/gluon/templates"
-	"github.com/pulcy/gluon/util"
 )
 
 const (
@@ -26,10 +25,10 @@ const (
 
 // createKubeAddonManagerManifest creates the manifest containing the kubernetes Kube-addon-manager pod.
 func createKubeAddonManagerManifest(deps service.ServiceDependencies, flags *service.ServiceFlags, c Component) (bool, error) {
-	if err := util.EnsureDirectoryOf(c.ManifestPath(), 0755); err != nil {
+	if err := deps.Target.EnsureDirectoryOf(c.ManifestPath(), 0755); err != nil {
 		return false, maskAny(err)
 	}
 	deps.Logger.Info("creating %s", c.ManifestPath())
-	changed, err := templates.Render(deps.Logger, kubeAddonManagerTemplate, c.ManifestPath(), nil, manifestFileMode)
+	changed, err := templates.Render(deps.Target, kubeAddonManagerTemplate, c.ManifestPath(), nil, manifestFileMode)
 	return changed, maskAny(err)
 }
@@ -21,7 +21,6 @@ import (
 	"github.com/pulcy/gluon/service"
 	"github.com/pulcy/gluon/service/etcd"
 	"github.com/pulcy/gluon/templates"
-	"github.com/pulcy/gluon/util"
 )
 
 const (
@@ -30,7 +29,7 @@ const (
 
 // createKubeApiServerManifest creates the manifest containing the kubernetes Kube-apiserver pod.
 func createKubeApiServerManifest(deps service.ServiceDependencies, flags *service.ServiceFlags, c Component) (bool, error) {
-	if err := util.EnsureDirectoryOf(c.ManifestPath(), 0755); err != nil {
+	if err := deps.Target.EnsureDirectoryOf(c.ManifestPath(), 0755); err != nil {
 		return false, maskAny(err)
 	}
 	deps.Logger.Info("creating %s", c.ManifestPath())
@@ -40,7 +39,7 @@ func createKubeApiServerManifest(deps service.ServiceDependencies, flags *servic
 	}
 	var etcdEndpoints []string
 	for _, m := range members {
-		if !m.EtcdProxy {
+		if m.HasRole(service.RoleEtcd) {
 			etcdEndpoints = append(etcdEndpoints, flags.Etcd.CreateEndpoint(m.ClusterIP))
 		}
 	}
@@ -79,7 +78,7 @@ func createKubeApiServerManifest(deps service.ServiceDependencies, flags *servic
 		CertificatesFolder:    path.Dir(c.CertificatePath()),
 		ServiceAccountKeyPath: serviceAccountsKeyPath,
 	}
-	changed, err := templates.Render(deps.Logger, kubeApiServiceTemplate, c.ManifestPath(), opts, manifestFileMode)
+	changed, err := templates.Render(deps.Target, kubeApiServiceTemplate, c.ManifestPath(), opts, manifestFileMode)
 	return changed, maskAny(err)
 }
 
@@ -19,7 +19,6 @@ import (
 
 	"github.com/pulcy/gluon/service"
 	"github.com/pulcy/gluon/templates"
-	"github.com/pulcy/gluon/util"
 )
 
 const (
@@ -28,7 +27,7 @@ const (
 
 // createKubeControllerManagerManifest creates the manifest containing the kubernetes Kube-controller-manager pod.
 func createKubeControllerManagerManifest(deps service.ServiceDependencies, flags *service.ServiceFlags, c Component) (bool, error) {
-	if err := util.EnsureDirectoryOf(c.ManifestPath(), 0755); err != nil {
+	if err := deps.Target.EnsureDirectoryOf(c.ManifestPath(), 0755); err != nil {
 		return false, maskAny(err)
 	}
 	configChanged, err := createKubeConfig(deps, flags, c)
@@ -57,6 +56,6 @@ func createKubeControllerManagerManifest(deps service.ServiceDependencies, flags
 		CAPath:                c.CAPath(),
 		CertificatesFolder:    path.Dir(c.CertificatePath()),
 	}
-	changed, err := templates.Render(deps.Logger, kubeControllerManagerTemplate, c.ManifestPath(), opts, manifestFileMode)
+	changed, err := templates.Render(deps.Target, kubeControllerManagerTemplate, c.ManifestPath(), opts, manifestFileMode)
 	return changed || configChanged, maskAny(err)
 }
@@ -17,7 +17,6 @@ package kubernetes
 import (
 	"github.com/pulcy/gluon/service"
 	"github.com/pulcy/gluon/templates"
-	"github.com/pulcy/gluon/util"
 )
 
 const (
@@ -26,7 +25,7 @@ const (
 
 // createKubeDNSAddon creates the manifest containing the kubernetes Kube-dns addon.
 func createKubeDNSAddon(deps service.ServiceDependencies, flags *service.ServiceFlags, c Component) (bool, error) {
-	if err := util.EnsureDirectoryOf(c.AddonPath(), 0755); err != nil {
+	if err := deps.Target.EnsureDirectoryOf(c.AddonPath(), 0755); err != nil {
 		return false, maskAny(err)
 	}
 	deps.Logger.Info("creating %s", c.AddonPath())
@@ -39,6 +38,6 @@ func createKubeDNSAddon(deps service.ServiceDependencies, flags *service.Service
 		ClusterDomain: flags.Kubernetes.ClusterDomain,
 		Version:       "1.11.0",
 	}
-	changed, err := templates.Render(deps.Logger, kubeDNSTemplate, c.AddonPath(), opts, manifestFileMode)
+	changed, err := templates.Render(deps.Target, kubeDNSTemplate, c.AddonPath(), opts, manifestFileMode)
 	return changed, maskAny(err)
 }
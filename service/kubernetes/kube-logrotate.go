@@ -29,12 +29,12 @@ const (
 // createKubeLogrotateService creates the file containing the kubernetes Kube-logrotate service.
 func createKubeLogrotateService(deps service.ServiceDependencies, flags *service.ServiceFlags, c Component) (bool, error) {
 	deps.Logger.Info("creating %s", kubeLogrotateConfPath)
-	confChanged, err := templates.Render(deps.Logger, kubeLogrotateConfTemplate, kubeLogrotateConfPath, nil, configFileMode)
+	confChanged, err := templates.Render(deps.Target, kubeLogrotateConfTemplate, kubeLogrotateConfPath, nil, configFileMode)
 
 	deps.Logger.Info("creating %s", c.ServicePath())
-	serviceChanged, err := templates.Render(deps.Logger, kubeLogrotateServiceTemplate, c.ServicePath(), nil, serviceFileMode)
+	serviceChanged, err := templates.Render(deps.Target, kubeLogrotateServiceTemplate, c.ServicePath(), nil, serviceFileMode)
 
 	deps.Logger.Info("creating %s", c.TimerPath())
-	timerChanged, err := templates.Render(deps.Logger, kubeLogrotateTimerTemplate, c.TimerPath(), nil, serviceFileMode)
+	timerChanged, err := templates.Render(deps.Target, kubeLogrotateTimerTemplate, c.TimerPath(), nil, serviceFileMode)
 	return confChanged || serviceChanged || timerChanged, maskAny(err)
 }
@@ -49,6 +49,6 @@ func createKubeProxyService(deps service.ServiceDependencies, flags *service.Ser
 		KubeConfigPath:   c.KubeConfigPath(),
 		Master:           apiServers[0],
 	}
-	changed, err := templates.Render(deps.Logger, kubeProxyServiceTemplate, c.ServicePath(), opts, serviceFileMode)
+	changed, err := templates.Render(deps.Target, kubeProxyServiceTemplate, c.ServicePath(), opts, serviceFileMode)
 	return changed || configChanged, maskAny(err)
 }
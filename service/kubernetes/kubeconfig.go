@@ -19,7 +19,6 @@ import (
 
 	"github.com/pulcy/gluon/service"
 	"github.com/pulcy/gluon/templates"
-	"github.com/pulcy/gluon/util"
 )
 
 const (
@@ -29,7 +28,7 @@ const (
 // createKubeConfig creates a component specific kubeconfig configuration file.
 func createKubeConfig(deps service.ServiceDependencies, flags *service.ServiceFlags, c Component) (bool, error) {
 	deps.Logger.Info("creating %s", c.KubeConfigPath())
-	if err := util.EnsureDirectoryOf(c.KubeConfigPath(), 0755); err != nil {
+	if err := deps.Target.EnsureDirectoryOf(c.KubeConfigPath(), 0755); err != nil {
 		return false, maskAny(err)
 	}
 	members, err := flags.GetClusterMembers(deps.Logger)
@@ -38,7 +37,7 @@ func createKubeConfig(deps service.ServiceDependencies, flags *service.ServiceFl
 	}
 	var apiServers []string
 	for _, m := range members {
-		if !m.EtcdProxy {
+		if m.HasRole(service.RoleAPIServer) {
 			apiServers = append(apiServers, fmt.Sprintf("https://%s:%d", m.ClusterIP, flags.Kubernetes.APIServerPort))
 		}
 	}
@@ -57,6 +56,6 @@ func createKubeConfig(deps service.ServiceDependencies, flags *service.ServiceFl
 		ClientCertPath: c.CertificatePath(),
 		ClientKeyPath:  c.KeyPath(),
 	}
-	changed, err := templates.Render(deps.Logger, kubeConfigTemplate, c.KubeConfigPath(), opts, configFileMode)
+	changed, err := templates.Render(deps.Target, kubeConfigTemplate, c.KubeConfigPath(), opts, configFileMode)
 	return changed, maskAny(err)
 }
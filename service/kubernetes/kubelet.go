@@ -15,6 +15,8 @@
 package kubernetes
 
 import (
+	"strings"
+
 	"github.com/pulcy/gluon/service"
 	"github.com/pulcy/gluon/templates"
 )
@@ -29,6 +31,14 @@ func createKubeletService(deps service.ServiceDependencies, flags *service.Servi
 	if err != nil {
 		return false, maskAny(err)
 	}
+	member, err := flags.ThisMember(deps.Logger)
+	if err != nil {
+		return false, maskAny(err)
+	}
+	nodeLabels := member.NodeLabels()
+	if flags.Kubernetes.Metadata != "" {
+		nodeLabels = append([]string{flags.Kubernetes.Metadata}, nodeLabels...)
+	}
 	deps.Logger.Info("creating %s", c.ServicePath())
 	opts := struct {
 		Requires            []string
@@ -40,6 +50,7 @@ func createKubeletService(deps service.ServiceDependencies, flags *service.Servi
 		RegisterSchedulable bool
 		NodeIP              string
 		NodeLabels          string
+		Taints              string
 		CertPath            string
 		KeyPath             string
 	}{
@@ -51,10 +62,11 @@ func createKubeletService(deps service.ServiceDependencies, flags *service.Servi
 		KubeConfigPath:      c.KubeConfigPath(),
 		RegisterSchedulable: true, //!flags.HasRole("core"),
 		NodeIP:              flags.Network.ClusterIP,
-		NodeLabels:          flags.Kubernetes.Metadata,
+		NodeLabels:          strings.Join(nodeLabels, ","),
+		Taints:              strings.Join(member.Taints, ","),
 		CertPath:            c.CertificatePath(),
 		KeyPath:             c.KeyPath(),
 	}
-	changed, err := templates.Render(deps.Logger, kubeletServiceTemplate, c.ServicePath(), opts, serviceFileMode)
+	changed, err := templates.Render(deps.Target, kubeletServiceTemplate, c.ServicePath(), opts, serviceFileMode)
 	return changed || configChanged, maskAny(err)
 }
@@ -18,6 +18,7 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"sort"
 
 	"github.com/juju/errgo"
 
@@ -77,6 +78,27 @@ func (t *k8sService) Name() string {
 	return "kubernetes"
 }
 
+// CertificateFiles returns the paths of the certificates of all components installed on this node.
+func (t *k8sService) CertificateFiles(flags *service.ServiceFlags) []string {
+	if !flags.Kubernetes.IsEnabled() {
+		return nil
+	}
+	var result []string
+	for c, compSetup := range components {
+		if !compSetup.CreateCertificates || (c.MasterOnly() && !flags.HasRole(service.RoleAPIServer)) {
+			continue
+		}
+		result = append(result, c.CertificatePath(), c.KeyPath(), c.CAPath())
+	}
+	sort.Strings(result)
+	return result
+}
+
+// Kubernetes needs the CNI binaries, the rkt API, the weave network & vault for its certificates.
+func (t *k8sService) Dependencies() []string {
+	return []string{"binaries", "docker", "etcd", "rkt", "vault", "weave"}
+}
+
 func (t *k8sService) Setup(deps service.ServiceDependencies, flags *service.ServiceFlags) error {
 	runKubernetes := flags.Kubernetes.IsEnabled()
 	if runKubernetes {
@@ -85,35 +107,42 @@ func (t *k8sService) Setup(deps service.ServiceDependencies, flags *service.Serv
 			return maskAny(err)
 		}
 
-		// Install template & service that extracts the service-accounts-key-file
-		serviceAccountsTemplateChanged, err := createServiceAccountsTemplate(deps, flags)
-		if err != nil {
-			return maskAny(err)
-		}
-		serviceAccountsServiceChanged, err := createServiceAccountsService(deps, flags)
-		if err != nil {
-			return maskAny(err)
-		}
-		isActive, err := deps.Systemd.IsActive(serviceAccountsTokenServiceName)
-		if err != nil {
-			return maskAny(err)
-		}
-
-		if !isActive || serviceAccountsTemplateChanged || serviceAccountsServiceChanged || flags.Force {
-			if err := deps.Systemd.Enable(serviceAccountsTokenServiceName); err != nil {
+		if flags.CA.IsLocal() {
+			// The service-accounts-key-file is taken from the local CA
+			if err := installLocalServiceAccountsKey(deps); err != nil {
 				return maskAny(err)
 			}
-			if err := deps.Systemd.Reload(); err != nil {
+		} else {
+			// Install template & service that extracts the service-accounts-key-file
+			serviceAccountsTemplateChanged, err := createServiceAccountsTemplate(deps, flags)
+			if err != nil {
+				return maskAny(err)
+			}
+			serviceAccountsServiceChanged, err := createServiceAccountsService(deps, flags)
+			if err != nil {
 				return maskAny(err)
 			}
-			if err := deps.Systemd.Restart(serviceAccountsTokenServiceName); err != nil {
+			isActive, err := deps.Systemd.IsActive(serviceAccountsTokenServiceName)
+			if err != nil {
 				return maskAny(err)
 			}
+
+			if !isActive || serviceAccountsTemplateChanged || serviceAccountsServiceChanged || flags.Force {
+				if err := deps.Systemd.Enable(serviceAccountsTokenServiceName); err != nil {
+					return maskAny(err)
+				}
+				if err := deps.Systemd.Reload(); err != nil {
+					return maskAny(err)
+				}
+				if err := deps.Systemd.Restart(serviceAccountsTokenServiceName); err != nil {
+					return maskAny(err)
+				}
+			}
 		}
 	}
 	for c, compSetup := range components {
 		installComponent := runKubernetes
-		if c.MasterOnly() && !flags.HasRole("core") {
+		if c.MasterOnly() && !flags.HasRole(service.RoleAPIServer) {
 			installComponent = false
 		}
 		var certsTimerChanged, certsServiceChanged bool
@@ -192,56 +221,68 @@ func (t *k8sService) Setup(deps service.ServiceDependencies, flags *service.Serv
 				}
 			}
 		} else {
-			// Component service no longer needed, remove it
-			if c.IsManifest() {
-				os.Remove(c.ManifestPath())
-			} else {
-				if c.HasTimer() {
-					if exists, err := deps.Systemd.Exists(c.TimerName()); err != nil {
-						return maskAny(err)
-					} else if exists {
-						if err := deps.Systemd.Disable(c.TimerName()); err != nil {
-							deps.Logger.Errorf("Disabling %s failed: %#v", c.TimerName(), err)
-						} else {
-							os.Remove(c.TimerPath())
-						}
-					}
-				}
-				if exists, err := deps.Systemd.Exists(c.ServiceName()); err != nil {
-					return maskAny(err)
-				} else if exists {
-					if err := deps.Systemd.Disable(c.ServiceName()); err != nil {
-						deps.Logger.Errorf("Disabling %s failed: %#v", c.ServiceName(), err)
-					} else {
-						os.Remove(c.ServicePath())
-					}
-				}
+			// Component no longer needed, remove it
+			if err := teardownComponent(deps, c); err != nil {
+				return maskAny(err)
 			}
+		}
+	}
 
-			// k8s-*-certs.timer no longer needed, remove it
-			if exists, err := deps.Systemd.Exists(c.CertificatesTimerName()); err != nil {
+	return nil
+}
+
+// Teardown removes all components, their certificates and the service-accounts-token service.
+func (t *k8sService) Teardown(deps service.ServiceDependencies, flags *service.ServiceFlags) error {
+	for c := range components {
+		if err := teardownComponent(deps, c); err != nil {
+			return maskAny(err)
+		}
+		for _, p := range []string{c.AddonPath(), c.KubeConfigPath(), c.CertificatePath(), c.KeyPath(), c.CAPath()} {
+			if p == "" {
+				continue
+			}
+			if err := deps.Target.Remove(p); err != nil {
 				return maskAny(err)
-			} else if exists {
-				if err := deps.Systemd.Disable(c.CertificatesTimerName()); err != nil {
-					deps.Logger.Errorf("Disabling %s failed: %#v", c.CertificatesTimerName(), err)
-				} else {
-					os.Remove(c.CertificatesTimerPath())
-				}
 			}
+		}
+	}
+	if err := deps.Systemd.StopAndRemove(serviceAccountsTokenServiceName, servicePath(serviceAccountsTokenServiceName)); err != nil {
+		return maskAny(err)
+	}
+	for _, p := range []string{certificatePath(serviceAccountsTokenTemplateName), serviceAccountsKeyPath, kubeLogrotateConfPath} {
+		if err := deps.Target.Remove(p); err != nil {
+			return maskAny(err)
+		}
+	}
+	if err := unlinkCniBinaries(deps); err != nil {
+		return maskAny(err)
+	}
+	return maskAny(deps.Systemd.Reload())
+}
 
-			// k8s-*-certs.service no longer needed, remove it
-			if exists, err := deps.Systemd.Exists(c.CertificatesServiceName()); err != nil {
+// teardownComponent stops & removes the service, timer or manifest of the given component,
+// as well as the service & timer that create its certificates.
+func teardownComponent(deps service.ServiceDependencies, c Component) error {
+	if c.IsManifest() {
+		if err := deps.Target.Remove(c.ManifestPath()); err != nil {
+			return maskAny(err)
+		}
+	} else {
+		if c.HasTimer() {
+			if err := deps.Systemd.StopAndRemove(c.TimerName(), c.TimerPath()); err != nil {
 				return maskAny(err)
-			} else if exists {
-				if err := deps.Systemd.Disable(c.CertificatesServiceName()); err != nil {
-					deps.Logger.Errorf("Disabling %s failed: %#v", c.CertificatesServiceName(), err)
-				} else {
-					os.Remove(c.CertificatesServicePath())
-				}
 			}
 		}
+		if err := deps.Systemd.StopAndRemove(c.ServiceName(), c.ServicePath()); err != nil {
+			return maskAny(err)
+		}
+	}
+	if err := deps.Systemd.StopAndRemove(c.CertificatesTimerName(), c.CertificatesTimerPath()); err != nil {
+		return maskAny(err)
+	}
+	if err := deps.Systemd.StopAndRemove(c.CertificatesServiceName(), c.CertificatesServicePath()); err != nil {
+		return maskAny(err)
 	}
-
 	return nil
 }
 
@@ -253,7 +294,7 @@ func getAPIServers(deps service.ServiceDependencies, flags *service.ServiceFlags
 	}
 	var apiServers []string
 	for _, m := range members {
-		if !m.EtcdProxy {
+		if m.HasRole(service.RoleAPIServer) {
 			apiServers = append(apiServers, fmt.Sprintf("https://%s:%d", m.ClusterIP, flags.Kubernetes.APIServerPort))
 		}
 	}
@@ -16,11 +16,12 @@ package kubernetes
 
 import (
 	"fmt"
+	"os"
 	"text/template"
 
+	"github.com/pulcy/gluon/pki"
 	"github.com/pulcy/gluon/service"
 	"github.com/pulcy/gluon/templates"
-	"github.com/pulcy/gluon/util"
 )
 
 const (
@@ -30,6 +31,7 @@ const (
 )
 
 var (
+	serviceAccountsKeyFileMode       = os.FileMode(0600)
 	serviceAccountsKeyPath           = certificatePath(fmt.Sprintf("%s.key", compNameKubeServiceAccounts))
 	serviceAccountsTokenTemplateName = fmt.Sprintf("%s.template", compNameKubeServiceAccounts)
 )
@@ -37,7 +39,7 @@ var (
 // createServiceAccountsTemplate creates the consul-template used by the k8s-certs service.
 func createServiceAccountsTemplate(deps service.ServiceDependencies, flags *service.ServiceFlags) (bool, error) {
 	serviceAccountsTokenTemplatePath := certificatePath(serviceAccountsTokenTemplateName)
-	if err := util.EnsureDirectoryOf(serviceAccountsTokenTemplatePath, 0755); err != nil {
+	if err := deps.Target.EnsureDirectoryOf(serviceAccountsTokenTemplatePath, 0755); err != nil {
 		return false, maskAny(err)
 	}
 	deps.Logger.Info("creating %s", serviceAccountsTokenTemplatePath)
@@ -53,7 +55,7 @@ func createServiceAccountsTemplate(deps service.ServiceDependencies, flags *serv
 	setDelims := func(t *template.Template) {
 		t.Delims("[[", "]]")
 	}
-	changed, err := templates.Render(deps.Logger, serviceAccountsTokenTemplateTemplate, serviceAccountsTokenTemplatePath, opts, templateFileMode, setDelims)
+	changed, err := templates.Render(deps.Target, serviceAccountsTokenTemplateTemplate, serviceAccountsTokenTemplatePath, opts, templateFileMode, setDelims)
 	return changed, maskAny(err)
 }
 
@@ -89,6 +91,39 @@ func createServiceAccountsService(deps service.ServiceDependencies, flags *servi
 		TokenPolicy:        fmt.Sprintf("secret/%s/k8s/token/%s", clusterID, compNameKubeServiceAccounts),
 		TokenRole:          tokenRole(clusterID, compNameKubeServiceAccounts),
 	}
-	changed, err := templates.Render(deps.Logger, serviceAccountsTokenServiceTemplate, serviceAccountsTokenServicePath, opts, serviceFileMode)
+	changed, err := templates.Render(deps.Target, serviceAccountsTokenServiceTemplate, serviceAccountsTokenServicePath, opts, serviceFileMode)
 	return changed, maskAny(err)
 }
+
+// installLocalServiceAccountsKey copies the service accounts key of the local CA to the
+// service-accounts-key-file and removes the service that extracts it from vault.
+// Kubelet is restarted when the key has changed, just like the vault service does.
+func installLocalServiceAccountsKey(deps service.ServiceDependencies) error {
+	if err := deps.Systemd.StopAndRemove(serviceAccountsTokenServiceName, servicePath(serviceAccountsTokenServiceName)); err != nil {
+		return maskAny(err)
+	}
+	if err := deps.Target.Remove(certificatePath(serviceAccountsTokenTemplateName)); err != nil {
+		return maskAny(err)
+	}
+	key, err := deps.Target.ReadFile(pki.ServiceAccountsKeyPath)
+	if err != nil {
+		return maskAny(err)
+	}
+	if err := deps.Target.EnsureDirectoryOf(serviceAccountsKeyPath, 0755); err != nil {
+		return maskAny(err)
+	}
+	deps.Logger.Info("creating %s", serviceAccountsKeyPath)
+	changed, err := deps.Target.UpdateFile(serviceAccountsKeyPath, key, serviceAccountsKeyFileMode)
+	if err != nil {
+		return maskAny(err)
+	}
+	kubeletServiceName := NewServiceComponent(compNameKubelet, false).ServiceName()
+	if isActive, err := deps.Systemd.IsActive(kubeletServiceName); err != nil {
+		return maskAny(err)
+	} else if changed && isActive {
+		if err := deps.Systemd.Restart(kubeletServiceName); err != nil {
+			return maskAny(err)
+		}
+	}
+	return nil
+}
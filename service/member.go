@@ -0,0 +1,114 @@
+// Copyright (c) 2017 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"fmt"
+	"sort"
+)
+
+const (
+	RoleCore   = "core"
+	RoleLB     = "lb"
+	RoleVault  = "vault"
+	RoleWorker = "worker"
+
+	// Roles of a member within the cluster. A core member has all of them.
+	RoleEtcd         = "etcd"          // Member is an ETCD peer (not a proxy)
+	RoleConsulServer = "consul-server" // Member runs consul as server
+	RoleWeaveSeed    = "weave-seed"    // Member is part of the initial weave peers
+	RoleAPIServer    = "k8s-api"       // Member runs the kubernetes API server
+
+	zoneLabel = "failure-domain.beta.kubernetes.io/zone"
+)
+
+var (
+	// KnownRoles contains all roles that can be assigned to a machine.
+	KnownRoles = []string{RoleCore, RoleLB, RoleVault, RoleWorker, RoleEtcd, RoleConsulServer, RoleWeaveSeed, RoleAPIServer}
+	// coreRoles contains the roles implied by RoleCore.
+	coreRoles = []string{RoleEtcd, RoleConsulServer, RoleWeaveSeed, RoleAPIServer}
+)
+
+type ClusterMember struct {
+	MachineID     string            `json:"machine-id"`
+	ClusterIP     string            `json:"cluster-ip"`                // IP address of member used for internal cluster traffic (e.g. etcd)
+	PrivateHostIP string            `json:"private-host-ip,omitempty"` // IP address of member host (can be same as ClusterIP)
+	EtcdProxy     bool              `json:"etcd-proxy,omitempty"`      // Deprecated: use Roles
+	Hostname      string            `json:"hostname,omitempty"`
+	Zone          string            `json:"zone,omitempty"`   // Failure domain of the member
+	Roles         []string          `json:"roles,omitempty"`  // If empty, roles are derived from EtcdProxy
+	Labels        map[string]string `json:"labels,omitempty"` // Kubelet node labels
+	Taints        []string          `json:"taints,omitempty"` // Kubelet node taints (key=value:effect)
+}
+
+// HasRole returns true if the member has the given role.
+// Members without explicit roles are core members, unless they are an ETCD proxy.
+func (m ClusterMember) HasRole(role string) bool {
+	roles := m.Roles
+	if len(roles) == 0 {
+		if m.EtcdProxy {
+			roles = []string{RoleWorker}
+		} else {
+			roles = []string{RoleCore}
+		}
+	}
+	return hasRole(roles, role)
+}
+
+// hasRole returns true if the given role is found in the given list of roles,
+// or is implied by a core role in that list.
+func hasRole(roles []string, role string) bool {
+	for _, x := range roles {
+		if x == role {
+			return true
+		}
+		if x == RoleCore {
+			for _, c := range coreRoles {
+				if c == role {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// NodeLabels returns the kubelet node labels of the member, sorted by key.
+func (m ClusterMember) NodeLabels() []string {
+	labels := make(map[string]string)
+	for k, v := range m.Labels {
+		labels[k] = v
+	}
+	if m.Zone != "" {
+		labels[zoneLabel] = m.Zone
+	}
+	var result []string
+	for k, v := range labels {
+		result = append(result, fmt.Sprintf("%s=%s", k, v))
+	}
+	sort.Strings(result)
+	return result
+}
+
+// normalize makes EtcdProxy and PrivateHostIP consistent with the other fields,
+// so code that only looks at those fields keeps working.
+func (m *ClusterMember) normalize() {
+	if m.PrivateHostIP == "" {
+		m.PrivateHostIP = m.ClusterIP
+	}
+	if len(m.Roles) > 0 {
+		m.EtcdProxy = !m.HasRole(RoleEtcd)
+	}
+}
@@ -0,0 +1,244 @@
+// Copyright (c) 2017 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/op/go-logging"
+
+	"github.com/pulcy/gluon/util"
+)
+
+const (
+	// FileMemberSource is the name of the member source that reads the members from the configuration file.
+	FileMemberSource        = "file"
+	defaultEtcdMembersKey   = "/pulcy/cluster-members"
+	memberSourceTimeout     = time.Second * 10
+	consulMachineIDMeta     = "machine-id"
+	consulPrivateHostIPMeta = "private-host-ip"
+	consulRolesMeta         = "roles"
+	consulZoneMeta          = "zone"
+)
+
+// MemberSource provides the list of cluster members.
+type MemberSource interface {
+	// Members returns all members of the cluster.
+	Members() ([]ClusterMember, error)
+	// String returns a human readable description of the source.
+	String() string
+}
+
+// NewMemberSource creates a member source from the given specification.
+// Supported specifications are:
+// - `file` (or empty): members listed in /etc/pulcy/gluon.json (or the legacy cluster-members file)
+// - `etcd://<host>:<port>[/<key prefix>]`: members stored under the key prefix in the ETCD v2 keys API
+// - `consul://<host>:<port>`: nodes registered in the catalog of Consul
+func NewMemberSource(spec string, target *util.Target, log *logging.Logger) (MemberSource, error) {
+	if spec == "" || spec == FileMemberSource {
+		return &fileSource{target: target}, nil
+	}
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	if u.Host == "" {
+		return nil, maskAny(fmt.Errorf("Member source '%s' has no host", spec))
+	}
+	if log == nil {
+		log = logging.MustGetLogger("gluon")
+	}
+	client := &http.Client{Timeout: memberSourceTimeout}
+	switch u.Scheme {
+	case "etcd":
+		prefix := u.Path
+		if prefix == "" || prefix == "/" {
+			prefix = defaultEtcdMembersKey
+		}
+		return &etcdSource{
+			client:   client,
+			endpoint: fmt.Sprintf("http://%s", u.Host),
+			prefix:   prefix,
+			log:      log,
+		}, nil
+	case "consul":
+		return &consulSource{
+			client:   client,
+			endpoint: fmt.Sprintf("http://%s", u.Host),
+			log:      log,
+		}, nil
+	default:
+		return nil, maskAny(fmt.Errorf("Unknown member source '%s', expected file, etcd://... or consul://...", spec))
+	}
+}
+
+// fileSource reads the members from the configuration file.
+type fileSource struct {
+	target *util.Target
+}
+
+func (s *fileSource) Members() ([]ClusterMember, error) {
+	cfg, _, err := LoadConfig(s.target)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	if len(cfg.Members) == 0 {
+		return nil, maskAny(fmt.Errorf("No cluster members found in %s", ConfigPath))
+	}
+	return cfg.Members, nil
+}
+
+func (s *fileSource) String() string {
+	return ConfigPath
+}
+
+// etcdSource reads the members from the ETCD v2 keys API.
+// Every member is stored in a key named by its machine ID. The value is a JSON encoded ClusterMember
+// or a line in the format of the legacy cluster-members file (without the machine ID).
+type etcdSource struct {
+	client   *http.Client
+	endpoint string
+	prefix   string
+	log      *logging.Logger
+}
+
+// discoveryResponse is the response of a GET request on the ETCD v2 keys API.
+type discoveryResponse struct {
+	Node   discoveryNode `json:"node"`
+	Action string        `json:"action"`
+}
+
+type discoveryNode struct {
+	Key   string          `json:"key,omitempty"`
+	Value string          `json:"value,omitempty"`
+	Nodes []discoveryNode `json:"nodes,omitempty"`
+}
+
+func (s *etcdSource) Members() ([]ClusterMember, error) {
+	var resp discoveryResponse
+	if err := getJSON(s.client, s.endpoint+path.Join("/v2/keys", s.prefix)+"?recursive=true", &resp); err != nil {
+		return nil, maskAny(err)
+	}
+	var members []ClusterMember
+	for _, node := range resp.Node.Nodes {
+		id := path.Base(node.Key)
+		value := strings.TrimSpace(node.Value)
+		var m ClusterMember
+		if strings.HasPrefix(value, "{") {
+			if err := json.Unmarshal([]byte(value), &m); err != nil {
+				s.log.Warningf("Cannot parse member %s: %v", node.Key, err)
+				continue
+			}
+		} else {
+			parsed := parseClusterMembers([]string{id + "=" + value}, s.log)
+			if len(parsed) == 0 {
+				s.log.Warningf("Cannot parse member %s", node.Key)
+				continue
+			}
+			m = parsed[0]
+		}
+		if m.MachineID == "" {
+			m.MachineID = id
+		}
+		m.normalize()
+		members = append(members, m)
+	}
+	if len(members) == 0 {
+		return nil, maskAny(fmt.Errorf("No cluster members found in %s", s))
+	}
+	sortMembers(members)
+	return members, nil
+}
+
+func (s *etcdSource) String() string {
+	return fmt.Sprintf("etcd %s%s", s.endpoint, s.prefix)
+}
+
+// consulSource reads the members from the node catalog of Consul.
+// Only nodes with a `machine-id` node meta value are used.
+type consulSource struct {
+	client   *http.Client
+	endpoint string
+	log      *logging.Logger
+}
+
+type consulNode struct {
+	Node    string
+	Address string
+	Meta    map[string]string
+}
+
+func (s *consulSource) Members() ([]ClusterMember, error) {
+	var nodes []consulNode
+	if err := getJSON(s.client, s.endpoint+"/v1/catalog/nodes", &nodes); err != nil {
+		return nil, maskAny(err)
+	}
+	var members []ClusterMember
+	for _, n := range nodes {
+		id := n.Meta[consulMachineIDMeta]
+		if id == "" {
+			s.log.Debugf("Skipping consul node %s, it has no %s meta value", n.Node, consulMachineIDMeta)
+			continue
+		}
+		m := ClusterMember{
+			MachineID:     id,
+			ClusterIP:     n.Address,
+			PrivateHostIP: n.Meta[consulPrivateHostIPMeta],
+			Hostname:      n.Node,
+			Zone:          n.Meta[consulZoneMeta],
+			Roles:         splitList(n.Meta[consulRolesMeta]),
+		}
+		m.normalize()
+		members = append(members, m)
+	}
+	if len(members) == 0 {
+		return nil, maskAny(fmt.Errorf("No cluster members found in %s", s))
+	}
+	sortMembers(members)
+	return members, nil
+}
+
+func (s *consulSource) String() string {
+	return fmt.Sprintf("consul %s", s.endpoint)
+}
+
+// getJSON performs a GET request on the given URL and decodes the JSON response into result.
+func getJSON(client *http.Client, url string, result interface{}) error {
+	resp, err := client.Get(url)
+	if err != nil {
+		return maskAny(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return maskAny(fmt.Errorf("GET %s returned status %d", url, resp.StatusCode))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return maskAny(fmt.Errorf("Cannot decode response of %s: %v", url, err))
+	}
+	return nil
+}
+
+// sortMembers sorts the given members by cluster IP, so the order of a
+// remote source is stable.
+func sortMembers(members []ClusterMember) {
+	sort.Slice(members, func(i, j int) bool { return members[i].ClusterIP < members[j].ClusterIP })
+}
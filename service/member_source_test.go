@@ -0,0 +1,95 @@
+package service
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	logging "github.com/op/go-logging"
+
+	"github.com/pulcy/gluon/util"
+)
+
+// newMemberSourceStub creates a local HTTP server that serves members
+// the way ETCD (v2 keys API) and Consul (catalog) do.
+func newMemberSourceStub() *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v2/keys/pulcy/cluster-members", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"action":"get","node":{"key":"/pulcy/cluster-members","dir":true,"nodes":[
+			{"key":"/pulcy/cluster-members/b7bdc73a4e4311ee80cabd7d1e4658a2","value":"{\"cluster-ip\":\"192.168.0.2\",\"roles\":[\"worker\"]}"},
+			{"key":"/pulcy/cluster-members/b7bdc73a4e4311ee80cabd7d1e4658a1","value":"192.168.0.1 private-host-ip=10.0.0.1"}
+		]}}`))
+	})
+	mux.HandleFunc("/v1/catalog/nodes", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[
+			{"Node":"core-1","Address":"192.168.0.1","Meta":{"machine-id":"b7bdc73a4e4311ee80cabd7d1e4658a1","roles":"core","zone":"z1"}},
+			{"Node":"other","Address":"192.168.0.9","Meta":{}}
+		]`))
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestEtcdMemberSource(t *testing.T) {
+	stub := newMemberSourceStub()
+	defer stub.Close()
+
+	source, err := NewMemberSource("etcd://"+strings.TrimPrefix(stub.URL, "http://"), nil, nil)
+	if err != nil {
+		t.Fatalf("NewMemberSource failed: %v", err)
+	}
+	members, err := source.Members()
+	if err != nil {
+		t.Fatalf("Members failed: %v", err)
+	}
+	if len(members) != 2 {
+		t.Fatalf("Expected 2 members, got %v", members)
+	}
+	if m := members[0]; m.ClusterIP != "192.168.0.1" || m.PrivateHostIP != "10.0.0.1" || !m.HasRole(RoleEtcd) {
+		t.Errorf("Unexpected first member %v", m)
+	}
+	if m := members[1]; m.MachineID != "b7bdc73a4e4311ee80cabd7d1e4658a2" || m.PrivateHostIP != "192.168.0.2" || !m.EtcdProxy {
+		t.Errorf("Unexpected second member %v", m)
+	}
+}
+
+func TestConsulMemberSource(t *testing.T) {
+	stub := newMemberSourceStub()
+	defer stub.Close()
+
+	source, err := NewMemberSource("consul://"+strings.TrimPrefix(stub.URL, "http://"), nil, nil)
+	if err != nil {
+		t.Fatalf("NewMemberSource failed: %v", err)
+	}
+	members, err := source.Members()
+	if err != nil {
+		t.Fatalf("Members failed: %v", err)
+	}
+	if len(members) != 1 || members[0].Hostname != "core-1" || members[0].Zone != "z1" || !members[0].HasRole(RoleConsulServer) {
+		t.Errorf("Unexpected members %v", members)
+	}
+}
+
+func TestMemberSourceFallsBackToFile(t *testing.T) {
+	root, err := ioutil.TempDir("", "gluon-member-source-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	writeTestFile(t, root, clusterMembersPath, "b7bdc73a4e4311ee80cabd7d1e4658a1=192.168.0.1\n")
+
+	stub := newMemberSourceStub()
+	stub.Close() // Make the source unreachable
+
+	flags := &ServiceFlags{MemberSource: "etcd://" + strings.TrimPrefix(stub.URL, "http://")}
+	flags.target = util.NewTarget(logging.MustGetLogger("test"), root, nil)
+	members, err := flags.GetClusterMembers(nil)
+	if err != nil {
+		t.Fatalf("GetClusterMembers failed: %v", err)
+	}
+	if len(members) != 1 || members[0].ClusterIP != "192.168.0.1" {
+		t.Errorf("Unexpected members %v", members)
+	}
+}
@@ -0,0 +1,47 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMemberRoles(t *testing.T) {
+	legacyCore := ClusterMember{}
+	legacyProxy := ClusterMember{EtcdProxy: true}
+	explicit := ClusterMember{Roles: []string{RoleEtcd, RoleWorker}}
+	explicit.normalize()
+
+	tests := []struct {
+		Member   ClusterMember
+		Role     string
+		Expected bool
+	}{
+		{legacyCore, RoleEtcd, true},
+		{legacyCore, RoleConsulServer, true},
+		{legacyCore, RoleWorker, false},
+		{legacyProxy, RoleEtcd, false},
+		{legacyProxy, RoleWorker, true},
+		{explicit, RoleEtcd, true},
+		{explicit, RoleWeaveSeed, false},
+		{explicit, RoleAPIServer, false},
+	}
+	for _, test := range tests {
+		if result := test.Member.HasRole(test.Role); result != test.Expected {
+			t.Errorf("Expected HasRole(%s) of %v to be %v, got %v", test.Role, test.Member, test.Expected, result)
+		}
+	}
+	if explicit.EtcdProxy {
+		t.Errorf("Expected member with etcd role not to be an etcd proxy")
+	}
+}
+
+func TestMemberNodeLabels(t *testing.T) {
+	m := ClusterMember{
+		Zone:   "eu-1a",
+		Labels: map[string]string{"disk": "ssd"},
+	}
+	expected := []string{"disk=ssd", "failure-domain.beta.kubernetes.io/zone=eu-1a"}
+	if labels := m.NodeLabels(); !reflect.DeepEqual(labels, expected) {
+		t.Errorf("Expected %v, got %v", expected, labels)
+	}
+}
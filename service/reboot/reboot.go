@@ -0,0 +1,149 @@
+// Copyright (c) 2017 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reboot
+
+import (
+	"os"
+
+	"github.com/juju/errgo"
+
+	"github.com/pulcy/gluon/service"
+	"github.com/pulcy/gluon/templates"
+)
+
+var (
+	maskAny = errgo.MaskFunc(errgo.Any)
+)
+
+const (
+	releaseServiceName     = "gluon-reboot-release.service"
+	releaseServiceTemplate = "templates/reboot/" + releaseServiceName + ".tmpl"
+	releaseServicePath     = "/etc/systemd/system/" + releaseServiceName
+	rebootServiceName      = "gluon-reboot.service"
+	rebootServiceTemplate  = "templates/reboot/" + rebootServiceName + ".tmpl"
+	rebootServicePath      = "/etc/systemd/system/" + rebootServiceName
+	rebootTimerName        = "gluon-reboot.timer"
+	rebootTimerTemplate    = "templates/reboot/" + rebootTimerName + ".tmpl"
+	rebootTimerPath        = "/etc/systemd/system/" + rebootTimerName
+
+	fileMode = os.FileMode(0644)
+)
+
+func NewService() service.Service {
+	return &rebootService{}
+}
+
+// rebootService installs the units that coordinate reboots through locks in ETCD.
+// The release service frees the locks of this machine after every boot, once it is healthy.
+// The reboot timer (only with automatic reboots) reboots the machine when the OS needs it.
+type rebootService struct{}
+
+func (t *rebootService) Name() string {
+	return "reboot"
+}
+
+// The reboot locks are stored in ETCD.
+func (t *rebootService) Dependencies() []string {
+	return []string{"etcd"}
+}
+
+func (t *rebootService) Setup(deps service.ServiceDependencies, flags *service.ServiceFlags) error {
+	changedRelease, err := createReleaseService(deps, flags)
+	if err != nil {
+		return maskAny(err)
+	}
+	if flags.Force || changedRelease {
+		if err := deps.Systemd.Reload(); err != nil {
+			return maskAny(err)
+		}
+		// Do not start it here, it would wait for setup itself to finish
+		if err := deps.Systemd.Enable(releaseServiceName); err != nil {
+			return maskAny(err)
+		}
+	}
+
+	if !flags.Reboot.Auto {
+		// Automatic reboots not wanted, remove the timer
+		return maskAny(removeRebootTimer(deps))
+	}
+
+	changedService, err := createRebootService(deps, flags)
+	if err != nil {
+		return maskAny(err)
+	}
+	changedTimer, err := createRebootTimer(deps, flags)
+	if err != nil {
+		return maskAny(err)
+	}
+	isActive, err := deps.Systemd.IsActive(rebootTimerName)
+	if err != nil {
+		return maskAny(err)
+	}
+	if !isActive || changedService || changedTimer || flags.Force {
+		if err := deps.Systemd.Reload(); err != nil {
+			return maskAny(err)
+		}
+		if err := deps.Systemd.Enable(rebootTimerName); err != nil {
+			return maskAny(err)
+		}
+		if err := deps.Systemd.Restart(rebootTimerName); err != nil {
+			return maskAny(err)
+		}
+	}
+	return nil
+}
+
+// Teardown removes the reboot timer & service and the release service.
+// Reboot locks held by this machine are left in ETCD.
+func (t *rebootService) Teardown(deps service.ServiceDependencies, flags *service.ServiceFlags) error {
+	if err := removeRebootTimer(deps); err != nil {
+		return maskAny(err)
+	}
+	if err := deps.Systemd.StopAndRemove(releaseServiceName, releaseServicePath); err != nil {
+		return maskAny(err)
+	}
+	return maskAny(deps.Systemd.Reload())
+}
+
+// removeRebootTimer stops & removes the reboot timer and service.
+func removeRebootTimer(deps service.ServiceDependencies) error {
+	if err := deps.Systemd.StopAndRemove(rebootTimerName, rebootTimerPath); err != nil {
+		return maskAny(err)
+	}
+	return maskAny(deps.Systemd.StopAndRemove(rebootServiceName, rebootServicePath))
+}
+
+func createReleaseService(deps service.ServiceDependencies, flags *service.ServiceFlags) (bool, error) {
+	deps.Logger.Info("creating %s", releaseServicePath)
+	changed, err := templates.Render(deps.Target, releaseServiceTemplate, releaseServicePath, nil, fileMode)
+	return changed, maskAny(err)
+}
+
+func createRebootService(deps service.ServiceDependencies, flags *service.ServiceFlags) (bool, error) {
+	deps.Logger.Info("creating %s", rebootServicePath)
+	opts := struct {
+		MaxConcurrent int
+	}{
+		MaxConcurrent: flags.Reboot.MaxConcurrent,
+	}
+	changed, err := templates.Render(deps.Target, rebootServiceTemplate, rebootServicePath, opts, fileMode)
+	return changed, maskAny(err)
+}
+
+func createRebootTimer(deps service.ServiceDependencies, flags *service.ServiceFlags) (bool, error) {
+	deps.Logger.Info("creating %s", rebootTimerPath)
+	changed, err := templates.Render(deps.Target, rebootTimerTemplate, rebootTimerPath, nil, fileMode)
+	return changed, maskAny(err)
+}
@@ -18,14 +18,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 
 	"github.com/juju/errgo"
 
 	"github.com/pulcy/gluon/service"
 	"github.com/pulcy/gluon/templates"
-	"github.com/pulcy/gluon/util"
 )
 
 var (
@@ -39,6 +37,15 @@ var (
 	metadataServiceName = "rkt-metadata.service"
 	metadataSocketName  = "rkt-metadata.socket"
 
+	serviceNames = []string{
+		apiServiceName,
+		apiSocketName,
+		gcServiceName,
+		gcTimerName,
+		metadataSocketName, // Keep this before metadataServiceName
+		metadataServiceName,
+	}
+
 	networkConfPath     = "/etc/rkt/net.d/10-gluon.conf"
 	networkConfTemplate = "templates/rkt/rkt-net-gluon.conf.tmpl"
 
@@ -60,6 +67,10 @@ func (t *rktService) Name() string {
 	return "rkt"
 }
 
+func (t *rktService) Dependencies() []string {
+	return []string{"binaries"}
+}
+
 func (t *rktService) Setup(deps service.ServiceDependencies, flags *service.ServiceFlags) error {
 	if _, err := createTmpFilesConf(deps, flags); err != nil {
 		return maskAny(err)
@@ -79,14 +90,6 @@ func (t *rktService) Setup(deps service.ServiceDependencies, flags *service.Serv
 		return maskAny(err)
 	}
 
-	serviceNames := []string{
-		apiServiceName,
-		apiSocketName,
-		gcServiceName,
-		gcTimerName,
-		metadataSocketName, // Keep this before metadataServiceName
-		metadataServiceName,
-	}
 	var serviceChanged []bool
 	anyChanged := false
 	for _, serviceName := range serviceNames {
@@ -117,6 +120,23 @@ func (t *rktService) Setup(deps service.ServiceDependencies, flags *service.Serv
 	return nil
 }
 
+// Teardown removes all rkt units and configuration files.
+// The rkt data directory is left alone.
+func (t *rktService) Teardown(deps service.ServiceDependencies, flags *service.ServiceFlags) error {
+	for i := len(serviceNames) - 1; i >= 0; i-- {
+		serviceName := serviceNames[i]
+		if err := deps.Systemd.StopAndRemove(serviceName, servicePath(serviceName)); err != nil {
+			return maskAny(err)
+		}
+	}
+	for _, p := range []string{tmpFilesConfPath, networkConfPath, privateRegistryAuthConfPath} {
+		if err := deps.Target.Remove(p); err != nil {
+			return maskAny(err)
+		}
+	}
+	return maskAny(deps.Systemd.Reload())
+}
+
 func createTmpFilesConf(deps service.ServiceDependencies, flags *service.ServiceFlags) (bool, error) {
 	deps.Logger.Info("creating %s", tmpFilesConfPath)
 	asset, err := templates.Asset(tmpFilesConfSource)
@@ -124,14 +144,13 @@ func createTmpFilesConf(deps service.ServiceDependencies, flags *service.Service
 		return false, maskAny(err)
 	}
 
-	changed, err := util.UpdateFile(deps.Logger, tmpFilesConfPath, asset, 0644)
+	changed, err := deps.Target.UpdateFile(tmpFilesConfPath, asset, 0644)
 	return changed, maskAny(err)
 
 }
 
 func setupDataDir(deps service.ServiceDependencies, flags *service.ServiceFlags) error {
-	cmd := exec.Command("/usr/bin/rkt-scripts/setup-data-dir.sh")
-	out, err := cmd.CombinedOutput()
+	out, err := deps.Target.Run("/usr/bin/rkt-scripts/setup-data-dir.sh")
 	if err != nil {
 		fmt.Println(string(out))
 		return maskAny(err)
@@ -141,8 +160,7 @@ func setupDataDir(deps service.ServiceDependencies, flags *service.ServiceFlags)
 
 func addCoreToRktGroup(deps service.ServiceDependencies, flags *service.ServiceFlags) error {
 	for _, g := range []string{"rkt", "rkt-admin"} {
-		cmd := exec.Command("gpasswd", "-a", "core", g)
-		out, err := cmd.CombinedOutput()
+		out, err := deps.Target.Run("gpasswd", "-a", "core", g)
 		if err != nil {
 			fmt.Println(string(out))
 			return maskAny(err)
@@ -156,7 +174,7 @@ func createService(serviceName string, deps service.ServiceDependencies, flags *
 	servicePath := servicePath(serviceName)
 	deps.Logger.Info("creating %s", servicePath)
 	opts := struct{}{}
-	changed, err := templates.Render(deps.Logger, serviceTemplate, servicePath, opts, serviceFileMode)
+	changed, err := templates.Render(deps.Target, serviceTemplate, servicePath, opts, serviceFileMode)
 	return changed, maskAny(err)
 }
 
@@ -201,12 +219,12 @@ func createPrivateRegistryAuthConf(deps service.ServiceDependencies, flags *serv
 		cf.Credentials.Password = flags.Docker.PrivateRegistryPassword
 
 		// Save
-		os.MkdirAll(filepath.Dir(privateRegistryAuthConfPath), 0700)
+		deps.Target.EnsureDirectory(filepath.Dir(privateRegistryAuthConfPath), 0700)
 		raw, err := json.MarshalIndent(cf, "", "\t")
 		if err != nil {
 			return false, maskAny(err)
 		}
-		changed, err := util.UpdateFile(deps.Logger, privateRegistryAuthConfPath, raw, 0600)
+		changed, err := deps.Target.UpdateFile(privateRegistryAuthConfPath, raw, 0600)
 		return changed, maskAny(err)
 	} else {
 		deps.Logger.Warningf("Skip creating %s", privateRegistryAuthConfPath)
@@ -222,6 +240,6 @@ func createNetwork(deps service.ServiceDependencies, flags *service.ServiceFlags
 	}{
 		RktSubnet: flags.Rkt.RktSubnet,
 	}
-	changed, err := templates.Render(deps.Logger, networkConfTemplate, networkConfPath, opts, configFileMode)
+	changed, err := templates.Render(deps.Target, networkConfTemplate, networkConfPath, opts, configFileMode)
 	return changed, maskAny(err)
 }
@@ -16,11 +16,10 @@ package service
 
 import (
 	"fmt"
-	"io/ioutil"
 	"net"
 	"os"
-	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/op/go-logging"
 
@@ -41,12 +40,18 @@ const (
 
 type Service interface {
 	Name() string
+	// Dependencies returns the names of the services that must be setup before this service.
+	Dependencies() []string
 	Setup(deps ServiceDependencies, flags *ServiceFlags) error
+	// Teardown stops, disables & removes all units, configuration files
+	// and scripts that are created by Setup.
+	Teardown(deps ServiceDependencies, flags *ServiceFlags) error
 }
 
 type ServiceDependencies struct {
-	Systemd *systemd.SystemdClient
+	Systemd systemd.Client
 	Logger  *logging.Logger
+	Target  *util.Target
 }
 
 type ServiceFlags struct {
@@ -56,6 +61,7 @@ type ServiceFlags struct {
 	GluonImage       string
 	VaultMonkeyImage string
 	Roles            []string
+	MemberSource     string // Where to find the cluster members (see NewMemberSource)
 
 	// Docker
 	Docker struct {
@@ -78,6 +84,9 @@ type ServiceFlags struct {
 		ClusterIP            string // IP address of member used for internal cluster traffic (e.g. etcd)
 	}
 
+	// Certificate authority
+	CA CA
+
 	// ETCD
 	Etcd Etcd
 
@@ -90,48 +99,56 @@ type ServiceFlags struct {
 	// Weave
 	Weave Weave
 
-	// private cache
-	clusterMembers []ClusterMember
-}
-
-type discoveryResponse struct {
-	Node   discoveryNode `json:"node"`
-	Action string        `json:"action"`
-}
+	// Reboot coordination
+	Reboot struct {
+		Auto          bool // If set, machines reboot on their own when the OS needs it, taking the reboot locks
+		MaxConcurrent int  // Maximum number of machines with the same role rebooting at the same time (1 for quorum roles)
+	}
 
-type discoveryNode struct {
-	Key   string          `json:"key,omitempty"`
-	Value string          `json:"value,omitempty"`
-	Nodes []discoveryNode `json:"nodes,omitempty"`
+	// private cache
+	clusterMembers *memberCache
+	config         *Config
+	legacyFiles    []string
+	target         *util.Target
 }
 
-type ClusterMember struct {
-	MachineID     string
-	ClusterIP     string // IP address of member used for internal cluster traffic (e.g. etcd)
-	PrivateHostIP string // IP address of member host (can be same as ClusterIP)
-	EtcdProxy     bool
+// memberCache holds the cluster members once they have been loaded.
+// It is safe for concurrent use, since services are setup concurrently.
+type memberCache struct {
+	mutex   sync.Mutex
+	members []ClusterMember
 }
 
-// SetupDefaults fills given flags with default value
-func (flags *ServiceFlags) SetupDefaults(log *logging.Logger) error {
+// SetupDefaults fills given flags with default value.
+// Values that are not set (by commandline flags or environment variables) are
+// taken from the configuration file found on the given target.
+func (flags *ServiceFlags) SetupDefaults(target *util.Target) error {
+	flags.target = target
+	if flags.clusterMembers == nil {
+		flags.clusterMembers = &memberCache{}
+	}
+	cfg, legacyFiles, err := LoadConfig(target)
+	if err != nil {
+		return maskAny(err)
+	}
+	flags.config = cfg
+	flags.legacyFiles = legacyFiles
 	if flags.VaultMonkeyImage == "" {
 		flags.VaultMonkeyImage = defaultVaultMonkeyImage
 	}
 	if flags.Docker.PrivateRegistryUrl == "" {
-		url, err := ioutil.ReadFile(privateRegistryUrlPath)
-		if err != nil && !os.IsNotExist(err) {
-			return maskAny(err)
-		} else if err == nil {
-			flags.Docker.PrivateRegistryUrl = string(url)
-		}
+		flags.Docker.PrivateRegistryUrl = cfg.Docker.PrivateRegistryUrl
 	}
-	if err := flags.Etcd.setupDefaults(log); err != nil {
+	if err := flags.CA.setupDefaults(cfg); err != nil {
 		return maskAny(err)
 	}
-	if err := flags.Kubernetes.setupDefaults(log); err != nil {
+	if err := flags.Etcd.setupDefaults(cfg); err != nil {
 		return maskAny(err)
 	}
-	if err := flags.Vault.setupDefaults(log); err != nil {
+	if err := flags.Kubernetes.setupDefaults(cfg); err != nil {
+		return maskAny(err)
+	}
+	if err := flags.Vault.setupDefaults(cfg); err != nil {
 		return maskAny(err)
 	}
 	if flags.Network.PrivateClusterDevice == "" {
@@ -144,109 +161,127 @@ func (flags *ServiceFlags) SetupDefaults(log *logging.Logger) error {
 		flags.Network.ClusterSubnet = network.String()
 	}
 	if flags.GluonImage == "" {
-		content, err := ioutil.ReadFile(gluonImagePath)
-		if err != nil && !os.IsNotExist(err) {
-			return maskAny(err)
-		} else if err == nil {
-			flags.GluonImage = strings.TrimSpace(string(content))
-		}
+		flags.GluonImage = cfg.GluonImage
 	}
-	if err := flags.Weave.setupDefaults(log, flags); err != nil {
+	if flags.MemberSource == "" {
+		flags.MemberSource = cfg.MemberSource
+	}
+	if err := flags.Weave.setupDefaults(cfg, flags); err != nil {
 		return maskAny(err)
 	}
 
 	// Setup roles last, since it depends on other flags being initialized
 	if len(flags.Roles) == 0 {
-		content, err := ioutil.ReadFile(rolesPath)
-		if err != nil && !os.IsNotExist(err) {
-			return maskAny(err)
-		} else if err == nil {
-			lines := trimLines(strings.Split(string(content), "\n"))
-			roles := strings.Join(lines, ",")
-			flags.Roles = strings.Split(roles, ",")
+		flags.Roles = cfg.Roles
+	}
+	if len(flags.Roles) == 0 && flags.Network.ClusterIP != "" {
+		// Use the roles of this machine in the cluster members list
+		if m, err := flags.ThisMember(target.Logger); err == nil {
+			flags.Roles = m.Roles
 		}
 	}
 	return nil
 }
 
-// Save applicable flags to their respective files
+// Save applicable flags to the configuration file and removes the legacy
+// configuration files that have been imported into it.
 // Returns true if anything has changed, false otherwise
-func (flags *ServiceFlags) Save(log *logging.Logger) (bool, error) {
-	changes := 0
-	if flags.Docker.PrivateRegistryUrl != "" {
-		if changed, err := updateContent(log, privateRegistryUrlPath, flags.Docker.PrivateRegistryUrl, 0644); err != nil {
+func (flags *ServiceFlags) Save(target *util.Target) (bool, error) {
+	cfg := flags.config
+	if cfg == nil {
+		var err error
+		cfg, flags.legacyFiles, err = LoadConfig(target)
+		if err != nil {
 			return false, maskAny(err)
-		} else if changed {
-			changes++
 		}
 	}
-	if changed, err := flags.Etcd.save(log); err != nil {
-		return false, maskAny(err)
-	} else if changed {
-		changes++
+	if flags.GluonImage != "" {
+		cfg.GluonImage = flags.GluonImage
 	}
-	if changed, err := flags.Kubernetes.save(log); err != nil {
-		return false, maskAny(err)
-	} else if changed {
-		changes++
+	if len(flags.Roles) > 0 {
+		cfg.Roles = flags.Roles
 	}
-	if changed, err := flags.Vault.save(log); err != nil {
-		return false, maskAny(err)
-	} else if changed {
-		changes++
+	if flags.MemberSource != "" {
+		cfg.MemberSource = flags.MemberSource
 	}
-	if flags.GluonImage != "" {
-		if changed, err := updateContent(log, gluonImagePath, flags.GluonImage, 0644); err != nil {
-			return false, maskAny(err)
-		} else if changed {
-			changes++
-		}
+	if flags.Docker.PrivateRegistryUrl != "" {
+		cfg.Docker.PrivateRegistryUrl = flags.Docker.PrivateRegistryUrl
 	}
-	if changed, err := flags.Weave.save(log); err != nil {
+	flags.CA.save(cfg)
+	flags.Etcd.save(cfg)
+	flags.Kubernetes.save(cfg)
+	flags.Vault.save(cfg)
+	flags.Weave.save(cfg)
+
+	changed, err := cfg.Save(target)
+	if err != nil {
 		return false, maskAny(err)
-	} else if changed {
-		changes++
 	}
-	if len(flags.Roles) > 0 {
-		content := strings.Join(flags.Roles, "\n")
-		if changed, err := updateContent(log, rolesPath, content, 0644); err != nil {
+	for _, path := range flags.legacyFiles {
+		target.Logger.Info("removing %s, it has been imported into %s", path, ConfigPath)
+		if err := target.Remove(path); err != nil {
 			return false, maskAny(err)
-		} else if changed {
-			changes++
 		}
+		changed = true
 	}
-	return (changes > 0), nil
+	flags.legacyFiles = nil
+	return changed, nil
+}
+
+// Config returns the configuration loaded by SetupDefaults.
+func (flags *ServiceFlags) Config() *Config {
+	return flags.config
 }
 
 // HasRole returns true if the given role is found in flags.Roles.
+// The core role implies all roles of a core cluster member (e.g. etcd).
 func (flags *ServiceFlags) HasRole(role string) bool {
-	for _, x := range flags.Roles {
-		if x == role {
-			return true
-		}
-	}
-	return false
+	return hasRole(flags.Roles, role)
 }
 
 // GetClusterMembers returns a list of the private IP
-// addresses of all the cluster members
+// addresses of all the cluster members, as found in the configured member source.
 func (flags *ServiceFlags) GetClusterMembers(log *logging.Logger) ([]ClusterMember, error) {
-	if flags.clusterMembers != nil {
-		return flags.clusterMembers, nil
+	cache := flags.clusterMembers
+	if cache == nil {
+		// Flags without defaults are not cached
+		cache = &memberCache{}
+	}
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if cache.members != nil {
+		return cache.members, nil
 	}
 
-	members, err := flags.getClusterMembersFromFS(log)
+	target := flags.getTarget(log)
+	source, err := NewMemberSource(flags.MemberSource, target, log)
 	if err != nil {
 		return nil, maskAny(err)
 	}
+	members, err := source.Members()
+	if err != nil {
+		if flags.MemberSource == "" || flags.MemberSource == FileMemberSource {
+			return nil, maskAny(err)
+		}
+		// Fall back to the members in the configuration file, so a machine can
+		// still boot when the source is not yet (or no longer) available.
+		if log != nil {
+			log.Warningf("Cannot get cluster members from %s, using %s: %v", source, ConfigPath, err)
+		}
+		fileSource, _ := NewMemberSource(FileMemberSource, target, log)
+		members, err = fileSource.Members()
+		if err != nil {
+			return nil, maskAny(err)
+		}
+	}
 
-	flags.clusterMembers = members
+	cache.members = members
 	return members, nil
 }
 
 // ReadClusterID reads the cluster ID from /etc/pulcy/cluster-id
 func (flags *ServiceFlags) ReadClusterID() (string, error) {
-	content, err := ioutil.ReadFile(clusterIDPath)
+	content, err := flags.getTarget(nil).ReadFile(clusterIDPath)
 	if err != nil {
 		return "", maskAny(err)
 	}
@@ -255,74 +290,42 @@ func (flags *ServiceFlags) ReadClusterID() (string, error) {
 
 // PrivateHostIP returns the private IPv4 address of the host.
 func (flags *ServiceFlags) PrivateHostIP(log *logging.Logger) (string, error) {
-	members, err := flags.GetClusterMembers(log)
+	m, err := flags.ThisMember(log)
 	if err != nil {
 		return "", maskAny(err)
 	}
-	for _, m := range members {
-		if m.ClusterIP == flags.Network.ClusterIP {
-			return m.PrivateHostIP, nil
-		}
-	}
-	return "", maskAny(fmt.Errorf("No cluster member found for %s", flags.Network.ClusterIP))
+	return m.PrivateHostIP, nil
 }
 
-// getClusterMembersFromFS returns a list of the private IP
-// addresses from a local configuration file
-func (flags *ServiceFlags) getClusterMembersFromFS(log *logging.Logger) ([]ClusterMember, error) {
-	content, err := ioutil.ReadFile(clusterMembersPath)
+// ThisMember returns the cluster member for this machine.
+func (flags *ServiceFlags) ThisMember(log *logging.Logger) (ClusterMember, error) {
+	members, err := flags.GetClusterMembers(log)
 	if err != nil {
-		return nil, maskAny(err)
+		return ClusterMember{}, maskAny(err)
 	}
-	lines := strings.Split(string(content), "\n")
-
-	// Find IP addresses
-	members := []ClusterMember{}
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
-		}
-		parts := strings.SplitN(line, "=", 2)
-		if len(parts) != 2 {
-			continue
-		}
-		id := parts[0]
-		parts = strings.Split(parts[1], " ")
-		clusterIP := parts[0]
-		privateHostIP := clusterIP
-		etcdProxy := false
-		for index, x := range parts {
-			if index == 0 {
-				continue
-			}
-			switch x {
-			case "etcd-proxy":
-				etcdProxy = true
-			default:
-				if strings.HasPrefix(x, privateHostIPPrefix) {
-					privateHostIP = x[len(privateHostIPPrefix):]
-				} else {
-					log.Error("Unknown option '%s' in %s", x, clusterMembersPath)
-				}
-			}
+	for _, m := range members {
+		if m.ClusterIP == flags.Network.ClusterIP {
+			return m, nil
 		}
-
-		members = append(members, ClusterMember{
-			MachineID:     id,
-			ClusterIP:     clusterIP,
-			PrivateHostIP: privateHostIP,
-			EtcdProxy:     etcdProxy,
-		})
 	}
+	return ClusterMember{}, maskAny(fmt.Errorf("No cluster member found for %s", flags.Network.ClusterIP))
+}
 
-	return members, nil
+// getTarget returns the target passed to SetupDefaults, or the local system if
+// SetupDefaults has not been called.
+func (flags *ServiceFlags) getTarget(log *logging.Logger) *util.Target {
+	if flags.target != nil {
+		return flags.target
+	}
+	return util.NewTarget(log, "", nil)
 }
 
-func updateContent(log *logging.Logger, path, content string, fileMode os.FileMode) (bool, error) {
+func updateContent(target *util.Target, path, content string, fileMode os.FileMode) (bool, error) {
 	content = strings.TrimSpace(content)
-	os.MkdirAll(filepath.Dir(path), 0755)
-	changed, err := util.UpdateFile(log, path, []byte(content), fileMode)
+	if err := target.EnsureDirectoryOf(path, 0755); err != nil {
+		return false, maskAny(err)
+	}
+	changed, err := target.UpdateFile(path, []byte(content), fileMode)
 	return changed, maskAny(err)
 }
 
@@ -46,6 +46,10 @@ func (t *sshdService) Name() string {
 	return "sshd"
 }
 
+func (t *sshdService) Dependencies() []string {
+	return []string{"iptables"}
+}
+
 func (t *sshdService) Setup(deps service.ServiceDependencies, flags *service.ServiceFlags) error {
 	changedConfig, err := createSshdConfig(deps, flags)
 	if err != nil {
@@ -61,9 +65,15 @@ func (t *sshdService) Setup(deps service.ServiceDependencies, flags *service.Ser
 	return nil
 }
 
+// Teardown leaves sshd_config in place, since removing it would lock us out of the machine.
+func (t *sshdService) Teardown(deps service.ServiceDependencies, flags *service.ServiceFlags) error {
+	deps.Logger.Warningf("leaving %s in place", confPath)
+	return nil
+}
+
 func createSshdConfig(deps service.ServiceDependencies, flags *service.ServiceFlags) (bool, error) {
 	deps.Logger.Info("creating %s", confPath)
-	os.Remove(confPath)
-	changed, err := templates.Render(deps.Logger, confTemplate, confPath, nil, fileMode)
+	deps.Target.Remove(confPath)
+	changed, err := templates.Render(deps.Target, confTemplate, confPath, nil, fileMode)
 	return changed, maskAny(err)
 }
@@ -0,0 +1,199 @@
+// Copyright (c) 2017 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"os"
+
+	"github.com/pulcy/gluon/systemd"
+	"github.com/pulcy/gluon/util"
+)
+
+type FileState string
+
+const (
+	FileOK        FileState = "ok"
+	FileMissing   FileState = "missing"
+	FileOutdated  FileState = "outdated"
+	FileWrongMode FileState = "wrong-mode"
+	FileObsolete  FileState = "obsolete" // File exists, but setup would remove it
+)
+
+// FileStatus is the state of a single file managed by a service.
+type FileStatus struct {
+	Path  string    `json:"path"`
+	State FileState `json:"state"`
+}
+
+// UnitStatus is the state of a single unit used by a service.
+type UnitStatus struct {
+	Name          string `json:"name"`
+	ExpectEnabled bool   `json:"expect_enabled"`
+	ExpectActive  bool   `json:"expect_active"`
+	systemd.UnitState
+}
+
+// Healthy returns true if the unit is in the state that setup leaves it in.
+func (s UnitStatus) Healthy() bool {
+	if !s.Exists || s.ActiveState == "failed" {
+		return false
+	}
+	if s.ExpectEnabled && !s.IsEnabled() {
+		return false
+	}
+	if s.ExpectActive && !s.Oneshot && s.ActiveState == "inactive" {
+		return false
+	}
+	return true
+}
+
+// ServiceStatus is the state of everything that is managed by a single service.
+type ServiceStatus struct {
+	Name         string       `json:"name"`
+	Files        []FileStatus `json:"files,omitempty"`
+	Units        []UnitStatus `json:"units,omitempty"`
+	Certificates []FileStatus `json:"certificates,omitempty"`
+	Error        string       `json:"error,omitempty"` // Set when the status could not be determined
+}
+
+// Healthy returns true if all files, units & certificates of the service are ok.
+func (s ServiceStatus) Healthy() bool {
+	if s.Error != "" {
+		return false
+	}
+	for _, f := range s.Files {
+		if f.State != FileOK {
+			return false
+		}
+	}
+	for _, u := range s.Units {
+		if !u.Healthy() {
+			return false
+		}
+	}
+	for _, c := range s.Certificates {
+		if c.State != FileOK {
+			return false
+		}
+	}
+	return true
+}
+
+// CertificateReporter is implemented by services that depend on certificate files
+// that are created at runtime (e.g. from vault), rather than by setup itself.
+type CertificateReporter interface {
+	// CertificateFiles returns the paths of all certificate files the service needs with the given flags.
+	CertificateFiles(flags *ServiceFlags) []string
+}
+
+// GetStatus reports the state of all files, units & certificates of the given service.
+// The setup of the service is run against a plan (once normally, once forced) to
+// find out which files it manages and which units it uses, so nothing is changed.
+func GetStatus(s Service, deps ServiceDependencies, flags *ServiceFlags) (ServiceStatus, error) {
+	status := ServiceStatus{Name: s.Name()}
+
+	// Which files are missing or outdated?
+	plan, err := planSetup(s, deps, flags, false)
+	if err != nil {
+		return status, maskAny(err)
+	}
+	fileStates := make(map[string]FileState)
+	for _, a := range plan.Actions() {
+		switch a.Kind {
+		case util.ActionCreateFile:
+			fileStates[a.Target] = FileMissing
+		case util.ActionChangeFile:
+			fileStates[a.Target] = FileOutdated
+		case util.ActionChangeMode:
+			if _, found := fileStates[a.Target]; !found {
+				fileStates[a.Target] = FileWrongMode
+			}
+		case util.ActionRemoveFile:
+			fileStates[a.Target] = FileObsolete
+			status.Files = append(status.Files, FileStatus{Path: a.Target, State: FileObsolete})
+		}
+	}
+	for _, path := range plan.Files() {
+		state, found := fileStates[path]
+		if !found {
+			state = FileOK
+		}
+		status.Files = append(status.Files, FileStatus{Path: path, State: state})
+	}
+
+	// Which units are used?
+	plan, err = planSetup(s, deps, flags, true)
+	if err != nil {
+		return status, maskAny(err)
+	}
+	unitIndex := make(map[string]int)
+	for _, a := range plan.Actions() {
+		switch a.Kind {
+		case util.ActionEnableUnit, util.ActionStartUnit, util.ActionRestartUnit:
+		default:
+			continue
+		}
+		index, found := unitIndex[a.Target]
+		if !found {
+			index = len(status.Units)
+			unitIndex[a.Target] = index
+			status.Units = append(status.Units, UnitStatus{Name: a.Target})
+		}
+		if a.Kind == util.ActionEnableUnit {
+			status.Units[index].ExpectEnabled = true
+		} else if !deps.Target.IsOffline() {
+			status.Units[index].ExpectActive = true
+		}
+	}
+	for i, u := range status.Units {
+		state, err := deps.Systemd.State(u.Name)
+		if err != nil {
+			return status, maskAny(err)
+		}
+		status.Units[i].UnitState = state
+	}
+
+	// Which certificates are present?
+	if cr, ok := s.(CertificateReporter); ok {
+		for _, path := range cr.CertificateFiles(flags) {
+			state := FileOK
+			if _, err := deps.Target.Stat(path); os.IsNotExist(err) {
+				state = FileMissing
+			} else if err != nil {
+				return status, maskAny(err)
+			}
+			status.Certificates = append(status.Certificates, FileStatus{Path: path, State: state})
+		}
+	}
+
+	return status, nil
+}
+
+// planSetup runs the setup of the given service against a new plan and returns that plan.
+func planSetup(s Service, deps ServiceDependencies, flags *ServiceFlags, force bool) (*util.Plan, error) {
+	plan := util.NewPlan()
+	target := util.NewTarget(deps.Logger, deps.Target.Root, plan)
+	planDeps := ServiceDependencies{
+		Systemd: systemd.NewPlanClient(plan, deps.Systemd, target),
+		Logger:  deps.Logger,
+		Target:  target,
+	}
+	planFlags := *flags
+	planFlags.Force = force
+	if err := s.Setup(planDeps, &planFlags); err != nil {
+		return nil, maskAny(err)
+	}
+	return plan, nil
+}
@@ -0,0 +1,167 @@
+// Copyright (c) 2017 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/juju/errgo"
+	"github.com/op/go-logging"
+
+	"github.com/pulcy/gluon/pki"
+)
+
+// ValidationError is returned by Validate and lists all problems found in the flags.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("Invalid configuration:\n- %s", strings.Join(e.Problems, "\n- "))
+}
+
+type namedSubnet struct {
+	Name   string
+	Subnet *net.IPNet
+}
+
+// Validate checks the flags for problems that would otherwise only surface
+// deep inside the setup of a service.
+// All problems are reported in a single ValidationError.
+func (flags *ServiceFlags) Validate(log *logging.Logger) error {
+	var problems []string
+	addProblem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	// Subnets used for containers & services must not overlap
+	var subnets []namedSubnet
+	addSubnet := func(name, cidr string) {
+		if cidr == "" {
+			return
+		}
+		_, subnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			addProblem("%s '%s' is not a valid CIDR", name, cidr)
+			return
+		}
+		subnets = append(subnets, namedSubnet{name, subnet})
+	}
+	addSubnet("docker subnet", flags.Docker.DockerSubnet)
+	addSubnet("rkt subnet", flags.Rkt.RktSubnet)
+	addSubnet("weave ip range", flags.Weave.IPRange)
+	addSubnet("weave rkt subnet", flags.Weave.RktSubnet)
+	if flags.Kubernetes.IsEnabled() {
+		addSubnet("kubernetes service cluster ip range", flags.Kubernetes.ServiceClusterIPRange)
+		if ip, _, err := net.ParseCIDR(flags.Kubernetes.ServiceClusterIPRange); err == nil && ip.To4() == nil {
+			addProblem("kubernetes service cluster ip range '%s' must be an IPv4 range", flags.Kubernetes.ServiceClusterIPRange)
+		}
+	}
+	for i, a := range subnets {
+		for _, b := range subnets[i+1:] {
+			if a.Subnet.Contains(b.Subnet.IP) || b.Subnet.Contains(a.Subnet.IP) {
+				addProblem("%s %s overlaps with %s %s", a.Name, a.Subnet, b.Name, b.Subnet)
+			}
+		}
+	}
+	if _, _, err := net.ParseCIDR(flags.Network.ClusterSubnet); err != nil {
+		addProblem("cluster subnet '%s' is not a valid CIDR", flags.Network.ClusterSubnet)
+	}
+
+	// This machine must be a member of the cluster
+	if net.ParseIP(flags.Network.ClusterIP) == nil {
+		addProblem("private ip '%s' is not a valid IP address", flags.Network.ClusterIP)
+	}
+	members, err := flags.GetClusterMembers(log)
+	if err != nil {
+		addProblem("cannot load cluster members: %v", errgo.Cause(err))
+	} else {
+		found := false
+		quorum := 0
+		for _, m := range members {
+			if m.ClusterIP == flags.Network.ClusterIP {
+				found = true
+			}
+			if m.HasRole(RoleEtcd) {
+				quorum++
+			}
+			// The cluster subnet is derived from the class of the private ip, so it is
+			// usually much larger than the actual network. Checking the member addresses
+			// instead avoids complaining about container subnets that are routed fine.
+			for i, ip := range []string{m.ClusterIP, m.PrivateHostIP} {
+				if i > 0 && ip == m.ClusterIP {
+					continue
+				}
+				for _, s := range subnets {
+					if s.Subnet.Contains(net.ParseIP(ip)) {
+						addProblem("%s %s contains address %s of cluster member %s", s.Name, s.Subnet, ip, m.MachineID)
+					}
+				}
+			}
+		}
+		if !found {
+			addProblem("private ip %s is not found in the cluster members", flags.Network.ClusterIP)
+		}
+		if quorum == 0 {
+			addProblem("etcd quorum is empty, all cluster members are etcd proxies")
+		}
+	}
+
+	// Maintenance uses the v3 API of ETCD
+	if flags.Etcd.Version != 3 {
+		if flags.Etcd.Maintenance.Enabled {
+			addProblem("etcd maintenance requires etcd version 3, got version %d", flags.Etcd.Version)
+		}
+		if flags.Etcd.Maintenance.QuotaBackendBytes > 0 {
+			addProblem("etcd quota-backend-bytes requires etcd version 3, got version %d", flags.Etcd.Version)
+		}
+	}
+
+	// The local CA must be installed on every machine
+	if flags.CA.IsLocal() && flags.target != nil {
+		paths := []string{pki.CACertPath, pki.CAKeyPath}
+		if flags.Kubernetes.IsEnabled() {
+			paths = append(paths, pki.ServiceAccountsKeyPath)
+		}
+		for _, p := range paths {
+			if _, err := flags.target.Stat(p); err != nil {
+				addProblem("%s not found, run 'gluon ca init' once and copy %s to all machines", p, pki.CADir)
+			}
+		}
+	}
+
+	// Roles must be known
+	for _, role := range flags.Roles {
+		if !isKnownRole(role) {
+			addProblem("unknown role '%s', expected one of %s", role, strings.Join(KnownRoles, ", "))
+		}
+	}
+
+	if len(problems) > 0 {
+		return maskAny(&ValidationError{Problems: problems})
+	}
+	return nil
+}
+
+func isKnownRole(role string) bool {
+	for _, x := range KnownRoles {
+		if x == role {
+			return true
+		}
+	}
+	return false
+}
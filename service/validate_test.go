@@ -0,0 +1,51 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/juju/errgo"
+)
+
+func validFlags() *ServiceFlags {
+	flags := &ServiceFlags{}
+	flags.Docker.DockerSubnet = "172.17.0.0/16"
+	flags.Rkt.RktSubnet = "172.22.0.0/16"
+	flags.Weave.IPRange = defaultWeaveIPRange
+	flags.Weave.RktSubnet = defaultWeaveRktSubnet
+	flags.Kubernetes.Enabled = true
+	flags.Kubernetes.ServiceClusterIPRange = defaultServiceClusterIPRange
+	flags.Network.ClusterIP = "192.168.0.1"
+	flags.Network.ClusterSubnet = "192.168.0.0/24"
+	flags.Roles = []string{RoleCore}
+	flags.clusterMembers = &memberCache{members: []ClusterMember{
+		{MachineID: "m1", ClusterIP: "192.168.0.1", PrivateHostIP: "192.168.0.1"},
+		{MachineID: "m2", ClusterIP: "192.168.0.2", PrivateHostIP: "192.168.0.2", EtcdProxy: true},
+	}}
+	return flags
+}
+
+func TestValidateValid(t *testing.T) {
+	if err := validFlags().Validate(nil); err != nil {
+		t.Errorf("Expected valid flags, got %v", err)
+	}
+}
+
+func TestValidateReportsAllProblems(t *testing.T) {
+	flags := validFlags()
+	flags.Rkt.RktSubnet = "172.17.128.0/17"
+	flags.Kubernetes.ServiceClusterIPRange = "fd00::/64"
+	flags.Network.ClusterIP = "192.168.0.3"
+	flags.clusterMembers.members[0].EtcdProxy = true
+	flags.Roles = append(flags.Roles, "dancer")
+	flags.Etcd.Version = 2
+	flags.Etcd.Maintenance.Enabled = true
+
+	err := flags.Validate(nil)
+	verr, ok := errgo.Cause(err).(*ValidationError)
+	if !ok {
+		t.Fatalf("Expected ValidationError, got %v", err)
+	}
+	if len(verr.Problems) != 6 {
+		t.Errorf("Expected 6 problems, got %d: %v", len(verr.Problems), verr)
+	}
+}
@@ -14,8 +14,6 @@
 
 package service
 
-import "github.com/op/go-logging"
-
 // Vault config
 type Vault struct {
 	VaultImage string
@@ -26,16 +24,13 @@ const (
 )
 
 // setupDefaults fills given flags with default value
-func (flags *Vault) setupDefaults(log *logging.Logger) error {
+func (flags *Vault) setupDefaults(cfg *Config) error {
 	if flags.VaultImage == "" {
 		flags.VaultImage = defaultVaultImage
 	}
 	return nil
 }
 
-// save applicable flags to their respective files
-// Returns true if anything has changed, false otherwise
-func (flags *Vault) save(log *logging.Logger) (bool, error) {
-	changes := 0
-	return (changes > 0), nil
+// save applicable flags to the given configuration
+func (flags *Vault) save(cfg *Config) {
 }
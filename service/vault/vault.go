@@ -43,8 +43,12 @@ func (t *vaultService) Name() string {
 	return "vault"
 }
 
+func (t *vaultService) Dependencies() []string {
+	return []string{"docker", "consul"}
+}
+
 func (t *vaultService) Setup(deps service.ServiceDependencies, flags *service.ServiceFlags) error {
-	if flags.HasRole("vault") {
+	if flags.HasRole(service.RoleVault) {
 		// Setup a vault server
 		changed, err := createService(deps, flags)
 		if err != nil {
@@ -64,20 +68,21 @@ func (t *vaultService) Setup(deps service.ServiceDependencies, flags *service.Se
 		}
 	} else {
 		// Do not setup a vault server, remove if found
-		if exists, err := deps.Systemd.Exists(vaultServiceName); err != nil {
+		if err := t.Teardown(deps, flags); err != nil {
 			return maskAny(err)
-		} else if exists {
-			if err := deps.Systemd.Disable(vaultServiceName); err != nil {
-				deps.Logger.Errorf("Disabling %s failed: %#v", vaultServiceName, err)
-			} else {
-				os.Remove(vaultServicePath)
-			}
 		}
 	}
 
 	return nil
 }
 
+func (t *vaultService) Teardown(deps service.ServiceDependencies, flags *service.ServiceFlags) error {
+	if err := deps.Systemd.StopAndRemove(vaultServiceName, vaultServicePath); err != nil {
+		return maskAny(err)
+	}
+	return maskAny(deps.Systemd.Reload())
+}
+
 func createService(deps service.ServiceDependencies, flags *service.ServiceFlags) (bool, error) {
 	deps.Logger.Info("creating %s", vaultServicePath)
 	members, err := flags.GetClusterMembers(deps.Logger)
@@ -101,6 +106,6 @@ func createService(deps service.ServiceDependencies, flags *service.ServiceFlags
 		PrivateIP:  privateIP,
 		VaultImage: flags.Vault.VaultImage,
 	}
-	changed, err := templates.Render(deps.Logger, vaultServiceTmpl, vaultServicePath, opts, serviceFileMode)
+	changed, err := templates.Render(deps.Target, vaultServiceTmpl, vaultServicePath, opts, serviceFileMode)
 	return changed, maskAny(err)
 }
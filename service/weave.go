@@ -15,12 +15,8 @@
 package service
 
 import (
-	"io/ioutil"
-	"os"
 	"strings"
 
-	"github.com/op/go-logging"
-
 	"github.com/pulcy/gluon/util"
 )
 
@@ -44,21 +40,18 @@ type Weave struct {
 }
 
 // setupDefaults fills given flags with default value
-func (flags *Weave) setupDefaults(log *logging.Logger, serviceFlags *ServiceFlags) error {
+func (flags *Weave) setupDefaults(cfg *Config, serviceFlags *ServiceFlags) error {
 	if flags.Seed == "" {
-		seed, err := ioutil.ReadFile(weaveSeedPath)
-		if err != nil && !os.IsNotExist(err) {
-			return maskAny(err)
-		} else if err == nil {
-			flags.Seed = string(seed)
+		if cfg.Weave.Seed != "" {
+			flags.Seed = cfg.Weave.Seed
 		} else {
-			members, err := serviceFlags.GetClusterMembers(log)
+			members, err := serviceFlags.GetClusterMembers(serviceFlags.target.Logger)
 			if err != nil {
 				return maskAny(err)
 			}
 			var seeds []string
 			for _, m := range members {
-				if !m.EtcdProxy {
+				if m.HasRole(RoleWeaveSeed) {
 					name, err := util.WeaveNameFromMachineID(m.MachineID)
 					if err != nil {
 						return maskAny(err)
@@ -70,22 +63,14 @@ func (flags *Weave) setupDefaults(log *logging.Logger, serviceFlags *ServiceFlag
 		}
 	}
 	if flags.IPRange == "" {
-		content, err := ioutil.ReadFile(weaveIPRangePath)
-		if err != nil && !os.IsNotExist(err) {
-			return maskAny(err)
-		} else if err == nil {
-			flags.IPRange = strings.TrimSpace(string(content))
+		if cfg.Weave.IPRange != "" {
+			flags.IPRange = cfg.Weave.IPRange
 		} else {
 			flags.IPRange = defaultWeaveIPRange
 		}
 	}
 	if flags.IPInit == "" {
-		content, err := ioutil.ReadFile(weaveIPInitPath)
-		if err != nil && !os.IsNotExist(err) {
-			return maskAny(err)
-		} else if err == nil {
-			flags.IPInit = strings.TrimSpace(string(content))
-		}
+		flags.IPInit = cfg.Weave.IPInit
 	}
 	if flags.RktSubnet == "" {
 		flags.RktSubnet = defaultWeaveRktSubnet
@@ -96,23 +81,15 @@ func (flags *Weave) setupDefaults(log *logging.Logger, serviceFlags *ServiceFlag
 	return nil
 }
 
-// Save applicable flags to their respective files
-// Returns true if anything has changed, false otherwise
-func (flags *Weave) save(log *logging.Logger) (bool, error) {
-	changes := 0
+// save applicable flags to the given configuration
+func (flags *Weave) save(cfg *Config) {
 	if flags.Seed != "" {
-		if changed, err := updateContent(log, weaveSeedPath, flags.Seed, 0644); err != nil {
-			return false, maskAny(err)
-		} else if changed {
-			changes++
-		}
+		cfg.Weave.Seed = flags.Seed
 	}
 	if flags.IPRange != "" {
-		if changed, err := updateContent(log, weaveIPRangePath, flags.IPRange, 0644); err != nil {
-			return false, maskAny(err)
-		} else if changed {
-			changes++
-		}
+		cfg.Weave.IPRange = flags.IPRange
+	}
+	if flags.IPInit != "" {
+		cfg.Weave.IPInit = flags.IPInit
 	}
-	return (changes > 0), nil
 }
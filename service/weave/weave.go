@@ -16,7 +16,6 @@ package weave
 
 import (
 	"os"
-	"os/exec"
 	"strings"
 
 	"github.com/juju/errgo"
@@ -52,8 +51,12 @@ func (t *weaveService) Name() string {
 	return "weave"
 }
 
+func (t *weaveService) Dependencies() []string {
+	return []string{"docker", "iptables", "rkt"}
+}
+
 func (t *weaveService) Setup(deps service.ServiceDependencies, flags *service.ServiceFlags) error {
-	os.MkdirAll(cniPluginDir, 0755)
+	deps.Target.EnsureDirectory(cniPluginDir, 0755)
 	changed, err := createService(deps, flags)
 	if err != nil {
 		return maskAny(err)
@@ -83,6 +86,18 @@ func (t *weaveService) Setup(deps service.ServiceDependencies, flags *service.Se
 	return nil
 }
 
+func (t *weaveService) Teardown(deps service.ServiceDependencies, flags *service.ServiceFlags) error {
+	if err := deps.Systemd.StopAndRemove(weaveServiceName, weaveServicePath); err != nil {
+		return maskAny(err)
+	}
+	for _, p := range []string{cniConfPath, rktNetworkConfPath} {
+		if err := deps.Target.Remove(p); err != nil {
+			return maskAny(err)
+		}
+	}
+	return maskAny(deps.Systemd.Reload())
+}
+
 func createService(deps service.ServiceDependencies, flags *service.ServiceFlags) (bool, error) {
 	deps.Logger.Info("creating %s", weaveServicePath)
 	members, err := flags.GetClusterMembers(deps.Logger)
@@ -112,20 +127,19 @@ func createService(deps service.ServiceDependencies, flags *service.ServiceFlags
 		IPRange:  flags.Weave.IPRange,
 		IPInit:   "seed=${SEED}",
 	}
-	changed, err := templates.Render(deps.Logger, weaveServiceTmpl, weaveServicePath, opts, serviceFileMode)
+	changed, err := templates.Render(deps.Target, weaveServiceTmpl, weaveServicePath, opts, serviceFileMode)
 	return changed, maskAny(err)
 }
 
 func createCniConf(deps service.ServiceDependencies, flags *service.ServiceFlags) (bool, error) {
 	deps.Logger.Info("creating %s", cniConfPath)
-	changed, err := templates.Render(deps.Logger, cniConfTmpl, cniConfPath, nil, configFileMode)
+	changed, err := templates.Render(deps.Target, cniConfTmpl, cniConfPath, nil, configFileMode)
 	return changed, maskAny(err)
 }
 
 func setupCni(deps service.ServiceDependencies, flags *service.ServiceFlags) error {
 	deps.Logger.Info("running weave setup-cni")
-	cmd := exec.Command("weave", "setup-cni")
-	if out, err := cmd.CombinedOutput(); err != nil {
+	if out, err := deps.Target.Run("weave", "setup-cni"); err != nil {
 		deps.Logger.Error(string(out))
 		return maskAny(err)
 	}
@@ -133,7 +147,7 @@ func setupCni(deps service.ServiceDependencies, flags *service.ServiceFlags) err
 }
 
 func createRktNetwork(deps service.ServiceDependencies, flags *service.ServiceFlags) (bool, error) {
-	if err := util.EnsureDirectoryOf(rktNetworkConfPath, 0755); err != nil {
+	if err := deps.Target.EnsureDirectoryOf(rktNetworkConfPath, 0755); err != nil {
 		return false, maskAny(err)
 	}
 	deps.Logger.Info("creating %s", rktNetworkConfPath)
@@ -144,7 +158,7 @@ func createRktNetwork(deps service.ServiceDependencies, flags *service.ServiceFl
 		Subnet:  flags.Weave.RktSubnet,
 		Gateway: flags.Weave.RktGateway,
 	}
-	changed, err := templates.Render(deps.Logger, rktNetworkConfTemplate, rktNetworkConfPath, opts, configFileMode)
+	changed, err := templates.Render(deps.Target, rktNetworkConfTemplate, rktNetworkConfPath, opts, configFileMode)
 	return changed, maskAny(err)
 }
 
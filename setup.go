@@ -15,6 +15,9 @@
 package main
 
 import (
+	"os"
+
+	"github.com/juju/errgo"
 	"github.com/spf13/cobra"
 
 	"github.com/pulcy/gluon/service"
@@ -27,11 +30,13 @@ import (
 	"github.com/pulcy/gluon/service/iptables"
 	"github.com/pulcy/gluon/service/journal"
 	"github.com/pulcy/gluon/service/kubernetes"
+	rebootservice "github.com/pulcy/gluon/service/reboot"
 	"github.com/pulcy/gluon/service/rkt"
 	"github.com/pulcy/gluon/service/sshd"
 	"github.com/pulcy/gluon/service/vault"
 	"github.com/pulcy/gluon/service/weave"
 	"github.com/pulcy/gluon/systemd"
+	"github.com/pulcy/gluon/util"
 )
 
 const (
@@ -40,6 +45,8 @@ const (
 	defaultPrivateClusterDevice = "eth1"
 
 	defaultWeaveHostname = "hosts.weave.local"
+
+	defaultSetupConcurrency = 4
 )
 
 var (
@@ -47,47 +54,80 @@ var (
 		Use: "setup",
 		Run: runSetup,
 	}
-	setupFlags = &service.ServiceFlags{}
+	setupFlags   = &service.ServiceFlags{}
+	setupOptions struct {
+		DryRun         bool
+		Root           string
+		Concurrency    int
+		Only           []string
+		Skip           []string
+		SkipValidation bool
+	}
 )
 
 func init() {
 	LoadEnv()
 	cmdSetup.Flags().BoolVar(&setupFlags.Force, "force", false, "Restart services, even if nothing has changed")
+	cmdSetup.Flags().BoolVar(&setupOptions.DryRun, "dry-run", false, "Show the changes setup would make, without making them")
+	cmdSetup.Flags().StringVar(&setupOptions.Root, "root", "", "Setup the system found in this directory (e.g. a mounted disk image) instead of the running system")
+	cmdSetup.Flags().IntVar(&setupOptions.Concurrency, "concurrency", defaultSetupConcurrency, "Maximum number of services that are setup at the same time")
+	cmdSetup.Flags().StringSliceVar(&setupOptions.Only, "only", nil, "Setup only these services (comma separated)")
+	cmdSetup.Flags().StringSliceVar(&setupOptions.Skip, "skip", nil, "Do not setup these services (comma separated)")
+	cmdSetup.Flags().BoolVar(&setupOptions.SkipValidation, "skip-validation", false, "Setup services, even if the configuration is invalid")
+	addServiceFlags(cmdSetup, setupFlags)
+
+	cmdMain.AddCommand(cmdSetup)
+}
+
+// addServiceFlags adds all flags used to configure services to the given command.
+func addServiceFlags(cmd *cobra.Command, flags *service.ServiceFlags) {
+	f := cmd.Flags()
 	// Gluon
-	cmdSetup.Flags().StringVar(&setupFlags.GluonImage, "gluon-image", "", "Gluon docker image name")
-	cmdSetup.Flags().StringVar(&setupFlags.VaultMonkeyImage, "vault-monkey-image", "", "VaultMonkey docker image name")
+	f.StringVar(&flags.GluonImage, "gluon-image", "", "Gluon docker image name")
+	f.StringVar(&flags.VaultMonkeyImage, "vault-monkey-image", "", "VaultMonkey docker image name")
+	f.StringVar(&flags.MemberSource, "member-source", "", "Source of cluster members: file, etcd://<host>:<port>[/<prefix>] or consul://<host>:<port>")
 	// Docker
-	cmdSetup.Flags().StringVar(&setupFlags.Docker.DockerIP, "docker-ip", "", "IP address docker binds ports to")
-	cmdSetup.Flags().StringVar(&setupFlags.Docker.DockerSubnet, "docker-subnet", defaultDockerSubnet, "Subnet used by docker")
-	cmdSetup.Flags().StringVar(&setupFlags.Docker.PrivateRegistryUrl, "private-registry-url", "", "URL of private docker registry")
-	cmdSetup.Flags().StringVar(&setupFlags.Docker.PrivateRegistryUserName, "private-registry-username", "", "Username for private registry")
-	cmdSetup.Flags().StringVar(&setupFlags.Docker.PrivateRegistryPassword, "private-registry-password", "", "Password for private registry")
+	f.StringVar(&flags.Docker.DockerIP, "docker-ip", "", "IP address docker binds ports to")
+	f.StringVar(&flags.Docker.DockerSubnet, "docker-subnet", defaultDockerSubnet, "Subnet used by docker")
+	f.StringVar(&flags.Docker.PrivateRegistryUrl, "private-registry-url", "", "URL of private docker registry")
+	f.StringVar(&flags.Docker.PrivateRegistryUserName, "private-registry-username", "", "Username for private registry")
+	f.StringVar(&flags.Docker.PrivateRegistryPassword, "private-registry-password", "", "Password for private registry")
 	// Rkt
-	cmdSetup.Flags().StringVar(&setupFlags.Rkt.RktSubnet, "rkt-subnet", defaultRktSubnet, "Subnet used by rkt")
+	f.StringVar(&flags.Rkt.RktSubnet, "rkt-subnet", defaultRktSubnet, "Subnet used by rkt")
 	// Network
-	cmdSetup.Flags().StringVar(&setupFlags.Network.ClusterIP, "private-ip", "", "IP address of this host in the cluster network")
-	cmdSetup.Flags().StringVar(&setupFlags.Network.PrivateClusterDevice, "private-cluster-device", defaultPrivateClusterDevice, "Network device connected to the cluster IP")
+	f.StringVar(&flags.Network.ClusterIP, "private-ip", "", "IP address of this host in the cluster network")
+	f.StringVar(&flags.Network.PrivateClusterDevice, "private-cluster-device", defaultPrivateClusterDevice, "Network device connected to the cluster IP")
+	// CA
+	f.StringVar(&flags.CA.Mode, "ca-mode", defaultCAMode(), "Where TLS certificates are issued: vault|local (default vault)")
 	// ETCD
-	cmdSetup.Flags().StringVar(&setupFlags.Etcd.ClusterState, "etcd-cluster-state", "", "State of the ETCD cluster new|existing")
-	cmdSetup.Flags().BoolVar(&setupFlags.Etcd.UseVaultCA, "etcd-use-vault-ca", defaultEtcdUseVaultCA(), "If set, use vault to create peer (and optional client) TLS certificates")
-	cmdSetup.Flags().BoolVar(&setupFlags.Etcd.SecureClients, "etcd-secure-clients", defaultEtcdSecureClients(), "If set, force clients to connect over TLS")
+	f.IntVar(&flags.Etcd.Version, "etcd-version", 0, "Major version of ETCD 2|3 (default 2)")
+	f.StringVar(&flags.Etcd.ClusterState, "etcd-cluster-state", "", "State of the ETCD cluster new|existing")
+	f.BoolVar(&flags.Etcd.UseVaultCA, "etcd-use-vault-ca", defaultEtcdUseVaultCA(), "If set, use vault to create peer (and optional client) TLS certificates (--ca-mode=local always creates them)")
+	f.BoolVar(&flags.Etcd.SecureClients, "etcd-secure-clients", defaultEtcdSecureClients(), "If set, force clients to connect over TLS")
 	// Kubernetes
-	cmdSetup.Flags().BoolVar(&setupFlags.Kubernetes.Enabled, "k8s-enabled", defaultKubernetesEnabled(), "If set, kubernetes will be installed")
-	cmdSetup.Flags().StringVar(&setupFlags.Kubernetes.APIDNSName, "k8s-api-dns-name", defaultKubernetesAPIDNSName(), "Alternate name of the Kubernetes API server")
-	cmdSetup.Flags().StringVar(&setupFlags.Kubernetes.Metadata, "k8s-metadata", "", "Metadata list for kubelet")
+	f.BoolVar(&flags.Kubernetes.Enabled, "k8s-enabled", defaultKubernetesEnabled(), "If set, kubernetes will be installed")
+	f.StringVar(&flags.Kubernetes.APIDNSName, "k8s-api-dns-name", defaultKubernetesAPIDNSName(), "Alternate name of the Kubernetes API server")
+	f.StringVar(&flags.Kubernetes.Metadata, "k8s-metadata", "", "Metadata list for kubelet")
 	// Vault
-	cmdSetup.Flags().StringVar(&setupFlags.Vault.VaultImage, "vault-image", "", "Pulcy Vault docker image name")
+	f.StringVar(&flags.Vault.VaultImage, "vault-image", "", "Pulcy Vault docker image name")
+	// Reboot
+	f.BoolVar(&flags.Reboot.Auto, "auto-reboot", defaultAutoReboot(), "If set, this machine reboots on its own when the OS needs it, taking the reboot locks")
+	f.IntVar(&flags.Reboot.MaxConcurrent, "reboot-max-concurrent", 1, "Maximum number of machines with the same role rebooting at the same time (1 for etcd, consul-server, weave-seed, k8s-api & vault)")
 	// Weave
-	cmdSetup.Flags().StringVar(&setupFlags.Weave.Seed, "weave-seed", "", "SEED of the weave network")
-	cmdSetup.Flags().StringVar(&setupFlags.Weave.Hostname, "weave-hostname", defaultWeaveHostname, "DNS name for exposed host")
-
-	cmdMain.AddCommand(cmdSetup)
+	f.StringVar(&flags.Weave.Seed, "weave-seed", "", "SEED of the weave network")
+	f.StringVar(&flags.Weave.Hostname, "weave-hostname", defaultWeaveHostname, "DNS name for exposed host")
 }
 
 func runSetup(cmd *cobra.Command, args []string) {
 	showVersion(cmd, args)
 
-	if err := setupFlags.SetupDefaults(log); err != nil {
+	var plan *util.Plan
+	if setupOptions.DryRun {
+		plan = util.NewPlan()
+	}
+	target := util.NewTarget(log, setupOptions.Root, plan)
+
+	if err := setupFlags.SetupDefaults(target); err != nil {
 		Exitf("SetupDefaults failed: %#v\n", err)
 	}
 
@@ -96,14 +136,105 @@ func runSetup(cmd *cobra.Command, args []string) {
 	assertArgIsSet(setupFlags.Docker.DockerSubnet, "--docker-subnet")
 	assertArgIsSet(setupFlags.Network.ClusterIP, "--private-ip")
 	assertArgIsSet(setupFlags.Network.PrivateClusterDevice, "--private-cluster-device")
+	if !setupOptions.SkipValidation {
+		if err := setupFlags.Validate(log); err != nil {
+			Exitf("%s\n", errgo.Cause(err))
+		}
+	}
 
+	sdc := newSystemdClient(target)
+	if plan != nil {
+		sdc = systemd.NewPlanClient(plan, sdc, target)
+	}
 	deps := service.ServiceDependencies{
-		Systemd: systemd.NewSystemdClient(log),
+		Systemd: sdc,
 		Logger:  log,
+		Target:  target,
 	}
 
-	services := []service.Service{
-		// The order of entries is relevant!
+	// Services are setup concurrently, so load the (cached) cluster members up front.
+	// Services that need them will fail on their own when that is not possible.
+	if _, err := setupFlags.GetClusterMembers(log); err != nil {
+		log.Warningf("Cannot load cluster members: %v", err)
+	}
+
+	services, err := service.Order(allServices())
+	if err != nil {
+		Exitf("Cannot order services: %v\n", err)
+	}
+	selected, err := selectSetupServices(services, setupOptions.Only, setupOptions.Skip)
+	if err != nil {
+		Exitf("%s\n", err.Error())
+	}
+	concurrency := setupOptions.Concurrency
+	if plan != nil {
+		// Keep the plan in a predictable order
+		concurrency = 1
+	}
+	err = service.Run(services, concurrency, func(t service.Service) error {
+		if !selected[t.Name()] {
+			log.Debugf("Skip %s", t.Name())
+			return nil
+		}
+		log.Info("Setup %s", t.Name())
+		if err := t.Setup(deps, setupFlags); err != nil {
+			log.Errorf("Setup %s failed: %#v", t.Name(), err)
+			return maskAny(err)
+		}
+		return nil
+	})
+	if err != nil {
+		sdc.Close()
+		Exitf("Setup failed\n")
+	}
+	if err := sdc.Close(); err != nil {
+		Exitf("Closing systemd client failed: %#v\n", err)
+	}
+	if plan != nil {
+		plan.Print(os.Stdout)
+	}
+	log.Info("Done")
+}
+
+// selectSetupServices returns the names of the services that are selected by the given
+// --only & --skip options. A warning is logged for every dependency of a selected service
+// that is not selected itself.
+func selectSetupServices(services []service.Service, only, skip []string) (map[string]bool, error) {
+	onlyServices, err := selectServices(only)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	skipServices, err := selectServices(skip)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	if len(onlyServices) == 0 {
+		onlyServices = services
+	}
+	selected := make(map[string]bool)
+	for _, s := range onlyServices {
+		selected[s.Name()] = true
+	}
+	for _, s := range skipServices {
+		delete(selected, s.Name())
+	}
+	for _, s := range services {
+		if !selected[s.Name()] {
+			continue
+		}
+		for _, d := range s.Dependencies() {
+			if d != service.AllServices && !selected[d] {
+				log.Warningf("%s depends on %s, which is not selected", s.Name(), d)
+			}
+		}
+	}
+	return selected, nil
+}
+
+// allServices returns all services.
+// The order in which they are setup follows from their dependencies.
+func allServices() []service.Service {
+	return []service.Service{
 		binaries.NewService(),
 		env.NewService(),
 		iptables.NewService(),
@@ -116,13 +247,15 @@ func runSetup(cmd *cobra.Command, args []string) {
 		etcd.NewService(),
 		kubernetes.NewService(),
 		sshd.NewService(),
+		rebootservice.NewService(),
 		gluon.NewService(),
 	}
-	for i, t := range services {
-		log.Info("%d/%d Setup %s", i+1, len(services), t.Name())
-		if err := t.Setup(deps, setupFlags); err != nil {
-			Exitf("Setup %s failed: %#v\n", t.Name(), err)
-		}
+}
+
+// newSystemdClient creates a systemd client for the given target.
+func newSystemdClient(target *util.Target) systemd.Client {
+	if target.IsOffline() {
+		return systemd.NewOfflineClient(log, target.Root)
 	}
-	log.Info("Done")
+	return systemd.NewSystemdClient(log)
 }
@@ -0,0 +1,155 @@
+// Copyright (c) 2017 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/juju/errgo"
+	logging "github.com/op/go-logging"
+	"github.com/spf13/cobra"
+
+	"github.com/pulcy/gluon/service"
+	"github.com/pulcy/gluon/util"
+)
+
+var (
+	cmdStatus = &cobra.Command{
+		Use:   "status",
+		Short: "Show the state of all files & units managed by gluon on this node",
+		Run:   runStatus,
+	}
+	statusFlags   = &service.ServiceFlags{}
+	statusOptions struct {
+		JSON    bool
+		Verbose bool
+		Root    string
+	}
+)
+
+func init() {
+	cmdStatus.Flags().BoolVar(&statusOptions.JSON, "json", false, "Print status as JSON")
+	cmdStatus.Flags().BoolVarP(&statusOptions.Verbose, "verbose", "v", false, "Show all files & units, not only the ones that need attention")
+	cmdStatus.Flags().StringVar(&statusOptions.Root, "root", "", "Inspect the system found in this directory (e.g. a mounted disk image) instead of the running system")
+	addServiceFlags(cmdStatus, statusFlags)
+
+	cmdMain.AddCommand(cmdStatus)
+}
+
+func runStatus(cmd *cobra.Command, args []string) {
+	// Setup logs what it would do, which is just noise here
+	logging.SetLevel(logging.ERROR, cmdMain.Use)
+
+	target := util.NewTarget(log, statusOptions.Root, nil)
+	if statusFlags.Network.ClusterIP == "" {
+		statusFlags.Network.ClusterIP = defaultPrivateIPv4(target)
+	}
+	if statusFlags.Docker.DockerIP == "" {
+		statusFlags.Docker.DockerIP = statusFlags.Network.ClusterIP
+	}
+	if err := statusFlags.SetupDefaults(target); err != nil {
+		Exitf("SetupDefaults failed: %#v\n", err)
+	}
+	assertArgIsSet(statusFlags.Network.ClusterIP, "--private-ip")
+
+	sdc := newSystemdClient(target)
+	defer sdc.Close()
+	deps := service.ServiceDependencies{
+		Systemd: sdc,
+		Logger:  log,
+		Target:  target,
+	}
+
+	statuses, healthy, err := collectStatus(deps, statusFlags)
+	if err != nil {
+		Exitf("%v\n", err)
+	}
+
+	if statusOptions.JSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(statuses); err != nil {
+			Exitf("Failed to encode status: %#v\n", err)
+		}
+	} else {
+		printStatusTable(os.Stdout, statuses, statusOptions.Verbose)
+	}
+	if !healthy {
+		sdc.Close()
+		os.Exit(1)
+	}
+}
+
+// collectStatus returns the status of all services and true if all of them are healthy.
+func collectStatus(deps service.ServiceDependencies, flags *service.ServiceFlags) ([]service.ServiceStatus, bool, error) {
+	services, err := service.Order(allServices())
+	if err != nil {
+		return nil, false, maskAny(fmt.Errorf("Cannot order services: %v", err))
+	}
+	var statuses []service.ServiceStatus
+	healthy := true
+	for _, s := range services {
+		status, err := service.GetStatus(s, deps, flags)
+		if err != nil {
+			log.Debugf("Status of %s failed: %#v", s.Name(), err)
+			status.Error = errgo.Cause(err).Error()
+		}
+		statuses = append(statuses, status)
+		healthy = healthy && status.Healthy()
+	}
+	return statuses, healthy, nil
+}
+
+// printStatusTable writes a table of the given statuses to the given writer.
+// Unless verbose is set, only items that need attention are shown.
+func printStatusTable(w io.Writer, statuses []service.ServiceStatus, verbose bool) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SERVICE\tKIND\tNAME\tSTATE")
+	for _, s := range statuses {
+		if s.Error != "" {
+			fmt.Fprintf(tw, "%s\terror\t\t%s\n", s.Name, s.Error)
+		} else if s.Healthy() {
+			fmt.Fprintf(tw, "%s\t\t\t%s\n", s.Name, "ok")
+		}
+		for _, f := range s.Files {
+			if verbose || f.State != service.FileOK {
+				fmt.Fprintf(tw, "%s\tfile\t%s\t%s\n", s.Name, f.Path, f.State)
+			}
+		}
+		for _, u := range s.Units {
+			if verbose || !u.Healthy() {
+				fmt.Fprintf(tw, "%s\tunit\t%s\t%s\n", s.Name, u.Name, unitStateString(u))
+			}
+		}
+		for _, c := range s.Certificates {
+			if verbose || c.State != service.FileOK {
+				fmt.Fprintf(tw, "%s\tcertificate\t%s\t%s\n", s.Name, c.Path, c.State)
+			}
+		}
+	}
+	tw.Flush()
+}
+
+// unitStateString returns a short description of the state of the given unit.
+func unitStateString(u service.UnitStatus) string {
+	if !u.Exists {
+		return "not-found"
+	}
+	return fmt.Sprintf("%s, %s", u.UnitFileState, u.ActiveState)
+}
@@ -0,0 +1,60 @@
+// Copyright (c) 2017 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package systemd
+
+// UnitState describes the state of a single unit.
+type UnitState struct {
+	Exists        bool   `json:"exists"`
+	ActiveState   string `json:"active_state,omitempty"`    // E.g. active, inactive, failed
+	UnitFileState string `json:"unit_file_state,omitempty"` // E.g. enabled, disabled, static
+	Oneshot       bool   `json:"oneshot,omitempty"`         // Set for services with Type=oneshot
+}
+
+// IsEnabled returns true if the unit is enabled (or cannot be enabled because it is static).
+func (s UnitState) IsEnabled() bool {
+	switch s.UnitFileState {
+	case "enabled", "enabled-runtime", "static":
+		return true
+	default:
+		return false
+	}
+}
+
+// Client is used by services to manage systemd units.
+type Client interface {
+	// Reload behaves as `systemctl daemon-reload`
+	Reload() error
+	// Start behaves as `systemctl start <unit>`
+	Start(unit string) error
+	// Restart behaves as `systemctl restart <unit>`
+	Restart(unit string) error
+	// Stop behaves as `systemctl stop <unit>`
+	Stop(unit string) error
+	// Enable behaves as `systemctl enable <unit>`
+	Enable(unit string) error
+	// Disable behaves as `systemctl disable <unit>`
+	Disable(unit string) error
+	// Exists returns true if the given unit exists, false otherwise.
+	Exists(unit string) (bool, error)
+	// IsActive returns true if the given unit exists and its ActiveState is 'active',
+	// false otherwise.
+	IsActive(unit string) (bool, error)
+	// State returns the current state of the given unit.
+	State(unit string) (UnitState, error)
+	// StopAndRemove stops the given unit, disables it, and removes all given files.
+	StopAndRemove(unit string, filesToRemove ...string) error
+	// Close performs any pending work and releases all resources of the client.
+	Close() error
+}
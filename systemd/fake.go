@@ -0,0 +1,195 @@
+// Copyright (c) 2017 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package systemd
+
+import (
+	"fmt"
+	"sync"
+)
+
+// FakeUnit is the state of a unit in a FakeClient.
+type FakeUnit struct {
+	Exists  bool
+	Active  bool
+	Enabled bool
+}
+
+// FakeClient is an in-memory Client that keeps track of the state of units
+// and records all calls made to it. It is intended for use in tests.
+type FakeClient struct {
+	mutex   sync.Mutex
+	units   map[string]FakeUnit
+	calls   []string
+	removed []string
+	reloads int
+	closed  bool
+}
+
+// NewFakeClient creates a new FakeClient without any units.
+func NewFakeClient() *FakeClient {
+	return &FakeClient{
+		units: make(map[string]FakeUnit),
+	}
+}
+
+// SetUnit sets the state of the given unit.
+func (c *FakeClient) SetUnit(unit string, state FakeUnit) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.units[unit] = state
+}
+
+// Unit returns the state of the given unit.
+func (c *FakeClient) Unit(unit string) FakeUnit {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.units[unit]
+}
+
+// Calls returns all calls made so far, formatted as "<method> <unit>".
+func (c *FakeClient) Calls() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return append([]string(nil), c.calls...)
+}
+
+// Called returns true if the given method has been called for the given unit.
+func (c *FakeClient) Called(method, unit string) bool {
+	call := fmt.Sprintf("%s %s", method, unit)
+	for _, x := range c.Calls() {
+		if x == call {
+			return true
+		}
+	}
+	return false
+}
+
+// Reloads returns the number of times Reload has been called.
+func (c *FakeClient) Reloads() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.reloads
+}
+
+// RemovedFiles returns all files passed to StopAndRemove so far.
+func (c *FakeClient) RemovedFiles() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return append([]string(nil), c.removed...)
+}
+
+// Closed returns true if Close has been called.
+func (c *FakeClient) Closed() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.closed
+}
+
+// Reset clears all recorded calls, leaving the state of all units intact.
+func (c *FakeClient) Reset() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.calls = nil
+	c.removed = nil
+	c.reloads = 0
+}
+
+// update records a call and applies the given change to the state of the given unit.
+func (c *FakeClient) update(method, unit string, change func(u *FakeUnit)) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.calls = append(c.calls, fmt.Sprintf("%s %s", method, unit))
+	u := c.units[unit]
+	u.Exists = true
+	change(&u)
+	c.units[unit] = u
+}
+
+func (c *FakeClient) Reload() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.calls = append(c.calls, "reload")
+	c.reloads++
+	return nil
+}
+
+func (c *FakeClient) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.closed = true
+	return nil
+}
+
+func (c *FakeClient) Start(unit string) error {
+	c.update("start", unit, func(u *FakeUnit) { u.Active = true })
+	return nil
+}
+
+func (c *FakeClient) Restart(unit string) error {
+	c.update("restart", unit, func(u *FakeUnit) { u.Active = true })
+	return nil
+}
+
+func (c *FakeClient) Stop(unit string) error {
+	c.update("stop", unit, func(u *FakeUnit) { u.Active = false })
+	return nil
+}
+
+func (c *FakeClient) Enable(unit string) error {
+	c.update("enable", unit, func(u *FakeUnit) { u.Enabled = true })
+	return nil
+}
+
+func (c *FakeClient) Disable(unit string) error {
+	c.update("disable", unit, func(u *FakeUnit) { u.Enabled = false })
+	return nil
+}
+
+func (c *FakeClient) Exists(unit string) (bool, error) {
+	return c.Unit(unit).Exists, nil
+}
+
+func (c *FakeClient) IsActive(unit string) (bool, error) {
+	u := c.Unit(unit)
+	return u.Exists && u.Active, nil
+}
+
+func (c *FakeClient) State(unit string) (UnitState, error) {
+	u := c.Unit(unit)
+	if !u.Exists {
+		return UnitState{}, nil
+	}
+	state := UnitState{
+		Exists:        true,
+		ActiveState:   "inactive",
+		UnitFileState: "disabled",
+	}
+	if u.Active {
+		state.ActiveState = "active"
+	}
+	if u.Enabled {
+		state.UnitFileState = "enabled"
+	}
+	return state, nil
+}
+
+func (c *FakeClient) StopAndRemove(unit string, filesToRemove ...string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.calls = append(c.calls, fmt.Sprintf("remove %s", unit))
+	c.removed = append(c.removed, filesToRemove...)
+	delete(c.units, unit)
+	return nil
+}
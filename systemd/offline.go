@@ -0,0 +1,245 @@
+// Copyright (c) 2017 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package systemd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/op/go-logging"
+)
+
+const (
+	unitConfigDir = "/etc/systemd/system"
+)
+
+var (
+	unitSearchPath = []string{
+		unitConfigDir,
+		"/lib/systemd/system",
+		"/usr/lib/systemd/system",
+	}
+)
+
+// OfflineClient is a Client that operates on the unit files of a system that is not running
+// (e.g. a mounted disk image), in the same way as `systemctl --root=<root>` does.
+// Since nothing runs on such a system, starting & stopping units is a no-op.
+type OfflineClient struct {
+	Logger *logging.Logger
+	Root   string
+}
+
+// NewOfflineClient creates a new client for the system found at the given root directory.
+func NewOfflineClient(logger *logging.Logger, root string) *OfflineClient {
+	return &OfflineClient{
+		Logger: logger,
+		Root:   root,
+	}
+}
+
+// Reload is a no-op for an offline system.
+func (c *OfflineClient) Reload() error {
+	return nil
+}
+
+// Close is a no-op for an offline system.
+func (c *OfflineClient) Close() error {
+	return nil
+}
+
+// Start is a no-op for an offline system.
+func (c *OfflineClient) Start(unit string) error {
+	return nil
+}
+
+// Restart is a no-op for an offline system.
+func (c *OfflineClient) Restart(unit string) error {
+	return nil
+}
+
+// Stop is a no-op for an offline system.
+func (c *OfflineClient) Stop(unit string) error {
+	return nil
+}
+
+// Enable behaves as `systemctl --root=<root> enable <unit>`
+func (c *OfflineClient) Enable(unit string) error {
+	c.Logger.Debugf("enabling %s in %s", unit, c.Root)
+	return maskAny(enableOffline(c.Root, unit))
+}
+
+// Disable behaves as `systemctl --root=<root> disable <unit>`
+func (c *OfflineClient) Disable(unit string) error {
+	c.Logger.Debugf("disabling %s in %s", unit, c.Root)
+	return maskAny(disableOffline(c.Root, unit))
+}
+
+// Exists returns true if a file for the given unit exists, false otherwise.
+func (c *OfflineClient) Exists(unit string) (bool, error) {
+	return findUnitFile(c.Root, unit) != "", nil
+}
+
+// IsActive always returns false, since nothing is running on an offline system.
+func (c *OfflineClient) IsActive(unit string) (bool, error) {
+	return false, nil
+}
+
+// State returns the state of the unit file of the given unit.
+// The ActiveState is always 'inactive', since nothing is running on an offline system.
+func (c *OfflineClient) State(unit string) (UnitState, error) {
+	unitPath := findUnitFile(c.Root, unit)
+	if unitPath == "" {
+		return UnitState{}, nil
+	}
+	content, err := ioutil.ReadFile(filepath.Join(c.Root, unitPath))
+	if err != nil {
+		return UnitState{}, maskAny(err)
+	}
+	state := UnitState{
+		Exists:      true,
+		ActiveState: "inactive",
+		Oneshot:     bytes.Contains(content, []byte("Type=oneshot")),
+	}
+	links, err := installLinks(c.Root, unit)
+	if err != nil {
+		return UnitState{}, maskAny(err)
+	}
+	state.UnitFileState = "static"
+	for _, link := range links {
+		state.UnitFileState = "enabled"
+		if _, err := os.Lstat(filepath.Join(c.Root, link)); err != nil {
+			state.UnitFileState = "disabled"
+			break
+		}
+	}
+	return state, nil
+}
+
+// StopAndRemove disables the given unit and removes all given files.
+func (c *OfflineClient) StopAndRemove(unit string, filesToRemove ...string) error {
+	if exists, _ := c.Exists(unit); exists {
+		if err := c.Disable(unit); err != nil {
+			c.Logger.Errorf("Disabling %s failed: %#v", unit, err)
+		}
+	}
+	for _, filePath := range filesToRemove {
+		filePath = filepath.Join(c.Root, filePath)
+		if _, err := os.Stat(filePath); err == nil {
+			c.Logger.Debugf("removing %s", filePath)
+			os.RemoveAll(filePath)
+		}
+	}
+	return nil
+}
+
+// findUnitFile returns the path of the file (relative to root) that contains the given unit.
+// Returns an empty string if the unit cannot be found.
+func findUnitFile(root, unit string) string {
+	for _, dir := range unitSearchPath {
+		path := filepath.Join(dir, unit)
+		if _, err := os.Stat(filepath.Join(root, path)); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// installLinks returns the paths (relative to root) of the symlinks that enable the given unit,
+// based on the [Install] section of its unit file.
+func installLinks(root, unit string) ([]string, error) {
+	unitPath := findUnitFile(root, unit)
+	if unitPath == "" {
+		return nil, maskAny(fmt.Errorf("Unit %s not found in %s", unit, root))
+	}
+	content, err := ioutil.ReadFile(filepath.Join(root, unitPath))
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	var links []string
+	inInstall := false
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") {
+			inInstall = line == "[Install]"
+			continue
+		}
+		if !inInstall {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		var suffix string
+		switch strings.TrimSpace(parts[0]) {
+		case "WantedBy":
+			suffix = ".wants"
+		case "RequiredBy":
+			suffix = ".requires"
+		default:
+			continue
+		}
+		for _, target := range strings.Fields(parts[1]) {
+			links = append(links, filepath.Join(unitConfigDir, target+suffix, unit))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, maskAny(err)
+	}
+	return links, nil
+}
+
+// enableOffline enables the given unit in the system found at the given root.
+func enableOffline(root, unit string) error {
+	links, err := installLinks(root, unit)
+	if err != nil {
+		return maskAny(err)
+	}
+	unitPath := findUnitFile(root, unit)
+	for _, link := range links {
+		linkPath := filepath.Join(root, link)
+		if err := os.MkdirAll(filepath.Dir(linkPath), 0755); err != nil {
+			return maskAny(err)
+		}
+		if dest, err := os.Readlink(linkPath); err == nil && dest == unitPath {
+			continue
+		}
+		os.Remove(linkPath)
+		if err := os.Symlink(unitPath, linkPath); err != nil {
+			return maskAny(err)
+		}
+	}
+	return nil
+}
+
+// disableOffline disables the given unit in the system found at the given root.
+func disableOffline(root, unit string) error {
+	links, err := installLinks(root, unit)
+	if err != nil {
+		return maskAny(err)
+	}
+	for _, link := range links {
+		if err := os.Remove(filepath.Join(root, link)); err != nil && !os.IsNotExist(err) {
+			return maskAny(err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package systemd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Test enableOffline & disableOffline create and remove the symlinks listed in the [Install] section.
+func TestEnableDisableOffline(t *testing.T) {
+	root, err := ioutil.TempDir("", "gluon-systemd")
+	if err != nil {
+		t.Fatalf("TempDir failed: %#v", err)
+	}
+	defer os.RemoveAll(root)
+
+	unitDir := filepath.Join(root, unitConfigDir)
+	if err := os.MkdirAll(unitDir, 0755); err != nil {
+		t.Fatalf("MkdirAll failed: %#v", err)
+	}
+	unit := "[Unit]\nDescription=Test\n\n[Service]\nExecStart=/bin/true\n\n[Install]\nWantedBy=multi-user.target default.target\n"
+	if err := ioutil.WriteFile(filepath.Join(unitDir, "test.service"), []byte(unit), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %#v", err)
+	}
+
+	if err := enableOffline(root, "test.service"); err != nil {
+		t.Fatalf("Expected success, got %#v", err)
+	}
+	for _, target := range []string{"multi-user.target", "default.target"} {
+		link := filepath.Join(unitDir, target+".wants", "test.service")
+		dest, err := os.Readlink(link)
+		if err != nil {
+			t.Fatalf("Expected symlink %s, got %#v", link, err)
+		}
+		if expected := "/etc/systemd/system/test.service"; dest != expected {
+			t.Errorf("Expected '%s', got '%s'", expected, dest)
+		}
+	}
+
+	if err := disableOffline(root, "test.service"); err != nil {
+		t.Fatalf("Expected success, got %#v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(unitDir, "multi-user.target.wants", "test.service")); !os.IsNotExist(err) {
+		t.Errorf("Expected symlink to be removed, got %#v", err)
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright (c) 2017 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package systemd
+
+import (
+	"sync"
+
+	"github.com/pulcy/gluon/util"
+)
+
+// planClient is a Client that adds all changes to a plan (dry-run).
+// Queries are forwarded to the wrapped client.
+// Like SystemdClient, reloads are deferred until the next start, restart or stop.
+type planClient struct {
+	Client
+	plan          *util.Plan
+	target        *util.Target
+	mutex         sync.Mutex
+	reloadPending bool
+}
+
+// NewPlanClient creates a Client that adds all changes to the given plan, instead of making them.
+// Exists & IsActive are answered by the given client. Files are checked on the given target.
+func NewPlanClient(plan *util.Plan, client Client, target *util.Target) Client {
+	return &planClient{
+		Client: client,
+		plan:   plan,
+		target: target,
+	}
+}
+
+func (c *planClient) Reload() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.reloadPending = true
+	return nil
+}
+
+func (c *planClient) Close() error {
+	c.flushReload()
+	return maskAny(c.Client.Close())
+}
+
+// flushReload adds a pending reload to the plan.
+func (c *planClient) flushReload() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.reloadPending {
+		c.plan.Add(util.Action{Kind: util.ActionReload})
+		c.reloadPending = false
+	}
+}
+
+func (c *planClient) Start(unit string) error {
+	c.flushReload()
+	c.plan.Add(util.Action{Kind: util.ActionStartUnit, Target: unit})
+	return nil
+}
+
+func (c *planClient) Restart(unit string) error {
+	c.flushReload()
+	c.plan.Add(util.Action{Kind: util.ActionRestartUnit, Target: unit})
+	return nil
+}
+
+func (c *planClient) Stop(unit string) error {
+	c.flushReload()
+	c.plan.Add(util.Action{Kind: util.ActionStopUnit, Target: unit})
+	return nil
+}
+
+func (c *planClient) Enable(unit string) error {
+	c.plan.Add(util.Action{Kind: util.ActionEnableUnit, Target: unit})
+	return nil
+}
+
+func (c *planClient) Disable(unit string) error {
+	c.plan.Add(util.Action{Kind: util.ActionDisableUnit, Target: unit})
+	return nil
+}
+
+func (c *planClient) StopAndRemove(unit string, filesToRemove ...string) error {
+	exists, err := c.Exists(unit)
+	if err != nil {
+		return maskAny(err)
+	}
+	if exists {
+		c.Stop(unit)
+		c.Disable(unit)
+	}
+	for _, filePath := range filesToRemove {
+		if err := c.target.RemoveAll(filePath); err != nil {
+			return maskAny(err)
+		}
+	}
+	return nil
+}
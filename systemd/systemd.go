@@ -16,6 +16,8 @@ package systemd
 
 import (
 	"os"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/coreos/go-systemd/dbus"
@@ -27,8 +29,16 @@ const (
 	jobTimeout = time.Minute
 )
 
+// SystemdClient is a Client for the running system, using D-Bus.
+// A single D-Bus connection is opened on first use and kept until Close is called.
+// Reloads are deferred until a unit is started, restarted or queried (or the client is closed),
+// so multiple calls to Reload result in a single daemon-reload.
 type SystemdClient struct {
 	Logger *logging.Logger
+
+	mutex         sync.Mutex
+	conn          *dbus.Conn
+	reloadPending bool
 }
 
 // NewSystemdClient creates a new systemd client
@@ -38,20 +48,71 @@ func NewSystemdClient(logger *logging.Logger) *SystemdClient {
 	}
 }
 
-// Reload behaves as `systemctl daemon-reload`
+// Reload behaves as `systemctl daemon-reload`.
+// The actual reload is deferred until it is needed.
 func (sdc *SystemdClient) Reload() error {
-	sdc.Logger.Debug("reloading daemon")
+	sdc.mutex.Lock()
+	defer sdc.mutex.Unlock()
+	sdc.reloadPending = true
+	return nil
+}
 
+// Close performs a pending reload (if any) and closes the D-Bus connection.
+func (sdc *SystemdClient) Close() error {
+	sdc.mutex.Lock()
+	defer sdc.mutex.Unlock()
+	if sdc.conn == nil && !sdc.reloadPending {
+		return nil
+	}
+	if err := sdc.open(); err != nil {
+		return maskAny(err)
+	}
+	err := sdc.flushReload()
+	sdc.conn.Close()
+	sdc.conn = nil
+	return maskAny(err)
+}
+
+// connection returns the D-Bus connection, opening it when needed.
+// If a reload is pending, it is performed first.
+func (sdc *SystemdClient) connection() (*dbus.Conn, error) {
+	sdc.mutex.Lock()
+	defer sdc.mutex.Unlock()
+	if err := sdc.open(); err != nil {
+		return nil, maskAny(err)
+	}
+	if err := sdc.flushReload(); err != nil {
+		return nil, maskAny(err)
+	}
+	return sdc.conn, nil
+}
+
+// open opens the D-Bus connection if that has not been done yet.
+// The caller must hold the mutex.
+func (sdc *SystemdClient) open() error {
+	if sdc.conn != nil {
+		return nil
+	}
 	conn, err := dbus.New()
 	if err != nil {
 		return maskAny(err)
 	}
+	sdc.conn = conn
+	return nil
+}
 
-	if err := conn.Reload(); err != nil {
+// flushReload performs a pending reload.
+// The caller must hold the mutex and have an open connection.
+func (sdc *SystemdClient) flushReload() error {
+	if !sdc.reloadPending {
+		return nil
+	}
+	sdc.Logger.Debug("reloading daemon")
+	if err := sdc.conn.Reload(); err != nil {
 		sdc.Logger.Errorf("reloading daemon failed: %#v", err)
 		return maskAny(err)
 	}
-
+	sdc.reloadPending = false
 	return nil
 }
 
@@ -59,7 +120,7 @@ func (sdc *SystemdClient) Reload() error {
 func (sdc *SystemdClient) Start(unit string) error {
 	sdc.Logger.Debugf("starting %s", unit)
 
-	conn, err := dbus.New()
+	conn, err := sdc.connection()
 	if err != nil {
 		return maskAny(err)
 	}
@@ -79,24 +140,22 @@ func (sdc *SystemdClient) Start(unit string) error {
 			// We need a start considered to be failed, when the unit is already running.
 			return nil
 		case "canceled", "timeout", "dependency", "skipped":
-			return maskAny(errgo.WithCausef(nil, SystemdError, res))
+			return maskAny(errgo.WithCausef(nil, SystemdError, "%s", res))
 		default:
 			// that should never happen
 			sdc.Logger.Errorf("unexpected systemd response: '%s'", res)
-			return maskAny(errgo.WithCausef(nil, SystemdError, res))
+			return maskAny(errgo.WithCausef(nil, SystemdError, "%s", res))
 		}
 	case <-time.After(jobTimeout):
 		return maskAny(errgo.WithCausef(nil, SystemdError, "job timeout"))
 	}
-
-	return nil
 }
 
 // Restart behaves as `systemctl restart <unit>`
 func (sdc *SystemdClient) Restart(unit string) error {
 	sdc.Logger.Debugf("restarting %s", unit)
 
-	conn, err := dbus.New()
+	conn, err := sdc.connection()
 	if err != nil {
 		return maskAny(err)
 	}
@@ -116,24 +175,22 @@ func (sdc *SystemdClient) Restart(unit string) error {
 			// We need a start considered to be failed, when the unit is already running.
 			return nil
 		case "canceled", "timeout", "dependency", "skipped":
-			return maskAny(errgo.WithCausef(nil, SystemdError, res))
+			return maskAny(errgo.WithCausef(nil, SystemdError, "%s", res))
 		default:
 			// that should never happen
 			sdc.Logger.Errorf("unexpected systemd response: '%s'", res)
-			return maskAny(errgo.WithCausef(nil, SystemdError, res))
+			return maskAny(errgo.WithCausef(nil, SystemdError, "%s", res))
 		}
 	case <-time.After(jobTimeout):
 		return maskAny(errgo.WithCausef(nil, SystemdError, "job timeout"))
 	}
-
-	return nil
 }
 
 // Stop behaves as `systemctl stop <unit>`
 func (sdc *SystemdClient) Stop(unit string) error {
 	sdc.Logger.Debugf("stopping %s", unit)
 
-	conn, err := dbus.New()
+	conn, err := sdc.connection()
 	if err != nil {
 		return maskAny(err)
 	}
@@ -154,24 +211,22 @@ func (sdc *SystemdClient) Stop(unit string) error {
 			// it is stopped, so all good.
 			return nil
 		case "timeout", "failed", "dependency", "skipped":
-			return maskAny(errgo.WithCausef(nil, SystemdError, res))
+			return maskAny(errgo.WithCausef(nil, SystemdError, "%s", res))
 		default:
 			// that should never happen
 			sdc.Logger.Errorf("unexpected systemd response: '%s'", res)
-			return maskAny(errgo.WithCausef(nil, SystemdError, res))
+			return maskAny(errgo.WithCausef(nil, SystemdError, "%s", res))
 		}
 	case <-time.After(jobTimeout):
 		return maskAny(errgo.WithCausef(nil, SystemdError, "job timeout"))
 	}
-
-	return nil
 }
 
 // Enable behaves as `systemctl enable <unit>`
 func (sdc *SystemdClient) Enable(unit string) error {
 	sdc.Logger.Debugf("enabling %s", unit)
 
-	conn, err := dbus.New()
+	conn, err := sdc.connection()
 	if err != nil {
 		return maskAny(err)
 	}
@@ -188,7 +243,7 @@ func (sdc *SystemdClient) Enable(unit string) error {
 func (sdc *SystemdClient) Disable(unit string) error {
 	sdc.Logger.Debugf("disabling %s", unit)
 
-	conn, err := dbus.New()
+	conn, err := sdc.connection()
 	if err != nil {
 		return maskAny(err)
 	}
@@ -203,7 +258,7 @@ func (sdc *SystemdClient) Disable(unit string) error {
 
 // Exists returns true if the given unit exists, false otherwise.
 func (sdc *SystemdClient) Exists(unit string) (bool, error) {
-	conn, err := dbus.New()
+	conn, err := sdc.connection()
 	if err != nil {
 		return false, maskAny(err)
 	}
@@ -248,7 +303,7 @@ func (sdc *SystemdClient) StopAndRemove(unit string, filesToRemove ...string) er
 // IsActive returns true if the given unit exists and its ActiveState is 'active',
 // false otherwise.
 func (sdc *SystemdClient) IsActive(unit string) (bool, error) {
-	conn, err := dbus.New()
+	conn, err := sdc.connection()
 	if err != nil {
 		return false, maskAny(err)
 	}
@@ -266,3 +321,32 @@ func (sdc *SystemdClient) IsActive(unit string) (bool, error) {
 
 	return false, nil
 }
+
+// State returns the current state of the given unit.
+func (sdc *SystemdClient) State(unit string) (UnitState, error) {
+	conn, err := sdc.connection()
+	if err != nil {
+		return UnitState{}, maskAny(err)
+	}
+
+	props, err := conn.GetUnitProperties(unit)
+	if err != nil {
+		return UnitState{}, maskAny(err)
+	}
+	loadState, _ := props["LoadState"].(string)
+	if loadState == "not-found" {
+		return UnitState{}, nil
+	}
+	state := UnitState{Exists: true}
+	state.ActiveState, _ = props["ActiveState"].(string)
+	state.UnitFileState, _ = props["UnitFileState"].(string)
+	if strings.HasSuffix(unit, ".service") {
+		serviceProps, err := conn.GetUnitTypeProperties(unit, "Service")
+		if err != nil {
+			return UnitState{}, maskAny(err)
+		}
+		serviceType, _ := serviceProps["Type"].(string)
+		state.Oneshot = serviceType == "oneshot"
+	}
+	return state, nil
+}
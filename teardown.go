@@ -0,0 +1,142 @@
+// Copyright (c) 2017 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"strings"
+
+	"github.com/juju/errgo"
+	"github.com/spf13/cobra"
+
+	"github.com/pulcy/gluon/service"
+	"github.com/pulcy/gluon/systemd"
+	"github.com/pulcy/gluon/util"
+)
+
+var (
+	cmdTeardown = &cobra.Command{
+		Use:   "teardown [service...]",
+		Short: "Stop & remove all units and files of the given services",
+		Run:   runTeardown,
+	}
+	teardownFlags   = &service.ServiceFlags{}
+	teardownOptions struct {
+		All    bool
+		DryRun bool
+		Root   string
+	}
+)
+
+func init() {
+	cmdTeardown.Flags().BoolVar(&teardownOptions.All, "all", false, "Teardown all services")
+	cmdTeardown.Flags().BoolVar(&teardownOptions.DryRun, "dry-run", false, "Show the changes teardown would make, without making them")
+	cmdTeardown.Flags().StringVar(&teardownOptions.Root, "root", "", "Teardown the system found in this directory (e.g. a mounted disk image) instead of the running system")
+
+	cmdMain.AddCommand(cmdTeardown)
+}
+
+func runTeardown(cmd *cobra.Command, args []string) {
+	services, err := selectServices(args)
+	if err != nil {
+		Exitf("%s\n", err.Error())
+	}
+	if len(services) == 0 && !teardownOptions.All {
+		Exitf("Specify the services to teardown, or use --all\n")
+	}
+	if teardownOptions.All {
+		services = allServices()
+	}
+	ordered, err := service.Order(allServices())
+	if err != nil {
+		Exitf("Cannot order services: %v\n", err)
+	}
+	services = filterServices(ordered, services)
+
+	var plan *util.Plan
+	if teardownOptions.DryRun {
+		plan = util.NewPlan()
+	}
+	target := util.NewTarget(log, teardownOptions.Root, plan)
+	// Teardown must also work on a node that is only partially configured
+	if err := teardownFlags.SetupDefaults(target); err != nil {
+		log.Warningf("SetupDefaults failed: %v", err)
+	}
+
+	sdc := newSystemdClient(target)
+	if plan != nil {
+		sdc = systemd.NewPlanClient(plan, sdc, target)
+	}
+	deps := service.ServiceDependencies{
+		Systemd: sdc,
+		Logger:  log,
+		Target:  target,
+	}
+
+	// Teardown in reverse setup order
+	for i := len(services) - 1; i >= 0; i-- {
+		t := services[i]
+		log.Info("%d/%d Teardown %s", len(services)-i, len(services), t.Name())
+		if err := t.Teardown(deps, teardownFlags); err != nil {
+			sdc.Close()
+			Exitf("Teardown %s failed: %#v\n", t.Name(), err)
+		}
+	}
+	if err := sdc.Close(); err != nil {
+		Exitf("Closing systemd client failed: %#v\n", err)
+	}
+	if plan != nil {
+		plan.Print(os.Stdout)
+	}
+	log.Info("Done")
+}
+
+// filterServices returns those services from the given list that are also in the given selection.
+func filterServices(services, selection []service.Service) []service.Service {
+	var result []service.Service
+	for _, s := range services {
+		for _, x := range selection {
+			if x.Name() == s.Name() {
+				result = append(result, s)
+				break
+			}
+		}
+	}
+	return result
+}
+
+// selectServices returns the services with given names.
+func selectServices(names []string) ([]service.Service, error) {
+	var result []service.Service
+	found := make(map[string]bool)
+	for _, s := range allServices() {
+		for _, name := range names {
+			if s.Name() == name {
+				result = append(result, s)
+				found[name] = true
+			}
+		}
+	}
+	var unknown []string
+	for _, name := range names {
+		if !found[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		return nil, maskAny(errgo.Newf("Unknown service(s): %s", strings.Join(unknown, ", ")))
+	}
+	return result, nil
+}
@@ -15,9 +15,12 @@
 package main
 
 import (
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 
 	"github.com/pulcy/gluon/update"
 )
@@ -27,6 +30,9 @@ const (
 )
 
 var (
+	updateResume bool
+	updateStatus bool
+
 	cmdUpdate = &cobra.Command{
 		Use: "update",
 		Run: runUpdate,
@@ -38,23 +44,88 @@ func init() {
 	cmdUpdate.Flags().DurationVar(&updateFlags.MachineDelay, "machine-delay", defaultMachineDelay, "Time between updating 2 machines")
 	cmdUpdate.Flags().DurationVar(&updateFlags.RebootExpired, "reboot-expired", 0, "Duration until a reboot is considered failed")
 	cmdUpdate.Flags().BoolVar(&updateFlags.Reboot, "reboot", false, "If set, reboot machines after update")
+	cmdUpdate.Flags().StringSliceVar(&updateFlags.HealthGates, "health-gates", defaultHealthGates(), "Health gates a machine must pass before the next machine is updated ("+strings.Join(update.AllHealthGates, ",")+")")
+	cmdUpdate.Flags().DurationVar(&updateFlags.HealthTimeout, "health-timeout", 0, "Maximum time a machine may take to pass all health gates (default 5m)")
+	cmdUpdate.Flags().IntVar(&updateFlags.Policy.BatchSize, "batch-size", 1, "Number of workers updated at once (core machines are always updated one at a time)")
+	cmdUpdate.Flags().IntVar(&updateFlags.Policy.BatchPercentage, "batch-percentage", 0, "Percentage of the workers updated at once (overrides --batch-size)")
+	cmdUpdate.Flags().IntVar(&updateFlags.Policy.Canary, "canary", 0, "Number of machines updated before pausing for confirmation")
+	cmdUpdate.Flags().BoolVar(&updateFlags.Rollback, "rollback", true, "If set, the previous gluon image is restored on a machine that fails to update")
+	cmdUpdate.Flags().BoolVar(&updateFlags.RollbackAll, "rollback-all", false, "If set, all machines updated so far are rolled back when a machine fails to update")
+	cmdUpdate.Flags().StringVar(&updateFlags.JournalDir, "journal-dir", "", "Directory where the progress of updates is recorded (default $HOME/.gluon/updates)")
+	cmdUpdate.Flags().BoolVar(&updateResume, "resume", false, "If set, the most recent interrupted or failed update is continued")
+	cmdUpdate.Flags().BoolVar(&updateStatus, "status", false, "If set, the progress of the most recent update is shown")
+	addSSHFlags(cmdUpdate.Flags(), &updateFlags.SSH)
 	cmdUpdate.Flags().BoolVar(&updateFlags.AskConfirmation, "confirm", false, "If set, confirmation is needed before continuing with next machine")
 
 	cmdMain.AddCommand(cmdUpdate)
 }
 
+// defaultHealthGates returns all health gates, except the kubelet gate when kubernetes is disabled.
+func defaultHealthGates() []string {
+	var gates []string
+	for _, g := range update.AllHealthGates {
+		if g == update.GateKubelet && !defaultKubernetesEnabled() {
+			continue
+		}
+		gates = append(gates, g)
+	}
+	return gates
+}
+
+// addSSHFlags adds all flags used to configure SSH connections to other machines to the given flag set.
+func addSSHFlags(f *pflag.FlagSet, opts *update.SSHOptions) {
+	f.StringVar(&opts.UserName, "user", "core", "SSH user name used to connect to other machines")
+	f.StringVar(&opts.KeyFile, "ssh-key", "", "Private key used to connect to other machines (defaults to SSH agent & default keys)")
+	f.StringVar(&opts.KnownHostsFile, "known-hosts", "", "Known hosts file containing the host keys of all machines (defaults to ~/.ssh/known_hosts)")
+	f.BoolVar(&opts.InsecureSkipHostKeyCheck, "insecure-skip-host-key-check", false, "If set, host keys of other machines are not verified")
+	f.StringVar(&opts.JumpHost, "jump-host", "", "Bastion host ([user@]host[:port]) used to reach other machines")
+	f.DurationVar(&opts.ConnectTimeout, "ssh-connect-timeout", 0, "Maximum time to connect to another machine (default 10s)")
+	f.DurationVar(&opts.CommandTimeout, "ssh-command-timeout", 0, "Maximum time a command on another machine may take (default 5m)")
+}
+
 func runUpdate(cmd *cobra.Command, args []string) {
-	if len(args) == 0 {
-		Exitf("Gluon-image argument needed\n")
+	if updateStatus {
+		showUpdateStatus()
+		return
+	}
+	if !updateResume {
+		if len(args) == 0 {
+			Exitf("Gluon-image argument needed\n")
+		}
+		updateFlags.GluonImage = args[0]
+	} else if len(args) > 0 {
+		Exitf("Gluon-image argument not allowed with --resume\n")
 	}
-	updateFlags.GluonImage = args[0]
 	if err := updateFlags.SetupDefaults(log); err != nil {
 		Exitf("SetupDefaults failed: %#v\n", err)
 	}
 
-	if err := update.UpdateAllMachines(updateFlags, log); err != nil {
-		Exitf("Update failed: %#v", err)
+	if updateResume {
+		if err := update.ResumeUpdate(updateFlags, log); err != nil {
+			Exitf("Update failed: %#v", err)
+		}
+	} else {
+		if err := update.UpdateAllMachines(updateFlags, log); err != nil {
+			Exitf("Update failed: %#v", err)
+		}
 	}
 
 	log.Info("Done")
 }
+
+// showUpdateStatus prints the progress of the most recent update.
+func showUpdateStatus() {
+	dir := updateFlags.JournalDir
+	if dir == "" {
+		dir = update.DefaultJournalDir()
+	}
+	journal, err := update.LoadLatestJournal(dir)
+	if err != nil {
+		Exitf("Cannot load update journal: %#v\n", err)
+	}
+	if journal == nil {
+		fmt.Printf("No updates found in %s\n", dir)
+		return
+	}
+	fmt.Println(journal.String())
+}
@@ -0,0 +1,131 @@
+// Copyright (c) 2017 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package update
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/juju/errgo"
+
+	"github.com/pulcy/gluon/service"
+)
+
+const (
+	// HealthOK is the result of a successful probe.
+	HealthOK = "ok"
+
+	consulHTTPPort = 8500
+	kubeletHealthz = "http://127.0.0.1:10248/healthz"
+)
+
+// MemberHealth contains the results of probing the services of a cluster member.
+// Every field is HealthOK, a description of the failure, or empty when not probed.
+type MemberHealth struct {
+	Etcd    string `json:"etcd"`
+	Consul  string `json:"consul"`
+	Kubelet string `json:"kubelet,omitempty"`
+	SSH     string `json:"ssh"`
+}
+
+// Healthy returns true if all probes succeeded.
+func (h MemberHealth) Healthy() bool {
+	for _, x := range []string{h.Etcd, h.Consul, h.Kubelet, h.SSH} {
+		if x != "" && x != HealthOK {
+			return false
+		}
+	}
+	return true
+}
+
+type CheckFlags struct {
+	SSH               SSHOptions
+	EtcdClientPort    int
+	KubernetesEnabled bool
+	Timeout           time.Duration
+}
+
+// CheckMembers probes the services of all given members concurrently.
+// The result contains the health of every member, in the order of the given members.
+func CheckMembers(members []service.ClusterMember, flags CheckFlags) []MemberHealth {
+	result := make([]MemberHealth, len(members))
+	var wg sync.WaitGroup
+	for i, m := range members {
+		i, m := i, m
+		probes := []func(){
+			func() { result[i].Etcd = probeTCP(m.ClusterIP, flags.EtcdClientPort, flags.Timeout) },
+			func() {
+				result[i].Consul = probeHTTP(fmt.Sprintf("http://%s:%d/v1/status/leader", m.ClusterIP, consulHTTPPort), flags.Timeout)
+			},
+			func() {
+				result[i].SSH = probeSSH(m, flags.SSH, "true", flags.Timeout)
+				if flags.KubernetesEnabled && result[i].SSH == HealthOK {
+					// The kubelet healthz endpoint only listens on localhost
+					result[i].Kubelet = probeSSH(m, flags.SSH, "curl -sf "+kubeletHealthz, flags.Timeout)
+				}
+			},
+		}
+		for _, probe := range probes {
+			wg.Add(1)
+			go func(probe func()) {
+				defer wg.Done()
+				probe()
+			}(probe)
+		}
+	}
+	wg.Wait()
+	return result
+}
+
+// probeTCP checks that a TCP connection can be made to the given address.
+func probeTCP(host string, port int, timeout time.Duration) string {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, strconv.Itoa(port)), timeout)
+	if err != nil {
+		return err.Error()
+	}
+	conn.Close()
+	return HealthOK
+}
+
+// probeHTTP checks that a GET request on the given URL returns status 200.
+func probeHTTP(url string, timeout time.Duration) string {
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Get(url)
+	if err != nil {
+		return err.Error()
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Sprintf("status %d", resp.StatusCode)
+	}
+	return HealthOK
+}
+
+// probeSSH checks that the given command can be run on the given member.
+// Unlike runRemoteCommand, it gives up after the given timeout.
+func probeSSH(member service.ClusterMember, opts SSHOptions, command string, timeout time.Duration) string {
+	opts.ConnectTimeout = timeout
+	if _, err := opts.run(member.ClusterIP, command, "", timeout*2); err != nil {
+		if rerr, ok := errgo.Cause(err).(*RemoteCommandError); ok && rerr.Stderr != "" {
+			return rerr.Stderr
+		}
+		return errgo.Cause(err).Error()
+	}
+	return HealthOK
+}
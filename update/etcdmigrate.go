@@ -0,0 +1,273 @@
+// Copyright (c) 2017 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package update
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/juju/errgo"
+	logging "github.com/op/go-logging"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/pulcy/gluon/service"
+	"github.com/pulcy/gluon/util"
+)
+
+// MigratePhase is a step of the etcd v2 to v3 migration that is executed on all machines.
+type MigratePhase string
+
+const (
+	PhaseBackup       MigratePhase = "backup"        // Take a snapshot on all peers
+	PhaseStop         MigratePhase = "stop"          // Stop etcd2 on all peers
+	PhaseMigrate      MigratePhase = "migrate"       // Convert the v2 data into the v3 store on all peers
+	PhaseSwitchPeers  MigratePhase = "switch-peers"  // Run etcd v3 on all peers
+	PhaseSwitchOthers MigratePhase = "switch-others" // Run the etcd v3 gateway on all other machines
+
+	// migrateDataCommand converts the v2 data of a stopped peer and moves it to the v3 data directory.
+	// It does nothing when the data has been migrated already, so it can be repeated.
+	migrateDataCommand = `sudo sh -e -c 'if [ ! -d /var/lib/etcd/member ]; then ` +
+		`ETCDCTL_API=3 /usr/bin/etcdctl migrate --data-dir=/var/lib/etcd2; ` +
+		`if [ -d /var/lib/etcd ]; then rmdir /var/lib/etcd; fi; ` +
+		`mv /var/lib/etcd2 /var/lib/etcd; fi'`
+	switchCommand     = "sudo /home/core/bin/gluon etcd set-version 3 && sudo systemctl restart gluon"
+	migrateV2Service  = "etcd2.service"
+	etcdHealthTimeout = time.Minute * 5
+)
+
+var (
+	migratePhases = []MigratePhase{PhaseBackup, PhaseStop, PhaseMigrate, PhaseSwitchPeers, PhaseSwitchOthers}
+	phaseIntros   = map[MigratePhase]string{
+		PhaseBackup:       "Taking a snapshot of the etcd data on all peers",
+		PhaseStop:         "Stopping etcd on all peers, the etcd cluster is unavailable until all peers run etcd v3",
+		PhaseMigrate:      "Migrating the etcd v2 data to the v3 store on all peers",
+		PhaseSwitchPeers:  "Starting etcd v3 on all peers",
+		PhaseSwitchOthers: "Starting the etcd v3 gateway on all other machines",
+	}
+)
+
+type MigrateFlags struct {
+	service.ServiceFlags
+	SSH             SSHOptions
+	StatePath       string // Path of the file recording the progress of the migration
+	AskConfirmation bool   // If set, confirmation is needed before etcd is stopped
+}
+
+func (flags *MigrateFlags) SetupDefaults(log *logging.Logger) error {
+	if err := flags.ServiceFlags.SetupDefaults(util.NewTarget(log, "", nil)); err != nil {
+		return maskAny(err)
+	}
+	flags.SSH.SetupDefaults()
+	if flags.StatePath == "" {
+		flags.StatePath = filepath.Join(os.Getenv("HOME"), ".gluon", "etcd-migrate.json")
+	}
+	return nil
+}
+
+// MigrateState records the phases of the migration that have been completed.
+type MigrateState struct {
+	StartedAt time.Time      `json:"started-at"`
+	Completed []MigratePhase `json:"completed,omitempty"`
+	path      string
+}
+
+// LoadMigrateState loads the progress of the migration from the given path.
+// Returns an empty state if the migration has not been started.
+func LoadMigrateState(path string) (*MigrateState, error) {
+	s := &MigrateState{path: path}
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, maskAny(err)
+	}
+	if err := json.Unmarshal(content, s); err != nil {
+		return nil, maskAny(fmt.Errorf("Cannot parse %s: %v", path, err))
+	}
+	return s, nil
+}
+
+// IsCompleted returns true if the given phase has been completed.
+func (s *MigrateState) IsCompleted(phase MigratePhase) bool {
+	for _, p := range s.Completed {
+		if p == phase {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns a human readable summary of the progress.
+func (s *MigrateState) String() string {
+	lines := []string{fmt.Sprintf("etcd v3 migration (%s)", s.path)}
+	for _, p := range migratePhases {
+		status := "pending"
+		if s.IsCompleted(p) {
+			status = "done"
+		}
+		lines = append(lines, fmt.Sprintf("  %-14s %s", p, status))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// complete records the given phase as completed.
+// The file is replaced atomically, so an interrupted save never leaves a corrupt state.
+func (s *MigrateState) complete(phase MigratePhase) error {
+	s.Completed = append(s.Completed, phase)
+	content, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return maskAny(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return maskAny(err)
+	}
+	tmpPath := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, content, 0644); err != nil {
+		return maskAny(err)
+	}
+	return maskAny(os.Rename(tmpPath, s.path))
+}
+
+// MigrateEtcd migrates all machines from etcd v2 to etcd v3.
+// All peers are stopped before any data is migrated, since a peer that keeps running etcd v2
+// would diverge from the migrated peers.
+// Each phase is executed on all machines and recorded when it has completed, so running the
+// migration again after a failure resumes at the phase that failed.
+func MigrateEtcd(flags *MigrateFlags, log *logging.Logger) error {
+	state, err := LoadMigrateState(flags.StatePath)
+	if err != nil {
+		return maskAny(err)
+	}
+	if state.StartedAt.IsZero() {
+		state.StartedAt = time.Now()
+	} else {
+		log.Infof("Resuming migration recorded in %s", flags.StatePath)
+	}
+	members, err := flags.GetClusterMembers(log)
+	if err != nil {
+		return maskAny(err)
+	}
+	var peers, others []service.ClusterMember
+	for _, m := range members {
+		if m.HasRole(service.RoleEtcd) {
+			peers = append(peers, m)
+		} else {
+			others = append(others, m)
+		}
+	}
+	if len(peers) == 0 {
+		return maskAny(fmt.Errorf("No etcd peers found"))
+	}
+	return maskAny(migrateMembers(state, peers, others, flags, log))
+}
+
+// migrateMembers executes all phases of the migration that have not been completed yet
+// on the given peers & other machines.
+func migrateMembers(state *MigrateState, peers, others []service.ClusterMember, flags *MigrateFlags, log *logging.Logger) error {
+	for _, phase := range migratePhases {
+		if state.IsCompleted(phase) {
+			continue
+		}
+		log.Infof("%s...", phaseIntros[phase])
+		var err error
+		switch phase {
+		case PhaseBackup:
+			if err = checkEtcdGate(peers[0], UpdateFlags{SSH: flags.SSH}, log); err == nil {
+				err = onEachMember(peers, flags, log, "sudo /home/core/bin/gluon etcd backup")
+			}
+		case PhaseStop:
+			if flags.AskConfirmation {
+				if err := confirm("Stop etcd on all peers?"); err != nil {
+					return maskAny(err)
+				}
+			}
+			if err = onEachMember(peers, flags, log, "sudo systemctl stop "+migrateV2Service); err == nil {
+				err = verifyStopped(peers, flags, log)
+			}
+		case PhaseMigrate:
+			err = onEachMember(peers, flags, log, migrateDataCommand)
+		case PhaseSwitchPeers:
+			// etcd does not report ready until it has a quorum, so all peers must be started at once
+			g := errgroup.Group{}
+			for _, m := range peers {
+				m := m
+				g.Go(func() error {
+					_, err := runRemoteCommand(m, flags.SSH, log, switchCommand, "", false)
+					return maskAny(err)
+				})
+			}
+			if err = g.Wait(); err == nil {
+				err = waitEtcdHealthy(peers[0], flags, log)
+			}
+		case PhaseSwitchOthers:
+			err = onEachMember(others, flags, log, switchCommand)
+		}
+		if err != nil {
+			log.Errorf("Phase %s failed, run the migration again to resume: %v", phase, err)
+			return maskAny(err)
+		}
+		if err := state.complete(phase); err != nil {
+			return maskAny(err)
+		}
+	}
+	log.Infof("All machines run etcd v3")
+	return nil
+}
+
+// onEachMember runs the given command on all given members, one after the other.
+func onEachMember(members []service.ClusterMember, flags *MigrateFlags, log *logging.Logger, command string) error {
+	for _, m := range members {
+		log.Infof("Running on %s...", m.ClusterIP)
+		if _, err := runRemoteCommand(m, flags.SSH, log, command, "", false); err != nil {
+			return maskAny(err)
+		}
+	}
+	return nil
+}
+
+// verifyStopped returns an error if etcd is still active on any of the given peers.
+func verifyStopped(peers []service.ClusterMember, flags *MigrateFlags, log *logging.Logger) error {
+	for _, m := range peers {
+		_, err := runRemoteCommand(m, flags.SSH, log, "systemctl is-active "+migrateV2Service, "", true)
+		if err == nil {
+			return maskAny(fmt.Errorf("%s is still active on %s", migrateV2Service, m.ClusterIP))
+		}
+		// is-active exits with code 3 when the unit is not active, anything else means we do not know
+		if rerr, ok := errgo.Cause(err).(*RemoteCommandError); !ok || rerr.ExitCode != 3 {
+			return maskAny(err)
+		}
+	}
+	return nil
+}
+
+// waitEtcdHealthy waits until the etcd cluster is healthy, as seen from the given member.
+func waitEtcdHealthy(member service.ClusterMember, flags *MigrateFlags, log *logging.Logger) error {
+	start := time.Now()
+	for {
+		err := checkEtcdGate(member, UpdateFlags{SSH: flags.SSH}, log)
+		if err == nil {
+			return nil
+		}
+		if time.Since(start) > etcdHealthTimeout {
+			return maskAny(fmt.Errorf("etcd cluster not healthy after %s: %v", etcdHealthTimeout, err))
+		}
+		time.Sleep(healthGateInterval)
+	}
+}
@@ -0,0 +1,198 @@
+package update
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	logging "github.com/op/go-logging"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/pulcy/gluon/service"
+)
+
+func TestMigrateState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "etcd-migrate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "etcd-migrate.json")
+
+	s, err := LoadMigrateState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.IsCompleted(PhaseBackup) {
+		t.Errorf("Expected no completed phases in a new state")
+	}
+	if err := s.complete(PhaseBackup); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.complete(PhaseStop); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadMigrateState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.IsCompleted(PhaseBackup) || !loaded.IsCompleted(PhaseStop) || loaded.IsCompleted(PhaseMigrate) {
+		t.Errorf("Unexpected completed phases %v", loaded.Completed)
+	}
+}
+
+// migrateTestCluster runs a test SSH server for every machine and records the phase
+// of every command that is run.
+type migrateTestCluster struct {
+	mutex     sync.Mutex
+	phases    []MigratePhase // Phase of every command in the order they were run
+	failPhase MigratePhase   // Commands of this phase fail
+	peers     []service.ClusterMember
+	others    []service.ClusterMember
+	servers   []*testSSHServer
+}
+
+func newMigrateTestCluster(t *testing.T, clientKey ssh.PublicKey, peerCount, otherCount int) *migrateTestCluster {
+	c := &migrateTestCluster{}
+	for i := 0; i < peerCount+otherCount; i++ {
+		isPeer := i < peerCount
+		server := newTestSSHServer(t, clientKey, func(command, stdin string) (string, string, int) {
+			return c.handle(command, isPeer)
+		})
+		c.servers = append(c.servers, server)
+		m := service.ClusterMember{ClusterIP: server.Addr()}
+		if isPeer {
+			c.peers = append(c.peers, m)
+		} else {
+			c.others = append(c.others, m)
+		}
+	}
+	return c
+}
+
+func (c *migrateTestCluster) Close() {
+	for _, s := range c.servers {
+		s.Close()
+	}
+}
+
+func (c *migrateTestCluster) handle(command string, isPeer bool) (string, string, int) {
+	var phase MigratePhase
+	switch {
+	case strings.Contains(command, "/health"):
+		return `{"health":"true"}`, "", 0
+	case strings.HasPrefix(command, "systemctl is-active"):
+		return "inactive", "", 3
+	case strings.Contains(command, "etcd backup"):
+		phase = PhaseBackup
+	case strings.Contains(command, "systemctl stop"):
+		phase = PhaseStop
+	case command == migrateDataCommand:
+		phase = PhaseMigrate
+	case command == switchCommand && isPeer:
+		phase = PhaseSwitchPeers
+	case command == switchCommand:
+		phase = PhaseSwitchOthers
+	default:
+		return "", "unexpected command", 127
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.phases = append(c.phases, phase)
+	if phase == c.failPhase {
+		return "", "failed", 1
+	}
+	return "", "", 0
+}
+
+// takePhases returns the phases of all commands run so far, without duplicates, and resets them.
+func (c *migrateTestCluster) takePhases() ([]MigratePhase, map[MigratePhase]int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	var order []MigratePhase
+	counts := make(map[MigratePhase]int)
+	for _, p := range c.phases {
+		if len(order) == 0 || order[len(order)-1] != p {
+			order = append(order, p)
+		}
+		counts[p]++
+	}
+	c.phases = nil
+	return order, counts
+}
+
+func TestMigrateMembers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "etcd-migrate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keyFile, clientPub := newTestClientKey(t, dir)
+	cluster := newMigrateTestCluster(t, clientPub, 3, 2)
+	defer cluster.Close()
+
+	log := logging.MustGetLogger("test")
+	flags := &MigrateFlags{SSH: SSHOptions{KeyFile: keyFile, InsecureSkipHostKeyCheck: true}}
+	flags.SSH.SetupDefaults()
+	migrate := func(statePath string) (*MigrateState, error) {
+		state, err := LoadMigrateState(statePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = migrateMembers(state, cluster.peers, cluster.others, flags, log)
+		loaded, lerr := LoadMigrateState(statePath)
+		if lerr != nil {
+			t.Fatal(lerr)
+		}
+		return loaded, err
+	}
+
+	// All phases run on all machines, each phase completes before the next starts
+	if _, err := migrate(filepath.Join(dir, "complete.json")); err != nil {
+		t.Fatalf("Migration failed: %v", err)
+	}
+	order, counts := cluster.takePhases()
+	if !reflect.DeepEqual(order, migratePhases) {
+		t.Errorf("Expected phases %v, got %v", migratePhases, order)
+	}
+	for _, p := range migratePhases {
+		expected := len(cluster.peers)
+		if p == PhaseSwitchOthers {
+			expected = len(cluster.others)
+		}
+		if counts[p] != expected {
+			t.Errorf("Expected phase %s to run on %d machines, got %d", p, expected, counts[p])
+		}
+	}
+
+	// A failed phase is resumed, completed phases are not repeated
+	for i, failPhase := range migratePhases {
+		statePath := filepath.Join(dir, string(failPhase)+".json")
+		cluster.failPhase = failPhase
+		state, err := migrate(statePath)
+		if err == nil {
+			t.Fatalf("Expected migration to fail in phase %s", failPhase)
+		}
+		if len(state.Completed) != i || (i > 0 && !reflect.DeepEqual(state.Completed, migratePhases[:i])) {
+			t.Errorf("Expected completed phases %v after failing %s, got %v", migratePhases[:i], failPhase, state.Completed)
+		}
+		cluster.takePhases()
+
+		cluster.failPhase = ""
+		state, err = migrate(statePath)
+		if err != nil {
+			t.Fatalf("Resuming at phase %s failed: %v", failPhase, err)
+		}
+		if !reflect.DeepEqual(state.Completed, migratePhases) {
+			t.Errorf("Expected all phases to be completed, got %v", state.Completed)
+		}
+		if order, _ := cluster.takePhases(); !reflect.DeepEqual(order, migratePhases[i:]) {
+			t.Errorf("Expected resume at %s to run phases %v, got %v", failPhase, migratePhases[i:], order)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright (c) 2017 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package update
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/juju/errgo"
+	logging "github.com/op/go-logging"
+
+	"github.com/pulcy/gluon/service"
+)
+
+const (
+	// Health gates that must pass on a machine before the next machine is updated.
+	GateEtcd    = "etcd"    // ETCD cluster is healthy
+	GateUnits   = "units"   // All units managed by gluon are active (gluon status)
+	GateKubelet = "kubelet" // Kubernetes node of the machine is Ready
+	GateConsul  = "consul"  // All consul members are alive
+
+	defaultHealthTimeout = time.Minute * 5
+	healthGateInterval   = time.Second * 5
+
+	consulStatusAlive = 1
+	consulStatusLeft  = 3
+)
+
+var (
+	// AllHealthGates contains the names of all health gates, in the order they are checked.
+	AllHealthGates = []string{GateEtcd, GateUnits, GateKubelet, GateConsul}
+
+	healthGates = map[string]func(member service.ClusterMember, flags UpdateFlags, log *logging.Logger) error{
+		GateEtcd:    checkEtcdGate,
+		GateUnits:   checkUnitsGate,
+		GateKubelet: checkKubeletGate,
+		GateConsul:  checkConsulGate,
+	}
+)
+
+// validateHealthGates returns an error if one of the given gates is unknown.
+func validateHealthGates(gates []string) error {
+	for _, g := range gates {
+		if _, found := healthGates[g]; !found {
+			return maskAny(fmt.Errorf("Unknown health gate '%s', expected one of %s", g, strings.Join(AllHealthGates, ", ")))
+		}
+	}
+	return nil
+}
+
+// waitForHealthGates checks all configured health gates on the given member, until they
+// all pass or the health timeout has expired.
+func waitForHealthGates(member service.ClusterMember, flags UpdateFlags, log *logging.Logger) error {
+	if len(flags.HealthGates) == 0 {
+		return nil
+	}
+	start := time.Now()
+	for {
+		var failures []string
+		for _, name := range flags.HealthGates {
+			if err := healthGates[name](member, flags, log); err != nil {
+				failures = append(failures, fmt.Sprintf("%s: %v", name, errgo.Cause(err)))
+			}
+		}
+		if len(failures) == 0 {
+			log.Infof("Machine %s passed health gates %s", member.ClusterIP, strings.Join(flags.HealthGates, ", "))
+			return nil
+		}
+		if time.Since(start) > flags.HealthTimeout {
+			return maskAny(fmt.Errorf("Machine %s failed health gates after %s:\n- %s", member.ClusterIP, flags.HealthTimeout, strings.Join(failures, "\n- ")))
+		}
+		log.Debugf("Machine %s not yet healthy: %s", member.ClusterIP, strings.Join(failures, "; "))
+		time.Sleep(healthGateInterval)
+	}
+}
+
+// checkEtcdGate checks the health of the ETCD cluster, as seen from the given member.
+// The local (plain HTTP) client URL is used, so no client certificates are needed.
+// Only ETCD peers serve that URL, so the gate always passes on other members
+// (updating them cannot affect the health of the cluster).
+func checkEtcdGate(member service.ClusterMember, flags UpdateFlags, log *logging.Logger) error {
+	if !member.HasRole(service.RoleEtcd) {
+		return nil
+	}
+	out, err := runRemoteCommand(member, flags.SSH, log, "curl -sf http://127.0.0.1:4001/health", "", true)
+	if err != nil {
+		return maskAny(err)
+	}
+	var health struct {
+		Health string `json:"health"`
+	}
+	if err := json.Unmarshal([]byte(out), &health); err != nil {
+		return maskAny(fmt.Errorf("Cannot parse etcd health '%s'", out))
+	}
+	if health.Health != "true" {
+		return maskAny(fmt.Errorf("etcd cluster is not healthy"))
+	}
+	return nil
+}
+
+// checkUnitsGate checks that all files & units managed by gluon are as expected.
+func checkUnitsGate(member service.ClusterMember, flags UpdateFlags, log *logging.Logger) error {
+	if _, err := runRemoteCommand(member, flags.SSH, log, "sudo /home/core/bin/gluon status", "", true); err != nil {
+		if rerr, ok := errgo.Cause(err).(*RemoteCommandError); ok && rerr.ExitCode == 1 {
+			return maskAny(fmt.Errorf("gluon status reports problems, run `gluon status` on %s for details", member.ClusterIP))
+		}
+		return maskAny(err)
+	}
+	return nil
+}
+
+// checkKubeletGate checks that the kubernetes node of the given member is Ready.
+func checkKubeletGate(member service.ClusterMember, flags UpdateFlags, log *logging.Logger) error {
+	cmd := fmt.Sprintf(`kubectl --kubeconfig=/var/lib/kubelet/kubeconfig get node %s -o jsonpath='{.status.conditions[?(@.type=="Ready")].status}'`, member.ClusterIP)
+	out, err := runRemoteCommand(member, flags.SSH, log, cmd, "", true)
+	if err != nil {
+		return maskAny(err)
+	}
+	if strings.TrimSpace(out) != "True" {
+		return maskAny(fmt.Errorf("node %s is not Ready (%s)", member.ClusterIP, out))
+	}
+	return nil
+}
+
+// checkConsulGate checks that all consul members known by the given member are alive.
+func checkConsulGate(member service.ClusterMember, flags UpdateFlags, log *logging.Logger) error {
+	out, err := runRemoteCommand(member, flags.SSH, log, "curl -sf http://127.0.0.1:8500/v1/agent/members", "", true)
+	if err != nil {
+		return maskAny(err)
+	}
+	var members []struct {
+		Name   string
+		Addr   string
+		Status int
+	}
+	if err := json.Unmarshal([]byte(out), &members); err != nil {
+		return maskAny(fmt.Errorf("Cannot parse consul members: %v", err))
+	}
+	var notAlive []string
+	for _, m := range members {
+		if m.Status != consulStatusAlive && m.Status != consulStatusLeft {
+			notAlive = append(notAlive, fmt.Sprintf("%s (%s)", m.Name, m.Addr))
+		}
+	}
+	if len(notAlive) > 0 {
+		return maskAny(fmt.Errorf("consul members not alive: %s", strings.Join(notAlive, ", ")))
+	}
+	return nil
+}
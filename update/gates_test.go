@@ -0,0 +1,30 @@
+package update
+
+import (
+	"testing"
+	"time"
+
+	logging "github.com/op/go-logging"
+
+	"github.com/pulcy/gluon/service"
+)
+
+func TestEtcdGateOnWorker(t *testing.T) {
+	log := logging.MustGetLogger("test")
+	flags := UpdateFlags{
+		HealthGates:   []string{GateEtcd},
+		HealthTimeout: time.Millisecond,
+		SSH:           SSHOptions{InsecureSkipHostKeyCheck: true},
+	}
+	flags.SSH.SetupDefaults()
+
+	// Nothing listens on this address, so the gate fails whenever it contacts the member
+	worker := service.ClusterMember{ClusterIP: "127.0.0.1:1", Roles: []string{service.RoleWorker}}
+	if err := waitForHealthGates(worker, flags, log); err != nil {
+		t.Errorf("Expected worker to pass the etcd gate, got %v", err)
+	}
+	peer := service.ClusterMember{ClusterIP: "127.0.0.1:1", Roles: []string{service.RoleCore}}
+	if err := waitForHealthGates(peer, flags, log); err == nil {
+		t.Errorf("Expected unreachable etcd peer to fail the etcd gate")
+	}
+}
@@ -0,0 +1,234 @@
+// Copyright (c) 2017 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package update
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pulcy/gluon/service"
+)
+
+// MemberUpdateStatus is the progress of the update of a single member.
+type MemberUpdateStatus string
+
+const (
+	MemberPending  MemberUpdateStatus = "pending"
+	MemberUpdating MemberUpdateStatus = "updating"
+	MemberDone     MemberUpdateStatus = "done"
+	MemberFailed   MemberUpdateStatus = "failed"
+	// MemberRolledBack is the status of a member that was restored to its previous image.
+	MemberRolledBack MemberUpdateStatus = "rolled-back"
+
+	journalTimeFormat = "20060102-150405"
+)
+
+// Journal records the progress of an update run, so it can be resumed when it is interrupted.
+type Journal struct {
+	ID         string          `json:"id"`
+	GluonImage string          `json:"gluon-image"`
+	Reboot     bool            `json:"reboot,omitempty"`
+	StartedAt  time.Time       `json:"started-at"`
+	FinishedAt *time.Time      `json:"finished-at,omitempty"`
+	Members    []JournalMember `json:"members"`
+
+	path  string
+	mutex sync.Mutex
+}
+
+// JournalMember records the progress of the update of a single member.
+type JournalMember struct {
+	MachineID     string             `json:"machine-id"`
+	ClusterIP     string             `json:"cluster-ip"`
+	Status        MemberUpdateStatus `json:"status"`
+	PreviousImage string             `json:"previous-image,omitempty"` // Gluon image the member had before the update
+	StartedAt     *time.Time         `json:"started-at,omitempty"`
+	FinishedAt    *time.Time         `json:"finished-at,omitempty"`
+	Error         string             `json:"error,omitempty"`
+}
+
+// DefaultJournalDir returns the directory used to record the progress of updates
+// when no directory is specified.
+func DefaultJournalDir() string {
+	return filepath.Join(os.Getenv("HOME"), ".gluon", "updates")
+}
+
+// newJournal creates a journal for a new update run of the given members.
+func newJournal(dir string, flags UpdateFlags, members []service.ClusterMember) *Journal {
+	now := time.Now()
+	j := &Journal{
+		ID:         now.Format(journalTimeFormat),
+		GluonImage: flags.GluonImage,
+		Reboot:     flags.Reboot,
+		StartedAt:  now,
+	}
+	for _, m := range members {
+		j.Members = append(j.Members, JournalMember{
+			MachineID: m.MachineID,
+			ClusterIP: m.ClusterIP,
+			Status:    MemberPending,
+		})
+	}
+	j.path = filepath.Join(dir, j.ID+".json")
+	return j
+}
+
+// LoadLatestJournal loads the journal of the most recent update run found in the given directory.
+// Returns nil (without error) if there is no journal.
+func LoadLatestJournal(dir string) (*Journal, error) {
+	names, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	if len(names) == 0 {
+		return nil, nil
+	}
+	// IDs are timestamps, so the last name is the most recent run
+	sort.Strings(names)
+	path := names[len(names)-1]
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	j := &Journal{}
+	if err := json.Unmarshal(content, j); err != nil {
+		return nil, maskAny(fmt.Errorf("Cannot parse %s: %v", path, err))
+	}
+	j.path = path
+	return j, nil
+}
+
+// Path returns the path of the file containing the journal.
+func (j *Journal) Path() string {
+	return j.path
+}
+
+// Complete returns true if all members have been updated.
+func (j *Journal) Complete() bool {
+	for _, m := range j.Members {
+		if m.Status != MemberDone {
+			return false
+		}
+	}
+	return true
+}
+
+// Member returns the journal entry of the member with given cluster IP.
+func (j *Journal) Member(clusterIP string) *JournalMember {
+	for i, m := range j.Members {
+		if m.ClusterIP == clusterIP {
+			return &j.Members[i]
+		}
+	}
+	return nil
+}
+
+// setStatus updates the status of the member with given cluster IP and saves the journal.
+func (j *Journal) setStatus(clusterIP string, status MemberUpdateStatus, err error) error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	m := j.Member(clusterIP)
+	if m == nil {
+		return maskAny(fmt.Errorf("Member %s is not part of update %s", clusterIP, j.ID))
+	}
+	now := time.Now()
+	m.Status = status
+	switch status {
+	case MemberUpdating:
+		m.StartedAt = &now
+		m.FinishedAt = nil
+		m.Error = ""
+	case MemberDone, MemberFailed:
+		m.FinishedAt = &now
+	}
+	if err != nil {
+		m.Error = err.Error()
+	}
+	return maskAny(j.save())
+}
+
+// setPreviousImage records the gluon image the member with given cluster IP had before the update.
+// An image that was already recorded (by an earlier attempt) is kept.
+// Returns the recorded image.
+func (j *Journal) setPreviousImage(clusterIP, image string) string {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	m := j.Member(clusterIP)
+	if m == nil {
+		return image
+	}
+	if m.PreviousImage == "" {
+		m.PreviousImage = image
+	}
+	return m.PreviousImage
+}
+
+// finish marks the run as finished and saves the journal.
+func (j *Journal) finish() error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	now := time.Now()
+	j.FinishedAt = &now
+	return maskAny(j.save())
+}
+
+// save writes the journal to disk.
+// The file is replaced atomically, so an interrupted save never leaves a corrupt journal.
+func (j *Journal) save() error {
+	content, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return maskAny(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(j.path), 0755); err != nil {
+		return maskAny(err)
+	}
+	tmpPath := j.path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, content, 0644); err != nil {
+		return maskAny(err)
+	}
+	return maskAny(os.Rename(tmpPath, j.path))
+}
+
+// String returns a human readable summary of the journal.
+func (j *Journal) String() string {
+	lines := []string{
+		fmt.Sprintf("Update %s to %s (%s)", j.ID, j.GluonImage, j.path),
+	}
+	if j.FinishedAt != nil {
+		lines = append(lines, fmt.Sprintf("Finished at %s", j.FinishedAt.Format(time.RFC3339)))
+	} else {
+		lines = append(lines, fmt.Sprintf("Started at %s, not finished", j.StartedAt.Format(time.RFC3339)))
+	}
+	for _, m := range j.Members {
+		line := fmt.Sprintf("  %-15s %-8s", m.ClusterIP, m.Status)
+		if m.FinishedAt != nil {
+			line += " " + m.FinishedAt.Format(time.RFC3339)
+		} else if m.StartedAt != nil {
+			line += " " + m.StartedAt.Format(time.RFC3339)
+		}
+		if m.Error != "" {
+			line += " " + m.Error
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
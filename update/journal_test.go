@@ -0,0 +1,51 @@
+package update
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/pulcy/gluon/service"
+)
+
+func TestJournalResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gluon-journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if j, err := LoadLatestJournal(dir); err != nil || j != nil {
+		t.Fatalf("Expected no journal, got %v, %v", j, err)
+	}
+
+	members := []service.ClusterMember{
+		{MachineID: "a", ClusterIP: "10.0.0.1"},
+		{MachineID: "b", ClusterIP: "10.0.0.2"},
+	}
+	j := newJournal(dir, UpdateFlags{ServiceFlags: service.ServiceFlags{GluonImage: "pulcy/gluon:1.0"}}, members)
+	if err := j.setStatus("10.0.0.1", MemberDone, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.setStatus("10.0.0.2", MemberFailed, errors.New("boom")); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.setStatus("10.0.0.3", MemberDone, nil); err == nil {
+		t.Error("Expected error for unknown member")
+	}
+
+	loaded, err := LoadLatestJournal(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.GluonImage != "pulcy/gluon:1.0" || loaded.Path() != j.Path() {
+		t.Errorf("Unexpected journal %+v", loaded)
+	}
+	if loaded.Complete() {
+		t.Error("Expected incomplete journal")
+	}
+	if m := loaded.Member("10.0.0.2"); m == nil || m.Status != MemberFailed || m.Error != "boom" {
+		t.Errorf("Unexpected member %+v", m)
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright (c) 2017 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package update
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	logging "github.com/op/go-logging"
+
+	"github.com/pulcy/gluon/service"
+	"github.com/pulcy/gluon/util"
+)
+
+const (
+	// replaceMembersCommand is run on every machine to replace its list of cluster members
+	// with the JSON encoded list passed on stdin.
+	replaceMembersCommand = "sudo /home/core/bin/gluon member replace"
+)
+
+type MemberFlags struct {
+	service.ServiceFlags
+	SSH     SSHOptions
+	NoSetup bool // If set, gluon setup is not triggered on the machines
+}
+
+func (flags *MemberFlags) SetupDefaults(log *logging.Logger) error {
+	if err := flags.ServiceFlags.SetupDefaults(util.NewTarget(log, "", nil)); err != nil {
+		return maskAny(err)
+	}
+	flags.SSH.SetupDefaults()
+	return nil
+}
+
+// AddMember adds the given member to the list of cluster members on all machines
+// (including the new member) and triggers a gluon setup on them.
+func AddMember(flags *MemberFlags, member service.ClusterMember, log *logging.Logger) error {
+	members, err := flags.localMembers(log)
+	if err != nil {
+		return maskAny(err)
+	}
+	for _, m := range members {
+		if m.MachineID == member.MachineID || m.ClusterIP == member.ClusterIP {
+			return maskAny(fmt.Errorf("Member %s (%s) already exists", m.MachineID, m.ClusterIP))
+		}
+	}
+	if member.HasRole(service.RoleEtcd) {
+		log.Infof("%s will be an ETCD peer, it joins the running ETCD cluster during its setup", member.ClusterIP)
+	}
+	members = append(members, member)
+	return maskAny(distributeMembers(flags, members, log))
+}
+
+// RemoveMember removes the member with given machine ID or cluster IP from the list of
+// cluster members on all remaining machines and triggers a gluon setup on them.
+// The removed machine itself is left untouched.
+func RemoveMember(flags *MemberFlags, idOrIP string, log *logging.Logger) error {
+	members, err := flags.localMembers(log)
+	if err != nil {
+		return maskAny(err)
+	}
+	var remaining []service.ClusterMember
+	var removed *service.ClusterMember
+	for _, m := range members {
+		if m.MachineID == idOrIP || m.ClusterIP == idOrIP {
+			m := m
+			removed = &m
+		} else {
+			remaining = append(remaining, m)
+		}
+	}
+	if removed == nil {
+		return maskAny(fmt.Errorf("No member found with machine ID or IP '%s'", idOrIP))
+	}
+	if len(remaining) == 0 {
+		return maskAny(fmt.Errorf("Cannot remove the last member of the cluster"))
+	}
+	if removed.HasRole(service.RoleEtcd) {
+		log.Infof("%s was an ETCD peer, it is removed from the running ETCD cluster during the setup of the remaining peers", removed.ClusterIP)
+	}
+	return maskAny(distributeMembers(flags, remaining, log))
+}
+
+// localMembers returns the cluster members of this machine.
+// Only members from the configuration file can be changed.
+func (flags *MemberFlags) localMembers(log *logging.Logger) ([]service.ClusterMember, error) {
+	if flags.MemberSource != "" && flags.MemberSource != service.FileMemberSource {
+		return nil, maskAny(fmt.Errorf("Members are managed by %s, change them there", flags.MemberSource))
+	}
+	members, err := flags.GetClusterMembers(log)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	return members, nil
+}
+
+// distributeMembers writes the given list of members on all of these members and
+// triggers a gluon setup on them.
+// All machines are tried, failures are reported at the end.
+func distributeMembers(flags *MemberFlags, members []service.ClusterMember, log *logging.Logger) error {
+	content, err := json.Marshal(members)
+	if err != nil {
+		return maskAny(err)
+	}
+	var failed []string
+	for _, m := range members {
+		log.Infof("Updating members on %s...", m.ClusterIP)
+		if _, err := runRemoteCommand(m, flags.SSH, log, replaceMembersCommand, string(content), false); err != nil {
+			log.Errorf("Failed to update members on %s: %v", m.ClusterIP, err)
+			failed = append(failed, m.ClusterIP)
+			continue
+		}
+		if flags.NoSetup {
+			continue
+		}
+		if _, err := runRemoteCommand(m, flags.SSH, log, "sudo systemctl restart gluon", "", false); err != nil {
+			log.Errorf("Failed to run setup on %s: %v", m.ClusterIP, err)
+			failed = append(failed, m.ClusterIP)
+		}
+	}
+	if len(failed) > 0 {
+		return maskAny(fmt.Errorf("Failed to update %s", strings.Join(failed, ", ")))
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+// Copyright (c) 2017 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package update
+
+import (
+	"fmt"
+
+	"github.com/pulcy/gluon/service"
+)
+
+// BatchPolicy determines the order in which machines are updated and how many are updated at once.
+// Workers are updated first, in batches. Core machines (running etcd, consul servers,
+// weave seeds or API servers) are updated after that, one at a time.
+type BatchPolicy struct {
+	BatchSize       int // Number of workers updated at once
+	BatchPercentage int // Percentage of the workers updated at once (overrides BatchSize when set)
+	Canary          int // Number of machines updated (one at a time) before pausing for confirmation
+}
+
+// validate returns an error if the policy is invalid.
+func (p BatchPolicy) validate() error {
+	if p.BatchSize < 0 {
+		return maskAny(fmt.Errorf("Batch size cannot be negative"))
+	}
+	if p.BatchPercentage < 0 || p.BatchPercentage > 100 {
+		return maskAny(fmt.Errorf("Batch percentage must be between 0 and 100"))
+	}
+	if p.BatchSize > 1 && p.BatchPercentage > 0 {
+		return maskAny(fmt.Errorf("Batch size and batch percentage cannot both be set"))
+	}
+	if p.Canary < 0 {
+		return maskAny(fmt.Errorf("Number of canaries cannot be negative"))
+	}
+	return nil
+}
+
+// batched returns true if the policy can update more than one machine at once.
+func (p BatchPolicy) batched() bool {
+	return p.BatchSize > 1 || p.BatchPercentage > 0
+}
+
+// isCoreMember returns true if the given member runs services that need a quorum,
+// which means it must be updated on its own.
+func isCoreMember(m service.ClusterMember) bool {
+	return m.HasRole(service.RoleEtcd) || m.HasRole(service.RoleConsulServer) || m.HasRole(service.RoleWeaveSeed) || m.HasRole(service.RoleAPIServer)
+}
+
+// planBatches splits the given members into batches that are updated one after another.
+// The first batches contain the canaries (one machine each), followed by the batches of workers,
+// followed by the core machines (one machine each).
+// The number of canary batches is returned as well.
+func (p BatchPolicy) planBatches(members []service.ClusterMember) ([][]service.ClusterMember, int) {
+	var workers, core []service.ClusterMember
+	for _, m := range members {
+		if isCoreMember(m) {
+			core = append(core, m)
+		} else {
+			workers = append(workers, m)
+		}
+	}
+
+	size := p.BatchSize
+	if p.BatchPercentage > 0 {
+		size = (len(workers)*p.BatchPercentage + 99) / 100
+	}
+	if size < 1 {
+		size = 1
+	}
+
+	ordered := append(workers, core...)
+	canaries := p.Canary
+	if canaries > len(ordered) {
+		canaries = len(ordered)
+	}
+
+	var batches [][]service.ClusterMember
+	for i, m := range ordered {
+		last := len(batches) - 1
+		if i < canaries || isCoreMember(m) || last < canaries || len(batches[last]) >= size || isCoreMember(batches[last][0]) {
+			batches = append(batches, []service.ClusterMember{m})
+		} else {
+			batches[last] = append(batches[last], m)
+		}
+	}
+	return batches, canaries
+}
@@ -0,0 +1,44 @@
+package update
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/pulcy/gluon/service"
+)
+
+func TestPlanBatches(t *testing.T) {
+	members := []service.ClusterMember{
+		{ClusterIP: "10.0.0.1"},
+		{ClusterIP: "10.0.0.2", Roles: []string{service.RoleWorker}},
+		{ClusterIP: "10.0.0.3", Roles: []string{service.RoleEtcd}},
+		{ClusterIP: "10.0.0.4", EtcdProxy: true},
+		{ClusterIP: "10.0.0.5", Roles: []string{service.RoleWorker, service.RoleLB}},
+		{ClusterIP: "10.0.0.6", Roles: []string{service.RoleWorker}},
+	}
+	tests := []struct {
+		Policy   BatchPolicy
+		Batches  [][]string
+		Canaries int
+	}{
+		{BatchPolicy{}, [][]string{{"10.0.0.2"}, {"10.0.0.4"}, {"10.0.0.5"}, {"10.0.0.6"}, {"10.0.0.1"}, {"10.0.0.3"}}, 0},
+		{BatchPolicy{BatchSize: 3}, [][]string{{"10.0.0.2", "10.0.0.4", "10.0.0.5"}, {"10.0.0.6"}, {"10.0.0.1"}, {"10.0.0.3"}}, 0},
+		{BatchPolicy{BatchPercentage: 50}, [][]string{{"10.0.0.2", "10.0.0.4"}, {"10.0.0.5", "10.0.0.6"}, {"10.0.0.1"}, {"10.0.0.3"}}, 0},
+		{BatchPolicy{BatchSize: 10, Canary: 1}, [][]string{{"10.0.0.2"}, {"10.0.0.4", "10.0.0.5", "10.0.0.6"}, {"10.0.0.1"}, {"10.0.0.3"}}, 1},
+		{BatchPolicy{Canary: 10}, [][]string{{"10.0.0.2"}, {"10.0.0.4"}, {"10.0.0.5"}, {"10.0.0.6"}, {"10.0.0.1"}, {"10.0.0.3"}}, 6},
+	}
+	for _, test := range tests {
+		batches, canaries := test.Policy.planBatches(members)
+		var ips [][]string
+		for _, b := range batches {
+			var list []string
+			for _, m := range b {
+				list = append(list, m.ClusterIP)
+			}
+			ips = append(ips, list)
+		}
+		if !reflect.DeepEqual(ips, test.Batches) || canaries != test.Canaries {
+			t.Errorf("Policy %+v: expected %v (%d canaries), got %v (%d canaries)", test.Policy, test.Batches, test.Canaries, ips, canaries)
+		}
+	}
+}
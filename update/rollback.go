@@ -0,0 +1,98 @@
+// Copyright (c) 2017 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package update
+
+import (
+	"fmt"
+	"strings"
+
+	logging "github.com/op/go-logging"
+
+	"github.com/pulcy/gluon/service"
+)
+
+var (
+	// currentImageCommand prints the gluon image a machine is currently running.
+	currentImageCommand = imageCommand("/home/core/bin/gluon", "/etc/pulcy/gluon-image")
+)
+
+// imageCommand returns a command that prints the gluon image, using the given gluon binary
+// or the given legacy image file.
+// Gluon versions without the config command print nothing for it (and exit 0),
+// so the output must not be empty.
+func imageCommand(gluonPath, imagePath string) string {
+	return fmt.Sprintf(`img=$(%s config get gluon-image 2>/dev/null) && [ -n "$img" ] && echo "$img" || cat %s`, gluonPath, imagePath)
+}
+
+// rollbackMachine restores the given previous gluon image on the given member and re-runs setup.
+func rollbackMachine(member service.ClusterMember, previousImage string, flags UpdateFlags, log *logging.Logger) error {
+	if previousImage == "" {
+		return maskAny(fmt.Errorf("Previous gluon image of %s is unknown, cannot roll back", member.ClusterIP))
+	}
+	log.Warningf("Rolling back %s to %s...", member.ClusterIP, previousImage)
+	flags.GluonImage = previousImage
+	flags.Reboot = false
+	flags.AskConfirmation = false
+	if err := installImage(member, flags, log); err != nil {
+		return maskAny(err)
+	}
+	if err := waitForHealthGates(member, flags, log); err != nil {
+		return maskAny(err)
+	}
+	log.Infof("Rolled back %s to %s", member.ClusterIP, previousImage)
+	return nil
+}
+
+// rollbackUpdatedMachines restores the previous gluon image on all members that were
+// updated successfully in the run recorded by the given journal.
+// Members are rolled back one at a time, in reverse order of their update.
+func rollbackUpdatedMachines(flags *UpdateFlags, journal *Journal, log *logging.Logger) error {
+	members, err := flags.GetClusterMembers(log)
+	if err != nil {
+		return maskAny(err)
+	}
+	var failed []string
+	for i := len(journal.Members) - 1; i >= 0; i-- {
+		jm := journal.Members[i]
+		if jm.Status != MemberDone || jm.PreviousImage == journal.GluonImage {
+			continue
+		}
+		var member *service.ClusterMember
+		for _, m := range members {
+			if m.ClusterIP == jm.ClusterIP {
+				m := m
+				member = &m
+				break
+			}
+		}
+		if member == nil {
+			log.Errorf("Member %s is no longer a cluster member, cannot roll back", jm.ClusterIP)
+			failed = append(failed, jm.ClusterIP)
+			continue
+		}
+		if err := rollbackMachine(*member, jm.PreviousImage, *flags, log); err != nil {
+			log.Errorf("Rollback of %s failed: %v", jm.ClusterIP, err)
+			failed = append(failed, jm.ClusterIP)
+			continue
+		}
+		if err := journal.setStatus(jm.ClusterIP, MemberRolledBack, nil); err != nil {
+			return maskAny(err)
+		}
+	}
+	if len(failed) > 0 {
+		return maskAny(fmt.Errorf("Rollback failed on %s", strings.Join(failed, ", ")))
+	}
+	return nil
+}
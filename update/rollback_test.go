@@ -0,0 +1,46 @@
+package update
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestImageCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rollback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	gluonPath, imagePath := filepath.Join(dir, "gluon"), filepath.Join(dir, "gluon-image")
+	if err := ioutil.WriteFile(imagePath, []byte("pulcy/gluon:old\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		Script   string
+		Expected string
+	}{
+		// Current gluon prints the image from its configuration
+		{"#!/bin/sh\necho pulcy/gluon:new\n", "pulcy/gluon:new"},
+		// Older gluon does not know the config command, prints nothing & exits 0
+		{"#!/bin/sh\necho 'Error: unknown command' >&2\n", "pulcy/gluon:old"},
+		// Failing gluon
+		{"#!/bin/sh\nexit 1\n", "pulcy/gluon:old"},
+	}
+	for _, test := range tests {
+		if err := ioutil.WriteFile(gluonPath, []byte(test.Script), 0755); err != nil {
+			t.Fatal(err)
+		}
+		out, err := exec.Command("sh", "-c", imageCommand(gluonPath, imagePath)).Output()
+		if err != nil {
+			t.Fatalf("Command failed: %v", err)
+		}
+		if image := strings.TrimSpace(string(out)); image != test.Expected {
+			t.Errorf("Expected '%s', got '%s'", test.Expected, image)
+		}
+	}
+}
@@ -0,0 +1,446 @@
+// Copyright (c) 2017 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package update
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/juju/errgo"
+	logging "github.com/op/go-logging"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/pulcy/gluon/service"
+)
+
+const (
+	defaultSSHUserName       = "core"
+	defaultSSHConnectTimeout = time.Second * 10
+	defaultSSHCommandTimeout = time.Minute * 5
+	defaultSSHPort           = "22"
+	keyscanTimeout           = time.Second * 10
+)
+
+var (
+	// defaultKeyFiles are the keys (relative to ~/.ssh) that are tried when no key file is given.
+	defaultKeyFiles = []string{"id_rsa", "id_ecdsa", "id_ed25519"}
+	// keyscanAlgorithms are the host key types collected by CollectHostKeys.
+	keyscanAlgorithms = []string{ssh.KeyAlgoED25519, ssh.KeyAlgoECDSA256, ssh.SigAlgoRSASHA2512}
+
+	errHostKeyCollected = errors.New("host key collected")
+)
+
+// SSHOptions configure how commands are run on other machines.
+// Without a key file, the keys of the SSH agent & the default keys in ~/.ssh are used.
+type SSHOptions struct {
+	UserName                 string
+	KeyFile                  string        // Private key used to authenticate (optional)
+	KnownHostsFile           string        // File with the pinned host keys of all machines (optional, defaults to ~/.ssh/known_hosts)
+	InsecureSkipHostKeyCheck bool          // If set, host keys are not verified
+	JumpHost                 string        // Bastion ([user@]host[:port]) used to reach the machines (optional)
+	ConnectTimeout           time.Duration // Maximum time to setup a connection
+	CommandTimeout           time.Duration // Maximum time a single command may take
+}
+
+// SetupDefaults fills empty options with default values.
+func (o *SSHOptions) SetupDefaults() {
+	if o.UserName == "" {
+		o.UserName = defaultSSHUserName
+	}
+	if o.ConnectTimeout == 0 {
+		o.ConnectTimeout = defaultSSHConnectTimeout
+	}
+	if o.CommandTimeout == 0 {
+		o.CommandTimeout = defaultSSHCommandTimeout
+	}
+}
+
+// RemoteCommandError is returned when a command on another machine failed.
+type RemoteCommandError struct {
+	Host     string
+	Command  string
+	ExitCode int    // -1 if the command did not exit normally (e.g. timeout)
+	Stderr   string // Trimmed stderr of the command
+	Reason   string // Description of the failure
+}
+
+func (e *RemoteCommandError) Error() string {
+	msg := fmt.Sprintf("'%s' on %s failed: %s", e.Command, e.Host, e.Reason)
+	if e.Stderr != "" {
+		msg = msg + ": " + e.Stderr
+	}
+	return msg
+}
+
+// DefaultKnownHostsFile returns the known hosts file used when none is configured.
+func DefaultKnownHostsFile() string {
+	return filepath.Join(os.Getenv("HOME"), ".ssh", "known_hosts")
+}
+
+// hostKeyError records the failure of a host key check, since the SSH handshake
+// only returns it as text.
+type hostKeyError struct {
+	err error
+}
+
+// hostKeyCallback returns the callback used to verify the host keys of the jump host & the machines.
+func (o SSHOptions) hostKeyCallback(failure *hostKeyError) (ssh.HostKeyCallback, error) {
+	if o.InsecureSkipHostKeyCheck {
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+	path := o.KnownHostsFile
+	if path == "" {
+		path = DefaultKnownHostsFile()
+	}
+	check, err := knownhosts.New(path)
+	if err != nil {
+		return nil, maskAny(fmt.Errorf("Cannot load known hosts from %s: %v (use `gluon member keyscan` on a trusted network to collect host keys)", path, err))
+	}
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		if err := check(hostname, remote, key); err != nil {
+			failure.err = err
+			return err
+		}
+		return nil
+	}, nil
+}
+
+// authMethods returns the methods used to authenticate.
+// The returned function releases the connection to the SSH agent (if any).
+func (o SSHOptions) authMethods() ([]ssh.AuthMethod, func(), error) {
+	if o.KeyFile != "" {
+		signer, err := loadSigner(o.KeyFile)
+		if err != nil {
+			return nil, nil, maskAny(err)
+		}
+		return []ssh.AuthMethod{ssh.PublicKeys(signer)}, func() {}, nil
+	}
+
+	var methods []ssh.AuthMethod
+	closeAgent := func() {}
+	if socket := os.Getenv("SSH_AUTH_SOCK"); socket != "" {
+		if conn, err := net.Dial("unix", socket); err == nil {
+			methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+			closeAgent = func() { conn.Close() }
+		}
+	}
+	var signers []ssh.Signer
+	for _, name := range defaultKeyFiles {
+		// Default keys that are missing or passphrase protected are skipped, like OpenSSH does without a terminal
+		if signer, err := loadSigner(filepath.Join(os.Getenv("HOME"), ".ssh", name)); err == nil {
+			signers = append(signers, signer)
+		}
+	}
+	if len(signers) > 0 {
+		methods = append(methods, ssh.PublicKeys(signers...))
+	}
+	if len(methods) == 0 {
+		return nil, nil, maskAny(fmt.Errorf("No SSH agent or default key found (use --ssh-key)"))
+	}
+	return methods, closeAgent, nil
+}
+
+// loadSigner reads the private key in the given file.
+func loadSigner(path string) (ssh.Signer, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, maskAny(err)
+	}
+	signer, err := ssh.ParsePrivateKey(content)
+	if _, ok := err.(*ssh.PassphraseMissingError); ok {
+		return nil, maskAny(fmt.Errorf("Key %s is passphrase protected (add it to the SSH agent instead)", path))
+	} else if err != nil {
+		return nil, maskAny(fmt.Errorf("Cannot parse key %s: %v", path, err))
+	}
+	return signer, nil
+}
+
+// splitUserHost splits [user@]host[:port] into a user name (defaults to given user) and an address.
+func splitUserHost(userHost, defaultUser string) (string, string) {
+	user := defaultUser
+	if i := strings.LastIndex(userHost, "@"); i >= 0 {
+		user, userHost = userHost[:i], userHost[i+1:]
+	}
+	return user, hostAddress(userHost)
+}
+
+// hostAddress adds the default SSH port to the given host, unless it contains a port.
+func hostAddress(host string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(strings.Trim(host, "[]"), defaultSSHPort)
+}
+
+// handshake sets up an SSH connection over the given connection.
+// The handshake is aborted when it takes longer than the timeout of the config.
+func handshake(conn net.Conn, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	if config.Timeout > 0 {
+		timer := time.AfterFunc(config.Timeout, func() { conn.Close() })
+		defer timer.Stop()
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if err != nil {
+		conn.Close()
+		return nil, maskAny(err)
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// dial connects to the given host ("host" or "host:port"), through the jump host if configured.
+// The returned function closes all connections.
+func (o SSHOptions) dial(host string, failure *hostKeyError) (*ssh.Client, func(), error) {
+	hostKeyCallback, err := o.hostKeyCallback(failure)
+	if err != nil {
+		return nil, nil, maskAny(err)
+	}
+	auth, closeAgent, err := o.authMethods()
+	if err != nil {
+		return nil, nil, maskAny(err)
+	}
+	config := func(user string) *ssh.ClientConfig {
+		return &ssh.ClientConfig{
+			User:            user,
+			Auth:            auth,
+			HostKeyCallback: hostKeyCallback,
+			Timeout:         o.ConnectTimeout,
+		}
+	}
+	dialDirect := func(user, addr string) (*ssh.Client, error) {
+		conn, err := net.DialTimeout("tcp", addr, o.ConnectTimeout)
+		if err != nil {
+			return nil, maskAny(err)
+		}
+		return handshake(conn, addr, config(user))
+	}
+
+	addr := hostAddress(host)
+	if o.JumpHost == "" {
+		client, err := dialDirect(o.UserName, addr)
+		if err != nil {
+			closeAgent()
+			return nil, nil, maskAny(err)
+		}
+		return client, func() { client.Close(); closeAgent() }, nil
+	}
+
+	jumpUser, jumpAddr := splitUserHost(o.JumpHost, o.UserName)
+	jump, err := dialDirect(jumpUser, jumpAddr)
+	if err != nil {
+		closeAgent()
+		return nil, nil, maskAny(fmt.Errorf("Cannot connect to jump host %s: %v", o.JumpHost, err))
+	}
+	conn, err := jump.Dial("tcp", addr)
+	if err != nil {
+		jump.Close()
+		closeAgent()
+		return nil, nil, maskAny(err)
+	}
+	client, err := handshake(conn, addr, config(o.UserName))
+	if err != nil {
+		jump.Close()
+		closeAgent()
+		return nil, nil, maskAny(err)
+	}
+	return client, func() { client.Close(); jump.Close(); closeAgent() }, nil
+}
+
+// run executes the given command on the given host ("host" or "host:port").
+// The command is aborted when it takes longer than the given timeout (if > 0).
+// Stdout is returned (without trailing newline).
+func (o SSHOptions) run(host, command, stdin string, timeout time.Duration) (string, error) {
+	newError := func(reason string) error {
+		return maskAny(&RemoteCommandError{
+			Host:     host,
+			Command:  command,
+			ExitCode: -1,
+			Reason:   reason,
+		})
+	}
+	var failure hostKeyError
+	client, closeClient, err := o.dial(host, &failure)
+	if err != nil {
+		if keyErr, ok := failure.err.(*knownhosts.KeyError); ok {
+			if len(keyErr.Want) == 0 {
+				return "", newError("unknown host key (use `gluon member keyscan` on a trusted network to collect host keys)")
+			}
+			return "", newError("host key has changed")
+		} else if failure.err != nil {
+			return "", newError(failure.err.Error())
+		}
+		return "", newError(fmt.Sprintf("ssh failed: %v", errgo.Cause(err)))
+	}
+	defer closeClient()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return "", newError(fmt.Sprintf("ssh failed: %v", err))
+	}
+	defer session.Close()
+	var stdOut, stdErr bytes.Buffer
+	session.Stdout = &stdOut
+	session.Stderr = &stdErr
+	if stdin != "" {
+		session.Stdin = strings.NewReader(stdin)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- session.Run(command) }()
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case err = <-done:
+	case <-expired:
+		// Closing the connection ends the session, so the output is no longer written
+		closeClient()
+		<-done
+		return "", maskAny(&RemoteCommandError{
+			Host:     host,
+			Command:  command,
+			ExitCode: -1,
+			Stderr:   strings.TrimSpace(stdErr.String()),
+			Reason:   fmt.Sprintf("timeout after %s", timeout),
+		})
+	}
+	if err != nil {
+		rerr := &RemoteCommandError{
+			Host:     host,
+			Command:  command,
+			ExitCode: -1,
+			Stderr:   strings.TrimSpace(stdErr.String()),
+			Reason:   err.Error(),
+		}
+		if exitErr, ok := err.(*ssh.ExitError); ok {
+			rerr.ExitCode = exitErr.ExitStatus()
+			rerr.Reason = fmt.Sprintf("exit code %d", rerr.ExitCode)
+			if exitErr.Signal() != "" {
+				rerr.Reason = fmt.Sprintf("%s (signal %s)", rerr.Reason, exitErr.Signal())
+			}
+		}
+		return "", maskAny(rerr)
+	}
+	return strings.TrimSuffix(stdOut.String(), "\n"), nil
+}
+
+// runRemoteCommand executes the given command on the given member.
+func runRemoteCommand(member service.ClusterMember, opts SSHOptions, log *logging.Logger, command, stdin string, quiet bool) (string, error) {
+	out, err := opts.run(member.ClusterIP, command, stdin, opts.CommandTimeout)
+	if err != nil {
+		if !quiet {
+			log.Errorf("%v", err)
+		}
+		return "", maskAny(err)
+	}
+	return out, nil
+}
+
+// CollectHostKeys fetches the SSH host keys of all given members and adds the ones
+// that are not yet known to the given known_hosts file.
+// Only use this on a network you trust, since the keys are not verified.
+func CollectHostKeys(members []service.ClusterMember, knownHostsFile string, log *logging.Logger) error {
+	existing, err := ioutil.ReadFile(knownHostsFile)
+	if err != nil && !os.IsNotExist(err) {
+		return maskAny(err)
+	}
+	known := make(map[string]bool)
+	for _, line := range strings.Split(string(existing), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			known[line] = true
+		}
+	}
+
+	var added []string
+	for _, m := range members {
+		addr := hostAddress(m.ClusterIP)
+		keys, err := scanHostKeys(addr, keyscanTimeout)
+		if err != nil {
+			return maskAny(err)
+		}
+		for _, key := range keys {
+			line := knownhosts.Line([]string{knownhosts.Normalize(addr)}, key)
+			if !known[line] {
+				known[line] = true
+				added = append(added, line)
+			}
+		}
+		log.Infof("Collected host keys of %s", m.ClusterIP)
+	}
+	if len(added) == 0 {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(knownHostsFile), 0700); err != nil {
+		return maskAny(err)
+	}
+	f, err := os.OpenFile(knownHostsFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return maskAny(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(strings.Join(added, "\n") + "\n"); err != nil {
+		return maskAny(err)
+	}
+	log.Infof("Added %d host keys to %s", len(added), knownHostsFile)
+	return nil
+}
+
+// scanHostKeys fetches the host keys of the given address, one handshake per key type.
+// Key types that the host does not offer are skipped.
+func scanHostKeys(addr string, timeout time.Duration) ([]ssh.PublicKey, error) {
+	var keys []ssh.PublicKey
+	var lastErr error
+	for _, algorithm := range keyscanAlgorithms {
+		var key ssh.PublicKey
+		config := &ssh.ClientConfig{
+			User:              "gluon-keyscan",
+			HostKeyAlgorithms: []string{algorithm},
+			Timeout:           timeout,
+			HostKeyCallback: func(hostname string, remote net.Addr, k ssh.PublicKey) error {
+				key = k
+				// Abort the handshake, there is no need to authenticate
+				return errHostKeyCollected
+			},
+		}
+		conn, err := net.DialTimeout("tcp", addr, timeout)
+		if err != nil {
+			return nil, maskAny(fmt.Errorf("Cannot connect to %s: %v", addr, err))
+		}
+		if client, err := handshake(conn, addr, config); err == nil {
+			client.Close()
+		} else if key == nil {
+			lastErr = err
+		}
+		if key != nil {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, maskAny(fmt.Errorf("No host keys found for %s: %v", addr, errgo.Cause(lastErr)))
+	}
+	return keys, nil
+}
@@ -0,0 +1,244 @@
+package update
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/juju/errgo"
+	logging "github.com/op/go-logging"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/pulcy/gluon/service"
+)
+
+// testCommandHandler fakes the execution of a command on a test SSH server.
+type testCommandHandler func(command, stdin string) (stdout, stderr string, status int)
+
+// testSSHServer is an in-process SSH server that runs commands with its handler
+// & forwards TCP connections (so it can be used as jump host).
+type testSSHServer struct {
+	listener net.Listener
+	config   *ssh.ServerConfig
+	hostKey  ssh.Signer
+	handler  testCommandHandler
+}
+
+func newTestSSHServer(t *testing.T, clientKey ssh.PublicKey, handler testCommandHandler) *testSSHServer {
+	hostKey, err := newTestSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if conn.User() == "core" && string(key.Marshal()) == string(clientKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("unknown key for %s", conn.User())
+		},
+	}
+	config.AddHostKey(hostKey)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testSSHServer{listener: listener, config: config, hostKey: hostKey, handler: handler}
+	go s.serve()
+	return s
+}
+
+func (s *testSSHServer) Addr() string { return s.listener.Addr().String() }
+
+func (s *testSSHServer) Close() { s.listener.Close() }
+
+func (s *testSSHServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			_, chans, reqs, err := ssh.NewServerConn(conn, s.config)
+			if err != nil {
+				conn.Close()
+				return
+			}
+			go ssh.DiscardRequests(reqs)
+			for newChannel := range chans {
+				switch newChannel.ChannelType() {
+				case "session":
+					go s.handleSession(newChannel)
+				case "direct-tcpip":
+					go handleTestForward(newChannel)
+				default:
+					newChannel.Reject(ssh.UnknownChannelType, "unsupported")
+				}
+			}
+		}()
+	}
+}
+
+// handleSession runs an exec request with the handler of the server.
+func (s *testSSHServer) handleSession(newChannel ssh.NewChannel) {
+	channel, reqs, err := newChannel.Accept()
+	if err != nil {
+		return
+	}
+	defer channel.Close()
+	for req := range reqs {
+		if req.Type != "exec" {
+			req.Reply(false, nil)
+			continue
+		}
+		var payload struct{ Command string }
+		ssh.Unmarshal(req.Payload, &payload)
+		req.Reply(true, nil)
+		stdin, _ := ioutil.ReadAll(channel)
+		stdout, stderr, status := s.handler(payload.Command, string(stdin))
+		io.WriteString(channel, stdout)
+		io.WriteString(channel.Stderr(), stderr)
+		channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{uint32(status)}))
+		return
+	}
+}
+
+// handleTestForward connects a direct-tcpip channel to the requested address.
+func handleTestForward(newChannel ssh.NewChannel) {
+	var payload struct {
+		Host     string
+		Port     uint32
+		OrigHost string
+		OrigPort uint32
+	}
+	if err := ssh.Unmarshal(newChannel.ExtraData(), &payload); err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	conn, err := net.Dial("tcp", net.JoinHostPort(payload.Host, fmt.Sprint(payload.Port)))
+	if err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	channel, reqs, err := newChannel.Accept()
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	go func() {
+		io.Copy(conn, channel)
+		conn.Close()
+	}()
+	io.Copy(channel, conn)
+	channel.Close()
+}
+
+func newTestSigner() (ssh.Signer, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	return ssh.NewSignerFromKey(key)
+}
+
+// newTestClientKey writes a new client key into the given directory.
+// It returns the path of the key file & its public key.
+func newTestClientKey(t *testing.T, dir string) (string, ssh.PublicKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(dir, "id_rsa")
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if err := ioutil.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	pub, err := ssh.NewPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keyFile, pub
+}
+
+func TestSSHRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ssh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	keyFile, clientPub := newTestClientKey(t, dir)
+	server := newTestSSHServer(t, clientPub, func(command, stdin string) (string, string, int) {
+		switch command {
+		case "cat":
+			return stdin, "", 0
+		case "fail":
+			return "", "boom\n", 3
+		case "sleep":
+			time.Sleep(time.Second * 5)
+			return "", "", 0
+		}
+		return "", "", 127
+	})
+	defer server.Close()
+
+	knownHostsFile := filepath.Join(dir, "known_hosts")
+	log := logging.MustGetLogger("test")
+	members := []service.ClusterMember{{ClusterIP: server.Addr()}}
+	if err := CollectHostKeys(members, knownHostsFile, log); err != nil {
+		t.Fatalf("CollectHostKeys failed: %v", err)
+	}
+	expectedLine := knownhosts.Line([]string{knownhosts.Normalize(server.Addr())}, server.hostKey.PublicKey())
+	if content, _ := ioutil.ReadFile(knownHostsFile); strings.TrimSpace(string(content)) != expectedLine {
+		t.Fatalf("Unexpected known hosts '%s'", content)
+	}
+
+	opts := SSHOptions{KeyFile: keyFile, KnownHostsFile: knownHostsFile}
+	opts.SetupDefaults()
+	for _, jumpHost := range []string{"", "core@" + server.Addr()} {
+		opts.JumpHost = jumpHost
+		if out, err := opts.run(server.Addr(), "cat", "hello\n", time.Second*5); err != nil || out != "hello" {
+			t.Errorf("Expected 'hello' (jump host '%s'), got '%s' %v", jumpHost, out, err)
+		}
+	}
+	opts.JumpHost = ""
+
+	expectError := func(command string, timeout time.Duration, exitCode int, stderr, reason string) {
+		_, err := opts.run(server.Addr(), command, "", timeout)
+		rerr, ok := errgo.Cause(err).(*RemoteCommandError)
+		if !ok {
+			t.Fatalf("Expected RemoteCommandError for '%s', got %v", command, err)
+		}
+		if rerr.ExitCode != exitCode || rerr.Stderr != stderr || !strings.HasPrefix(rerr.Reason, reason) {
+			t.Errorf("Unexpected error for '%s': %#v", command, rerr)
+		}
+	}
+	expectError("fail", time.Second*5, 3, "boom", "exit code 3")
+	expectError("sleep", time.Millisecond*100, -1, "", "timeout after")
+
+	// A changed host key must be refused
+	otherKey, err := newTestSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherLine := knownhosts.Line([]string{knownhosts.Normalize(server.Addr())}, otherKey.PublicKey())
+	if err := ioutil.WriteFile(knownHostsFile, []byte(otherLine+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	expectError("cat", time.Second*5, -1, "", "host key has changed")
+	opts.InsecureSkipHostKeyCheck = true
+	if _, err := opts.run(server.Addr(), "cat", "", time.Second*5); err != nil {
+		t.Errorf("Expected host key check to be skipped, got %v", err)
+	}
+}
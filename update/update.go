@@ -2,31 +2,35 @@ package update
 
 import (
 	"bufio"
-	"bytes"
 	"fmt"
 	"os"
-	"os/exec"
-	"strings"
 	"time"
 
+	"github.com/juju/errgo"
 	"golang.org/x/sync/errgroup"
 
-	"github.com/juju/errgo"
 	logging "github.com/op/go-logging"
 	"github.com/pulcy/gluon/service"
+	"github.com/pulcy/gluon/util"
 )
 
 type UpdateFlags struct {
 	service.ServiceFlags
 	MachineDelay    time.Duration
 	RebootExpired   time.Duration
-	UserName        string
+	SSH             SSHOptions
+	HealthGates     []string      // Names of the health gates a machine must pass before the next one is updated
+	HealthTimeout   time.Duration // Maximum time a machine may take to pass all health gates
+	JournalDir      string        // Directory containing the journals of update runs
+	Policy          BatchPolicy
+	Rollback        bool // If set, the previous image is restored on a machine that fails to update
+	RollbackAll     bool // If set, all machines updated in this run are rolled back when a machine fails to update
 	Reboot          bool
 	AskConfirmation bool
 }
 
 func (flags *UpdateFlags) SetupDefaults(log *logging.Logger) error {
-	if err := flags.ServiceFlags.SetupDefaults(log); err != nil {
+	if err := flags.ServiceFlags.SetupDefaults(util.NewTarget(log, "", nil)); err != nil {
 		return maskAny(err)
 	}
 	if flags.MachineDelay == 0 {
@@ -35,12 +39,27 @@ func (flags *UpdateFlags) SetupDefaults(log *logging.Logger) error {
 	if flags.RebootExpired == 0 {
 		flags.RebootExpired = time.Minute * 2
 	}
-	if flags.UserName == "" {
-		flags.UserName = "core"
+	flags.SSH.SetupDefaults()
+	if flags.JournalDir == "" {
+		flags.JournalDir = DefaultJournalDir()
+	}
+	if flags.HealthTimeout == 0 {
+		flags.HealthTimeout = defaultHealthTimeout
+	}
+	if err := validateHealthGates(flags.HealthGates); err != nil {
+		return maskAny(err)
+	}
+	if err := flags.Policy.validate(); err != nil {
+		return maskAny(err)
+	}
+	if flags.AskConfirmation && flags.Policy.batched() {
+		return maskAny(fmt.Errorf("Confirmation cannot be combined with batches"))
 	}
 	return nil
 }
 
+// UpdateAllMachines updates gluon on all cluster members, in the order given by the batch policy.
+// The progress is recorded in a journal, so the update can be resumed when it is interrupted.
 func UpdateAllMachines(flags *UpdateFlags, log *logging.Logger) error {
 	// Get all members
 	members, err := flags.GetClusterMembers(log)
@@ -48,6 +67,58 @@ func UpdateAllMachines(flags *UpdateFlags, log *logging.Logger) error {
 		return maskAny(err)
 	}
 
+	journal := newJournal(flags.JournalDir, *flags, members)
+	if err := journal.save(); err != nil {
+		return maskAny(err)
+	}
+	log.Infof("Recording progress in %s", journal.Path())
+	return maskAny(updateMachines(flags, journal, members, log))
+}
+
+// ResumeUpdate continues the most recent update run, starting at the first member
+// that has not been updated successfully.
+func ResumeUpdate(flags *UpdateFlags, log *logging.Logger) error {
+	journal, err := LoadLatestJournal(flags.JournalDir)
+	if err != nil {
+		return maskAny(err)
+	}
+	if journal == nil {
+		return maskAny(fmt.Errorf("No update found in %s", flags.JournalDir))
+	}
+	if journal.Complete() {
+		return maskAny(fmt.Errorf("Update %s to %s is already complete", journal.ID, journal.GluonImage))
+	}
+	flags.GluonImage = journal.GluonImage
+	flags.Reboot = journal.Reboot
+
+	// Find the remaining members in the current list of cluster members
+	members, err := flags.GetClusterMembers(log)
+	if err != nil {
+		return maskAny(err)
+	}
+	var remaining []service.ClusterMember
+	for _, jm := range journal.Members {
+		if jm.Status == MemberDone {
+			continue
+		}
+		found := false
+		for _, m := range members {
+			if m.ClusterIP == jm.ClusterIP {
+				remaining = append(remaining, m)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return maskAny(fmt.Errorf("Member %s of update %s is no longer a cluster member", jm.ClusterIP, journal.ID))
+		}
+	}
+	log.Infof("Resuming update %s to %s on %d machines", journal.ID, journal.GluonImage, len(remaining))
+	return maskAny(updateMachines(flags, journal, remaining, log))
+}
+
+// updateMachines updates the given members, recording the progress in the given journal.
+func updateMachines(flags *UpdateFlags, journal *Journal, members []service.ClusterMember, log *logging.Logger) error {
 	// Pull image on all machines
 	log.Infof("Pulling gluon image on %d machines", len(members))
 	var pullGroup errgroup.Group
@@ -61,57 +132,126 @@ func UpdateAllMachines(flags *UpdateFlags, log *logging.Logger) error {
 		return maskAny(err)
 	}
 
-	// Update all machines, one at a time
-	for index, m := range members {
+	// Update all machines, batch by batch
+	batches, canaries := flags.Policy.planBatches(members)
+	for index, batch := range batches {
 		if index > 0 {
+			if index == canaries {
+				if err := confirm(fmt.Sprintf("%d canaries updated, continue with the remaining machines?", canaries)); err != nil {
+					return maskAny(err)
+				}
+			}
 			log.Infof("Waiting %s...", flags.MachineDelay)
 			time.Sleep(flags.MachineDelay)
 		}
-		if err := updateMachine(m, *flags, log); err != nil {
+		if len(batch) > 1 {
+			log.Infof("Updating batch of %d machines", len(batch))
+		}
+		var batchGroup errgroup.Group
+		for _, m := range batch {
+			m := m
+			batchGroup.Go(func() error {
+				return maskAny(updateJournaledMachine(m, flags, journal, log))
+			})
+		}
+		if err := batchGroup.Wait(); err != nil {
+			if flags.RollbackAll {
+				if rerr := rollbackUpdatedMachines(flags, journal, log); rerr != nil {
+					log.Errorf("%v", rerr)
+				}
+			}
+			log.Errorf("Update failed, continue with `gluon update --resume` after fixing it")
 			return maskAny(err)
 		}
 	}
 
-	return nil
+	return maskAny(journal.finish())
+}
+
+// updateJournaledMachine updates the given member, recording the progress in the given journal.
+// If the update fails, the previous image is restored when rollback is enabled.
+func updateJournaledMachine(member service.ClusterMember, flags *UpdateFlags, journal *Journal, log *logging.Logger) error {
+	// Remember the current image, so the update can be rolled back
+	var previousImage string
+	if image, err := runRemoteCommand(member, flags.SSH, log, currentImageCommand, "", true); err == nil {
+		previousImage = journal.setPreviousImage(member.ClusterIP, image)
+	}
+	if err := journal.setStatus(member.ClusterIP, MemberUpdating, nil); err != nil {
+		return maskAny(err)
+	}
+	if err := updateMachine(member, *flags, log); err != nil {
+		if jerr := journal.setStatus(member.ClusterIP, MemberFailed, errgo.Cause(err)); jerr != nil {
+			log.Errorf("Failed to record progress: %v", jerr)
+		}
+		log.Errorf("Update of %s failed", member.ClusterIP)
+		if flags.Rollback && previousImage != flags.GluonImage {
+			if rerr := rollbackMachine(member, previousImage, *flags, log); rerr != nil {
+				log.Errorf("Rollback of %s failed: %v", member.ClusterIP, rerr)
+			} else if jerr := journal.setStatus(member.ClusterIP, MemberRolledBack, nil); jerr != nil {
+				log.Errorf("Failed to record progress: %v", jerr)
+			}
+		}
+		return maskAny(err)
+	}
+	return maskAny(journal.setStatus(member.ClusterIP, MemberDone, nil))
 }
 
 func pullImage(member service.ClusterMember, flags UpdateFlags, log *logging.Logger) error {
 	cmd := fmt.Sprintf("docker pull %s", flags.GluonImage)
-	if _, err := runRemoteCommand(member, flags.UserName, log, cmd, "", false); err != nil {
+	if _, err := runRemoteCommand(member, flags.SSH, log, cmd, "", false); err != nil {
 		return maskAny(err)
 	}
 	return nil
 }
 
-func updateMachine(member service.ClusterMember, flags UpdateFlags, log *logging.Logger) error {
-	askConfirmation := flags.AskConfirmation
-	log.Infof("Updating %s...", member.ClusterIP)
-
+// installImage extracts the gluon binary from the image in the given flags on the given member,
+// records the image and runs setup with the new binary.
+func installImage(member service.ClusterMember, flags UpdateFlags, log *logging.Logger) error {
 	// Extract gluon binary
 	cmd := fmt.Sprintf("docker run --rm -v /home/core/bin/:/destination/ %s", flags.GluonImage)
-	if _, err := runRemoteCommand(member, flags.UserName, log, cmd, "", false); err != nil {
+	if _, err := runRemoteCommand(member, flags.SSH, log, cmd, "", false); err != nil {
 		return maskAny(err)
 	}
 
 	// Update image version on disk
-	if _, err := runRemoteCommand(member, flags.UserName, log, "sudo tee /etc/pulcy/gluon-image", flags.GluonImage, false); err != nil {
+	if _, err := runRemoteCommand(member, flags.SSH, log, "sudo tee /etc/pulcy/gluon-image", flags.GluonImage, false); err != nil {
 		return maskAny(err)
 	}
 
 	// Setup new gluon version
-	if _, err := runRemoteCommand(member, flags.UserName, log, "sudo systemctl restart gluon", "", false); err != nil {
+	if _, err := runRemoteCommand(member, flags.SSH, log, "sudo systemctl restart gluon", "", false); err != nil {
+		return maskAny(err)
+	}
+	return nil
+}
+
+func updateMachine(member service.ClusterMember, flags UpdateFlags, log *logging.Logger) error {
+	askConfirmation := flags.AskConfirmation
+	log.Infof("Updating %s...", member.ClusterIP)
+
+	if err := installImage(member, flags, log); err != nil {
 		return maskAny(err)
 	}
+	if !flags.Reboot {
+		if err := waitForHealthGates(member, flags, log); err != nil {
+			return maskAny(err)
+		}
+	}
 
 	// Reboot if needed
 	if flags.Reboot {
 		log.Infof("Rebooting %s...", member.ClusterIP)
-		runRemoteCommand(member, flags.UserName, log, "sudo reboot -f", "", true)
+		runRemoteCommand(member, flags.SSH, log, "sudo reboot -f", "", true)
 		time.Sleep(time.Second * 15)
 		if err := waitUntilMachineUp(member, flags, log); err != nil {
 			return maskAny(err)
 		}
-		if !member.EtcdProxy {
+		if len(flags.HealthGates) > 0 {
+			log.Infof("Machine %s is back up, checking health gates", member.ClusterIP)
+			if err := waitForHealthGates(member, flags, log); err != nil {
+				return maskAny(err)
+			}
+		} else if member.HasRole(service.RoleEtcd) {
 			log.Warningf("Core machine %s is back up, check services", member.ClusterIP)
 			askConfirmation = true
 		} else {
@@ -126,33 +266,10 @@ func updateMachine(member service.ClusterMember, flags UpdateFlags, log *logging
 	return nil
 }
 
-func runRemoteCommand(member service.ClusterMember, userName string, log *logging.Logger, command, stdin string, quiet bool) (string, error) {
-	hostAddress := member.ClusterIP
-	cmd := exec.Command("ssh", "-o", "UserKnownHostsFile=/dev/null", "-o", "StrictHostKeyChecking=no", userName+"@"+hostAddress, command)
-	var stdOut, stdErr bytes.Buffer
-	cmd.Stdout = &stdOut
-	cmd.Stderr = &stdErr
-
-	if stdin != "" {
-		cmd.Stdin = strings.NewReader(stdin)
-	}
-
-	if err := cmd.Run(); err != nil {
-		if !quiet {
-			log.Errorf("SSH failed: %s %s", cmd.Path, strings.Join(cmd.Args, " "))
-		}
-		return "", errgo.NoteMask(err, stdErr.String())
-	}
-
-	out := stdOut.String()
-	out = strings.TrimSuffix(out, "\n")
-	return out, nil
-}
-
 func waitUntilMachineUp(member service.ClusterMember, flags UpdateFlags, log *logging.Logger) error {
 	start := time.Now()
 	for {
-		if _, err := runRemoteCommand(member, flags.UserName, log, "cat /etc/machine-id", "", true); err == nil {
+		if _, err := runRemoteCommand(member, flags.SSH, log, "cat /etc/machine-id", "", true); err == nil {
 			return nil
 		}
 		if time.Since(start) > flags.RebootExpired {
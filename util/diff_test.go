@@ -0,0 +1,36 @@
+// Copyright (c) 2017 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import "testing"
+
+// Test UnifiedDiff creates a unified diff of 2 file contents.
+func TestUnifiedDiff(t *testing.T) {
+	old := "a\nb\nc\nd\ne\nf\ng\nh\n"
+	new := "a\nb\nc\nd\nE\nf\ng\nh\ni\n"
+	diff := UnifiedDiff("x", "x", []byte(old), []byte(new))
+	expected := "--- x\n+++ x\n@@ -2,7 +2,8 @@\n b\n c\n d\n-e\n+E\n f\n g\n h\n+i\n"
+	if diff != expected {
+		t.Errorf("Expected '%s', got '%s'", expected, diff)
+	}
+	if diff := UnifiedDiff("x", "x", []byte(old), []byte(old)); diff != "" {
+		t.Errorf("Expected empty diff, got '%s'", diff)
+	}
+	diff = UnifiedDiff("x", "x", nil, []byte("a\n"))
+	expected = "--- x\n+++ x\n@@ -0,0 +1 @@\n+a\n"
+	if diff != expected {
+		t.Errorf("Expected '%s', got '%s'", expected, diff)
+	}
+}
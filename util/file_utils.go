@@ -21,8 +21,6 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
-
-	logging "github.com/op/go-logging"
 )
 
 // EnsureDirectoryOf checks if the directory of the given file path exists and if not creates it.
@@ -52,9 +50,14 @@ func EnsureDirectory(dirPath string, perm os.FileMode) error {
 // if the content is different, the file is updated.
 // If the file does not exist, it is created.
 // Returns: true if the file is created or updated, false otherwise.
-func UpdateFile(log *logging.Logger, filePath string, content []byte, perm os.FileMode) (bool, error) {
-	if err := EnsureDirectoryOf(filePath, perm); err != nil {
-		return false, maskAny(err)
+func (t *Target) UpdateFile(filePath string, content []byte, perm os.FileMode) (bool, error) {
+	filePath = t.Path(filePath)
+	if t.DryRun() {
+		t.Plan.AddFile(filePath)
+	} else {
+		if err := EnsureDirectoryOf(filePath, perm); err != nil {
+			return false, maskAny(err)
+		}
 	}
 	notFound := false
 	var oldContent []byte
@@ -73,19 +76,26 @@ func UpdateFile(log *logging.Logger, filePath string, content []byte, perm os.Fi
 	}
 	if !notFound && bytes.Equal(oldContent, content) {
 		// No need to make changes, but check filemode
-		if info.Mode() != perm {
-			if err := os.Chmod(filePath, perm); err != nil {
-				return false, maskAny(err)
-			}
-		}
-		return false, nil
+		return false, maskAny(t.chmod(filePath, info.Mode(), perm))
 	}
 	// Not found or content changed, update it
-	log.Debugf("updating %s", filePath)
-	if err := ioutil.WriteFile(filePath, content, perm); err != nil {
-		return true, maskAny(err)
+	if t.DryRun() {
+		if notFound {
+			t.Plan.Add(Action{Kind: ActionCreateFile, Target: filePath, NewMode: perm})
+			return true, nil
+		}
+		t.Plan.Add(Action{Kind: ActionChangeFile, Target: filePath, Diff: UnifiedDiff(filePath, filePath, oldContent, content)})
+	} else {
+		t.Logger.Debugf("updating %s", filePath)
+		if err := ioutil.WriteFile(filePath, content, perm); err != nil {
+			return true, maskAny(err)
+		}
+		if notFound {
+			return true, nil
+		}
 	}
-	return true, nil
+	// WriteFile does not change the mode of an existing file
+	return true, maskAny(t.chmod(filePath, info.Mode(), perm))
 }
 
 type KeyValuePair struct {
@@ -96,21 +106,30 @@ type KeyValuePair struct {
 // AppendEnvironmentFile ensures that all given key-value pairs are up to date in the given file.
 // If the file does not exist, it is created.
 // Returns: true if the file is created or updated, false otherwise.
-func AppendEnvironmentFile(filePath string, kv []KeyValuePair, perm os.FileMode) (bool, error) {
-	if err := EnsureDirectoryOf(filePath, perm); err != nil {
-		return false, maskAny(err)
+func (t *Target) AppendEnvironmentFile(filePath string, kv []KeyValuePair, perm os.FileMode) (bool, error) {
+	filePath = t.Path(filePath)
+	if t.DryRun() {
+		t.Plan.AddFile(filePath)
+	} else {
+		if err := EnsureDirectoryOf(filePath, perm); err != nil {
+			return false, maskAny(err)
+		}
 	}
 	updateNeeded := false
+	notFound := false
+	var oldContentRaw []byte
 	var oldContent []string
 	info, err := os.Stat(filePath)
 	if os.IsNotExist(err) {
 		updateNeeded = true
+		notFound = true
 	} else if err != nil {
 		return false, maskAny(err)
 	} else {
-		oldContentRaw, err := ioutil.ReadFile(filePath)
+		oldContentRaw, err = ioutil.ReadFile(filePath)
 		if os.IsNotExist(err) {
 			updateNeeded = true
+			notFound = true
 		} else if err != nil {
 			return false, maskAny(err)
 		} else {
@@ -146,15 +165,18 @@ func AppendEnvironmentFile(filePath string, kv []KeyValuePair, perm os.FileMode)
 
 	if !updateNeeded {
 		// No need to make changes, but check filemode
-		if info.Mode() != perm {
-			if err := os.Chmod(filePath, perm); err != nil {
-				return false, maskAny(err)
-			}
-		}
-		return false, nil
+		return false, maskAny(t.chmod(filePath, info.Mode(), perm))
 	}
 	// Not found or content changed, update it
 	newContent := strings.Join(oldContent, "\n")
+	if t.DryRun() {
+		if notFound {
+			t.Plan.Add(Action{Kind: ActionCreateFile, Target: filePath, NewMode: perm})
+		} else {
+			t.Plan.Add(Action{Kind: ActionChangeFile, Target: filePath, Diff: UnifiedDiff(filePath, filePath, oldContentRaw, []byte(newContent))})
+		}
+		return true, nil
+	}
 	if err := ioutil.WriteFile(filePath, []byte(newContent), perm); err != nil {
 		return true, maskAny(err)
 	}
@@ -0,0 +1,58 @@
+// Copyright (c) 2017 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/op/go-logging"
+)
+
+// Test UpdateFile changes the mode of an existing file when its content changes,
+// both in a dry-run and for real.
+func TestUpdateFileMode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "util")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(path, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	log := logging.MustGetLogger("test")
+
+	plan := NewPlan()
+	if changed, err := NewTarget(log, "", plan).UpdateFile(path, []byte("new"), 0600); err != nil || !changed {
+		t.Fatalf("Expected a change, got %v %v", changed, err)
+	}
+	kinds := []ActionKind{}
+	for _, a := range plan.Actions() {
+		kinds = append(kinds, a.Kind)
+	}
+	if len(kinds) != 2 || kinds[0] != ActionChangeFile || kinds[1] != ActionChangeMode {
+		t.Errorf("Expected change file & mode actions, got %v", kinds)
+	}
+
+	if changed, err := NewTarget(log, "", nil).UpdateFile(path, []byte("new"), 0600); err != nil || !changed {
+		t.Fatalf("Expected a change, got %v %v", changed, err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Expected mode 0600, got %v %v", info, err)
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright (c) 2017 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+type ActionKind string
+
+const (
+	ActionCreateFile  ActionKind = "create"
+	ActionChangeFile  ActionKind = "change"
+	ActionChangeMode  ActionKind = "chmod"
+	ActionRemoveFile  ActionKind = "remove"
+	ActionRunCommand  ActionKind = "run"
+	ActionReload      ActionKind = "reload"
+	ActionEnableUnit  ActionKind = "enable"
+	ActionDisableUnit ActionKind = "disable"
+	ActionStartUnit   ActionKind = "start"
+	ActionRestartUnit ActionKind = "restart"
+	ActionStopUnit    ActionKind = "stop"
+)
+
+// Action is a single change that gluon would make to the system.
+type Action struct {
+	Kind    ActionKind
+	Target  string      // Path of a file, name of a unit or command line
+	Diff    string      // Unified diff of file changes (ActionChangeFile only)
+	OldMode os.FileMode // Original file mode (ActionChangeMode only)
+	NewMode os.FileMode // New file mode (ActionCreateFile, ActionChangeMode only)
+}
+
+// String returns a single line description of the action.
+func (a Action) String() string {
+	switch a.Kind {
+	case ActionCreateFile:
+		return fmt.Sprintf("%-8s %s (%#o)", a.Kind, a.Target, a.NewMode.Perm())
+	case ActionChangeMode:
+		return fmt.Sprintf("%-8s %s (%#o -> %#o)", a.Kind, a.Target, a.OldMode.Perm(), a.NewMode.Perm())
+	case ActionReload:
+		return string(a.Kind)
+	default:
+		return fmt.Sprintf("%-8s %s", a.Kind, a.Target)
+	}
+}
+
+// Plan collects the actions of a dry-run.
+type Plan struct {
+	mutex   sync.Mutex
+	actions []Action
+	files   []string
+}
+
+// NewPlan creates a new, empty plan.
+func NewPlan() *Plan {
+	return &Plan{}
+}
+
+// Add appends the given action to the plan.
+func (p *Plan) Add(a Action) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.actions = append(p.actions, a)
+}
+
+// AddFile records the path of a file that is managed by gluon, whether it changes or not.
+func (p *Plan) AddFile(path string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for _, x := range p.files {
+		if x == path {
+			return
+		}
+	}
+	p.files = append(p.files, path)
+}
+
+// Files returns the paths of all managed files recorded so far.
+func (p *Plan) Files() []string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return append([]string(nil), p.files...)
+}
+
+// Actions returns a copy of all actions collected so far.
+func (p *Plan) Actions() []Action {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return append([]Action(nil), p.actions...)
+}
+
+// Print writes a human readable version of the plan to the given writer.
+func (p *Plan) Print(w io.Writer) {
+	actions := p.Actions()
+	if len(actions) == 0 {
+		fmt.Fprintln(w, "No changes")
+		return
+	}
+	for _, a := range actions {
+		fmt.Fprintln(w, a.String())
+		if a.Diff != "" {
+			for _, line := range strings.Split(strings.TrimSuffix(a.Diff, "\n"), "\n") {
+				fmt.Fprintf(w, "    %s\n", line)
+			}
+		}
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright (c) 2017 Pulcy.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	logging "github.com/op/go-logging"
+)
+
+// Target is the system that is being configured.
+// All file access and all commands go through a Target, so
+// they can be redirected to another root directory, or
+// recorded in a Plan instead of being executed.
+type Target struct {
+	Logger *logging.Logger
+	Root   string // If set, all paths are relative to this directory (e.g. a mounted image)
+	Plan   *Plan  // If set, changes are added to this plan instead of being made (dry-run)
+}
+
+// NewTarget creates a new target for the system found at the given root directory.
+// An empty root means the local system.
+// If the given plan is non-nil, the target will only record changes in it.
+func NewTarget(log *logging.Logger, root string, plan *Plan) *Target {
+	return &Target{
+		Logger: log,
+		Root:   root,
+		Plan:   plan,
+	}
+}
+
+// DryRun returns true if changes are recorded instead of being made.
+func (t *Target) DryRun() bool {
+	return t.Plan != nil
+}
+
+// IsOffline returns true if the target is not the running system.
+func (t *Target) IsOffline() bool {
+	return t.Root != "" && t.Root != "/"
+}
+
+// Path returns the actual path of the given absolute path on the target.
+func (t *Target) Path(path string) string {
+	if !t.IsOffline() {
+		return path
+	}
+	return filepath.Join(t.Root, path)
+}
+
+// ReadFile reads the file at the given path.
+// Errors are not masked, so os.IsNotExist can be used on them.
+func (t *Target) ReadFile(filePath string) ([]byte, error) {
+	return ioutil.ReadFile(t.Path(filePath))
+}
+
+// ReadDir reads the directory at the given path and returns a list of its entries sorted by name.
+// Errors are not masked, so os.IsNotExist can be used on them.
+func (t *Target) ReadDir(dirPath string) ([]os.FileInfo, error) {
+	return ioutil.ReadDir(t.Path(dirPath))
+}
+
+// Stat returns information about the file at the given path.
+// Errors are not masked, so os.IsNotExist can be used on them.
+func (t *Target) Stat(path string) (os.FileInfo, error) {
+	return os.Stat(t.Path(path))
+}
+
+// Readlink returns the destination of the symbolic link at the given path.
+// Errors are not masked, so os.IsNotExist can be used on them.
+func (t *Target) Readlink(path string) (string, error) {
+	return os.Readlink(t.Path(path))
+}
+
+// EnsureDirectory checks if a directory with given path exists and if not creates it.
+func (t *Target) EnsureDirectory(dirPath string, perm os.FileMode) error {
+	if t.DryRun() {
+		return nil
+	}
+	return maskAny(EnsureDirectory(t.Path(dirPath), perm))
+}
+
+// EnsureDirectoryOf checks if the directory of the given file path exists and if not creates it.
+func (t *Target) EnsureDirectoryOf(filePath string, perm os.FileMode) error {
+	if t.DryRun() {
+		return nil
+	}
+	return maskAny(EnsureDirectoryOf(t.Path(filePath), perm))
+}
+
+// Remove removes the file at the given path.
+// It is not an error if the file does not exist.
+func (t *Target) Remove(filePath string) error {
+	return maskAny(t.remove(filePath, os.Remove))
+}
+
+// RemoveAll removes the given path and any children it contains.
+func (t *Target) RemoveAll(path string) error {
+	return maskAny(t.remove(path, os.RemoveAll))
+}
+
+func (t *Target) remove(path string, remove func(string) error) error {
+	path = t.Path(path)
+	if _, err := os.Lstat(path); os.IsNotExist(err) {
+		return nil
+	}
+	if t.DryRun() {
+		t.Plan.Add(Action{Kind: ActionRemoveFile, Target: path})
+		return nil
+	}
+	t.Logger.Debugf("removing %s", path)
+	if err := remove(path); err != nil && !os.IsNotExist(err) {
+		return maskAny(err)
+	}
+	return nil
+}
+
+// Symlink creates newname as a symbolic link to oldname.
+// Oldname is not changed, since the link is resolved on the target itself.
+func (t *Target) Symlink(oldname, newname string) error {
+	newname = t.Path(newname)
+	if t.DryRun() {
+		t.Plan.Add(Action{Kind: ActionCreateFile, Target: newname + " -> " + oldname, NewMode: os.ModeSymlink | 0777})
+		return nil
+	}
+	return maskAny(os.Symlink(oldname, newname))
+}
+
+// Run executes the given command and returns its combined output.
+// In a dry-run the command is only added to the plan.
+// Commands are never executed on an offline target, since they affect
+// the running system. Gluon runs them when the target boots.
+func (t *Target) Run(name string, args ...string) ([]byte, error) {
+	cmdLine := strings.Join(append([]string{name}, args...), " ")
+	if t.DryRun() {
+		t.Plan.Add(Action{Kind: ActionRunCommand, Target: cmdLine})
+		return nil, nil
+	}
+	if t.IsOffline() {
+		t.Logger.Warningf("skipping '%s' on offline target %s", cmdLine, t.Root)
+		return nil, nil
+	}
+	cmd := exec.Command(name, args...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return out, maskAny(err)
+	}
+	return out, nil
+}
+
+// chmod changes the mode of the given file to perm if that differs from its current mode.
+func (t *Target) chmod(filePath string, current, perm os.FileMode) error {
+	if current == perm {
+		return nil
+	}
+	if t.DryRun() {
+		t.Plan.Add(Action{Kind: ActionChangeMode, Target: filePath, OldMode: current, NewMode: perm})
+		return nil
+	}
+	return maskAny(os.Chmod(filePath, perm))
+}